@@ -0,0 +1,100 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Struct to represent the address an order is delivered to
+type ShippingAddress struct {
+	Name       string `json:"name" validate:"required"`
+	Phone      string `json:"phone" validate:"omitempty,e164"`
+	Address    string `json:"address" validate:"required"`
+	City       string `json:"city" validate:"required"`
+	PostalCode string `json:"postal_code"`
+	Region     string `json:"region"`
+	Country    string `json:"country" validate:"required,country"`
+}
+
+// Struct to represent the address rules of a country
+type AddressFormat struct {
+	PostalCode     *regexp.Regexp // nil when the country does not use postal codes
+	RegionRequired bool
+}
+
+// Address rules by country code. Countries not listed only need the common fields.
+var addressFormats = map[string]AddressFormat{
+	"ID": {PostalCode: regexp.MustCompile(`^\d{5}$`)},
+	"SG": {PostalCode: regexp.MustCompile(`^\d{6}$`)},
+	"DE": {PostalCode: regexp.MustCompile(`^\d{5}$`)},
+	"GB": {PostalCode: regexp.MustCompile(`^[A-Z]{1,2}\d[A-Z\d]? ?\d[A-Z]{2}$`)},
+	"US": {PostalCode: regexp.MustCompile(`^\d{5}(-\d{4})?$`), RegionRequired: true},
+	"CA": {PostalCode: regexp.MustCompile(`^[A-Z]\d[A-Z] ?\d[A-Z]\d$`), RegionRequired: true},
+	"HK": {},
+}
+
+// Distribution centers by destination country; other countries ship from the default DC
+var distributionCenters = map[string]string{
+	"ID": "DC-JKT",
+	"SG": "DC-SIN",
+}
+
+// validateShippingAddress checks the address against the rules of its country;
+// the fields every address needs are checked by its `validate` tags. It returns
+// the offending field and a message, or empty strings when valid.
+func validateShippingAddress(address ShippingAddress) (string, string) {
+	format, known := addressFormats[strings.ToUpper(address.Country)]
+	if !known {
+		return "", ""
+	}
+	if format.RegionRequired && address.Region == "" {
+		return "region", "State or province is required for this country"
+	}
+	if format.PostalCode != nil && !format.PostalCode.MatchString(strings.ToUpper(address.PostalCode)) {
+		return "postal_code", "Postal code is not valid for this country"
+	}
+	return "", ""
+}
+
+// shippingAddressFromBilling uses the billing address as the delivery address
+func shippingAddressFromBilling(billing BillingAddress) *ShippingAddress {
+	return &ShippingAddress{
+		Name:       billing.Name,
+		Phone:      billing.Phone,
+		Address:    billing.Address,
+		City:       billing.City,
+		PostalCode: billing.PostalCode,
+		Region:     billing.Region,
+		Country:    billing.Country,
+	}
+}
+
+// deliveryAddress returns the address a delivery order ships to: the requested
+// address, else the customer's saved shipping address, else the billing address.
+// A requested address, already checked against its tags, must also satisfy its
+// country's rules. On failure it returns the API error.
+func deliveryAddress(tenant *Tenant, requested *ShippingAddress, billing BillingAddress) (*ShippingAddress, error) {
+	if requested == nil {
+		if customer, exists := tenant.Customers[billing.CustomerID]; exists && customer.ShippingAddress != nil {
+			saved := *customer.ShippingAddress
+			return &saved, nil
+		}
+		return shippingAddressFromBilling(billing), nil
+	}
+
+	if field, msg := validateShippingAddress(*requested); msg != "" {
+		log.Warn().Str("address.country", requested.Country).Msg(msg)
+		return nil, ErrInvalidShippingAddress.WithMessage(msg).WithTarget("shipping_address." + field)
+	}
+	return requested, nil
+}
+
+// distributionCenterFor returns the DC that serves the destination country
+func distributionCenterFor(address *ShippingAddress) string {
+	if address != nil {
+		if dc, exists := distributionCenters[strings.ToUpper(address.Country)]; exists {
+			return dc
+		}
+	}
+	return defaultDistributionCenter
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test required fields and postal code formats per country
+func TestValidateShippingAddress(t *testing.T) {
+	address := ShippingAddress{Name: "Budi", Address: "Jl. Merdeka 1", City: "Jakarta", PostalCode: "10110", Country: "ID"}
+	field, _ := validateShippingAddress(address)
+	assert.Equal(t, "", field)
+
+	address.PostalCode = "1011"
+	field, _ = validateShippingAddress(address)
+	assert.Equal(t, "postal_code", field)
+
+	// US addresses need a state and a ZIP code
+	us := ShippingAddress{Name: "Ann", Address: "1 Main St", City: "Springfield", PostalCode: "62701-1234", Country: "US"}
+	field, _ = validateShippingAddress(us)
+	assert.Equal(t, "region", field)
+	us.Region = "IL"
+	field, _ = validateShippingAddress(us)
+	assert.Equal(t, "", field)
+
+	gb := ShippingAddress{Name: "Tom", Address: "10 Downing St", City: "London", PostalCode: "sw1a 2aa", Country: "GB"}
+	field, _ = validateShippingAddress(gb)
+	assert.Equal(t, "", field)
+
+	// Countries without postal codes only need the fields every address has
+	hk := ShippingAddress{Name: "Mei", Address: "1 Queen's Rd", City: "Hong Kong", Country: "HK"}
+	field, _ = validateShippingAddress(hk)
+	assert.Equal(t, "", field)
+	assert.Empty(t, validateRequest(hk))
+
+	hk.City = ""
+	hk.Country = "XX"
+	assert.Equal(t, []FieldError{
+		{Field: "city", Reason: "is required"},
+		{Field: "country", Reason: "must be an ISO 3166-1 alpha-2 country code"},
+	}, validateRequest(hk))
+}
+
+// Test shipping a gift to an address other than the billing address
+func TestProcessPaymentWithShippingAddress(t *testing.T) {
+	app := setupApp()
+
+	doJSON(t, app, http.MethodPost, "/create-cart", `{"customer_id": "cust_gift", "items": [{"item_id": "item002", "quantity": 1}]}`)
+
+	payload := `{
+		"order_id": "order_gift",
+		"amount": 65.5,
+		"billing_address": {"customer_id": "cust_gift", "name": "John Doe", "email": "john@example.com", "phone": "+15555555555", "country": "US"},
+		"shipping_address": {"name": "Budi", "address": "Jl. Merdeka 1", "city": "Jakarta", "postal_code": "%s", "country": "ID"}
+	}`
+
+	status, body := doJSON(t, app, http.MethodPost, "/process-payment", fmt.Sprintf(payload, "ABC"))
+	assert.Equal(t, 400, status)
+	errBody := body["error"].(map[string]interface{})
+	assert.Equal(t, "InvalidShippingAddress", errBody["code"])
+	assert.Equal(t, "shipping_address.postal_code", errBody["target"])
+
+	// Shipping is priced to Indonesia (standard, up to 1kg), and the Indonesian
+	// tax applies there rather than the US billing address, which has none
+	status, body = doJSON(t, app, http.MethodPost, "/process-payment", fmt.Sprintf(payload, "10110"))
+	assert.Equal(t, 200, status)
+	order := body["order"].(map[string]interface{})
+	assert.Equal(t, 10.0, order["Totals"].(map[string]interface{})["shipping"])
+	assert.Equal(t, 5.5, order["Totals"].(map[string]interface{})["tax"])
+	assert.Equal(t, 0.11, order["Items"].([]interface{})[0].(map[string]interface{})["tax_rate"])
+	assert.Equal(t, "Jakarta", order["ShippingAddress"].(map[string]interface{})["city"])
+	assert.Equal(t, "John Doe", order["Customer"].(map[string]interface{})["name"])
+
+	// Routed to the DC serving the shipping address
+	status, body = doJSON(t, app, http.MethodPost, "/route-order", `{"order_id": "order_gift"}`)
+	assert.Equal(t, 200, status)
+	fulfillment := body["order"].(map[string]interface{})["Fulfillment"].(map[string]interface{})
+	assert.Equal(t, "DC-JKT", fulfillment["location"])
+}
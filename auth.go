@@ -0,0 +1,329 @@
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// How a client authenticated
+const (
+	AuthAPIKey = "api_key" // static key for service-to-service calls
+	AuthJWT    = "jwt"     // bearer token signed with HS256 or RS256
+)
+
+// Header carrying a static API key
+const apiKeyHeader = "X-API-Key"
+
+// Clock skew allowed when checking token expiry
+const jwtLeeway = time.Minute
+
+// Fiber context key of the authenticated principal
+const principalKey = "principal"
+
+// Struct to represent the authenticated caller of a request
+type Principal struct {
+	ID         string // API key name or token subject
+	Name       string
+	Method     string   // AuthAPIKey or AuthJWT
+	Roles      []string // see rolePermissions
+	CustomerID string   // account a customer token acts for
+	TenantID   string   // storefront the caller is bound to, if any
+}
+
+// Struct to hold the accepted credentials. Authentication is off when none are
+// configured.
+type AuthConfig struct {
+	APIKeys       map[string]string         // client name by API key
+	APIKeyRoles   map[string][]string       // roles by client name
+	APIKeyTenants map[string]string         // tenant by client name, for keys bound to one storefront
+	JWTSecret     []byte                    // HS256 signing secret
+	JWKS          map[string]*rsa.PublicKey // RS256 keys by key ID
+	Issuer        string                    // required "iss" claim, if set
+	Audience      string                    // required "aud" claim, if set
+}
+
+// Routes served without credentials: the API docs, the error catalog, and
+// carrier webhooks, which are signed instead
+var publicRoutes = []string{"/openapi.json", "/docs", "/errors", "/v1/errors", "/webhooks/carriers", "/v1/webhooks/carriers"}
+
+// isPublicRoute reports whether a path is served without credentials
+func isPublicRoute(path string) bool {
+	for _, route := range publicRoutes {
+		if path == route || strings.HasPrefix(path, route+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// loadAuthConfig reads the credentials from the environment:
+// API_KEYS="name=key,name=key" with API_KEY_ROLES="name=role role,name=role"
+// and API_KEY_TENANTS="name=tenant", JWT_SECRET for HS256 tokens,
+// JWT_JWKS_FILE for RS256 tokens, and optionally JWT_ISSUER and JWT_AUDIENCE
+func loadAuthConfig(getenv func(string) string) (*AuthConfig, error) {
+	config := &AuthConfig{
+		APIKeys:       make(map[string]string),
+		APIKeyRoles:   make(map[string][]string),
+		APIKeyTenants: make(map[string]string),
+		JWTSecret:     []byte(getenv("JWT_SECRET")),
+		Issuer:        getenv("JWT_ISSUER"),
+		Audience:      getenv("JWT_AUDIENCE"),
+	}
+	for _, entry := range strings.Split(getenv("API_KEYS"), ",") {
+		name, key, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || name == "" || key == "" {
+			continue
+		}
+		config.APIKeys[key] = name
+	}
+	for _, entry := range strings.Split(getenv("API_KEY_ROLES"), ",") {
+		name, roles, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || name == "" {
+			continue
+		}
+		config.APIKeyRoles[name] = strings.Fields(roles)
+	}
+	for _, entry := range strings.Split(getenv("API_KEY_TENANTS"), ",") {
+		name, tenantID, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || name == "" || tenantID == "" {
+			continue
+		}
+		config.APIKeyTenants[name] = tenantID
+	}
+	if path := getenv("JWT_JWKS_FILE"); path != "" {
+		jwks, err := loadJWKS(path)
+		if err != nil {
+			return nil, err
+		}
+		config.JWKS = jwks
+	}
+	return config, nil
+}
+
+// Struct to represent an RSA key of a JSON Web Key Set
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// loadJWKS reads the RSA signing keys of a JWKS file
+func loadJWKS(path string) (map[string]*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS file %s: %w", path, err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, key := range set.Keys {
+		if key.Kty != "RSA" || (key.Use != "" && key.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(key.N)
+		e, errE := base64.RawURLEncoding.DecodeString(key.E)
+		if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid RSA key %q in %s", key.Kid, path)
+		}
+		keys[key.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no RSA signing keys in %s", path)
+	}
+	return keys, nil
+}
+
+// enabled reports whether any credentials are configured
+func (a *AuthConfig) enabled() bool {
+	return a != nil && (len(a.APIKeys) > 0 || len(a.JWTSecret) > 0 || len(a.JWKS) > 0)
+}
+
+// Authenticate requires an API key or bearer token on every route except the
+// public ones, and stores the caller as the request's principal
+func Authenticate(config *AuthConfig) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !config.enabled() || isPublicRoute(c.Path()) {
+			return c.Next()
+		}
+
+		principal, err := config.authenticate(c)
+		if err != nil {
+			log.Warn().Str("url.path", c.Path()).Msgf("Authentication failed: %v", err)
+			c.Set(fiber.HeaderWWWAuthenticate, `Bearer realm="order-app"`)
+			return err
+		}
+
+		c.Locals(principalKey, principal)
+		return c.Next()
+	}
+}
+
+// authenticate checks the credentials of a request
+func (a *AuthConfig) authenticate(c *fiber.Ctx) (*Principal, error) {
+	if key := c.Get(apiKeyHeader); key != "" {
+		for validKey, name := range a.APIKeys {
+			if subtle.ConstantTimeCompare([]byte(key), []byte(validKey)) == 1 {
+				return &Principal{ID: name, Name: name, Method: AuthAPIKey, Roles: a.APIKeyRoles[name], TenantID: a.APIKeyTenants[name]}, nil
+			}
+		}
+		return nil, ErrInvalidCredentials.WithDetails(fiber.Map{"reason": "unknown API key"})
+	}
+
+	scheme, token, _ := strings.Cut(c.Get(fiber.HeaderAuthorization), " ")
+	if !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return nil, ErrUnauthenticated
+	}
+	claims, err := a.verifyJWT(strings.TrimSpace(token), time.Now())
+	if err != nil {
+		return nil, ErrInvalidCredentials.WithDetails(fiber.Map{"reason": err.Error()})
+	}
+	return &Principal{ID: claims.Subject, Name: claims.Name, Method: AuthJWT, Roles: claims.Roles, CustomerID: claims.CustomerID, TenantID: claims.TenantID}, nil
+}
+
+// Struct to represent the claims of a token this API reads
+type jwtClaims struct {
+	Subject    string      `json:"sub"`
+	Name       string      `json:"name"`
+	Issuer     string      `json:"iss"`
+	Audience   jwtAudience `json:"aud"`
+	ExpiresAt  float64     `json:"exp"`
+	NotBefore  float64     `json:"nbf"`
+	Roles      []string    `json:"roles"`
+	CustomerID string      `json:"customer_id"`
+	TenantID   string      `json:"tenant_id"`
+}
+
+// jwtAudience is the "aud" claim, a single string or a list
+type jwtAudience []string
+
+func (a *jwtAudience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = jwtAudience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// verifyJWT checks the signature and claims of a compact JWS token
+func (a *AuthConfig) verifyJWT(token string, now time.Time) (*jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, errors.New("malformed token header")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed token signature")
+	}
+
+	// Only algorithms with a configured key are accepted, so a token cannot
+	// pick "none" or sign with the RSA public key as an HMAC secret
+	signed := []byte(parts[0] + "." + parts[1])
+	switch header.Alg {
+	case "HS256":
+		if len(a.JWTSecret) == 0 {
+			return nil, errors.New("HS256 tokens are not accepted")
+		}
+		mac := hmac.New(sha256.New, a.JWTSecret)
+		mac.Write(signed)
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return nil, errors.New("invalid token signature")
+		}
+	case "RS256":
+		key, exists := a.JWKS[header.Kid]
+		if !exists {
+			return nil, fmt.Errorf("unknown signing key %q", header.Kid)
+		}
+		digest := sha256.Sum256(signed)
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			return nil, errors.New("invalid token signature")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+
+	var claims jwtClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, errors.New("malformed token claims")
+	}
+	switch {
+	case claims.Subject == "":
+		return nil, errors.New("token has no subject")
+	case claims.ExpiresAt == 0:
+		return nil, errors.New("token has no expiry")
+	case now.After(time.Unix(int64(claims.ExpiresAt), 0).Add(jwtLeeway)):
+		return nil, errors.New("token has expired")
+	case claims.NotBefore != 0 && now.Add(jwtLeeway).Before(time.Unix(int64(claims.NotBefore), 0)):
+		return nil, errors.New("token is not valid yet")
+	case a.Issuer != "" && claims.Issuer != a.Issuer:
+		return nil, errors.New("token has the wrong issuer")
+	case a.Audience != "" && !containsString(claims.Audience, a.Audience):
+		return nil, errors.New("token has the wrong audience")
+	}
+	return &claims, nil
+}
+
+// decodeJWTPart decodes a base64url JSON part of a token
+func decodeJWTPart(part string, out interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// currentPrincipal returns the authenticated caller, or nil when
+// authentication is off
+func currentPrincipal(c *fiber.Ctx) *Principal {
+	principal, _ := c.Locals(principalKey).(*Principal)
+	return principal
+}
+
+// processedBy names who processed an order step, "System" without authentication
+func processedBy(c *fiber.Ctx) string {
+	if principal := currentPrincipal(c); principal != nil {
+		return principal.ID
+	}
+	return "System"
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper function to set up the app with authentication
+func setupAuthApp(config *AuthConfig) *fiber.App {
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	app.Use(Authenticate(config))
+	app.Use(ResolveTenant())
+	app.Use(OpenAPIValidator(ValidateStrict))
+	app.Use(CommitEvents())
+	setupRoutes(app)
+	return app
+}
+
+// signJWT builds a token signed with an HMAC secret or an RSA key
+func signJWT(t *testing.T, header, claims fiber.Map, key interface{}) string {
+	encode := func(part fiber.Map) string {
+		data, err := json.Marshal(part)
+		assert.NoError(t, err)
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := encode(header) + "." + encode(claims)
+
+	var signature []byte
+	switch key := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		assert.NoError(t, err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// authRequest sends a request with the given credential headers
+func authRequest(t *testing.T, app *fiber.App, method, path string, headers map[string]string) (*http.Response, map[string]interface{}) {
+	req := httptest.NewRequest(method, path, nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	var body map[string]interface{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	return resp, body
+}
+
+// Test that API keys are required and recorded on the orders they process
+func TestAuthAPIKey(t *testing.T) {
+	config, err := loadAuthConfig(func(name string) string {
+		return map[string]string{
+			"API_KEYS":      "checkout=key_checkout, warehouse=key_warehouse",
+			"API_KEY_ROLES": "checkout=support,warehouse=warehouse",
+		}[name]
+	})
+	assert.NoError(t, err)
+	app := setupAuthApp(config)
+	createPaidOrder(t, setupApp(), "cust_auth_key", "order_auth_key", nil)
+
+	resp, body := authRequest(t, app, http.MethodPost, "/v1/orders/order_auth_key/route", nil)
+	assert.Equal(t, 401, resp.StatusCode)
+	assert.Equal(t, "Unauthenticated", body["error"].(map[string]interface{})["code"])
+	assert.Contains(t, resp.Header.Get(fiber.HeaderWWWAuthenticate), "Bearer")
+
+	resp, body = authRequest(t, app, http.MethodPost, "/v1/orders/order_auth_key/route", map[string]string{"X-API-Key": "key_unknown"})
+	assert.Equal(t, 401, resp.StatusCode)
+	assert.Equal(t, "InvalidCredentials", body["error"].(map[string]interface{})["code"])
+
+	resp, body = authRequest(t, app, http.MethodPost, "/v1/orders/order_auth_key/route", map[string]string{"X-API-Key": "key_warehouse"})
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "warehouse", body["order"].(map[string]interface{})["ProcessedBy"])
+
+	// The docs and error catalog stay public
+	resp, _ = authRequest(t, app, http.MethodGet, "/v1/errors/OrderNotFound", nil)
+	assert.Equal(t, 200, resp.StatusCode)
+}
+
+// Test HS256 bearer tokens and the claims that are checked
+func TestAuthJWTHS256(t *testing.T) {
+	secret := []byte("test-secret")
+	app := setupAuthApp(&AuthConfig{JWTSecret: secret, Issuer: "https://auth.example.com", Audience: "order-app"})
+	createPaidOrder(t, setupApp(), "cust_auth_jwt", "order_auth_jwt", nil)
+
+	header := fiber.Map{"alg": "HS256", "typ": "JWT"}
+	claims := func(overrides fiber.Map) fiber.Map {
+		claims := fiber.Map{
+			"sub": "user_ops_1", "name": "Siti", "iss": "https://auth.example.com",
+			"aud": []string{"order-app"}, "exp": time.Now().Add(time.Hour).Unix(), "roles": []string{"warehouse"},
+		}
+		for key, value := range overrides {
+			claims[key] = value
+		}
+		return claims
+	}
+	bearer := func(token string) map[string]string {
+		return map[string]string{"Authorization": "Bearer " + token}
+	}
+
+	resp, body := authRequest(t, app, http.MethodGet, "/v1/orders/order_auth_jwt", bearer(signJWT(t, header, claims(nil), secret)))
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "order_auth_jwt", body["order"].(map[string]interface{})["ID"])
+
+	for reason, token := range map[string]string{
+		"token has expired":            signJWT(t, header, claims(fiber.Map{"exp": time.Now().Add(-time.Hour).Unix()}), secret),
+		"token has the wrong audience": signJWT(t, header, claims(fiber.Map{"aud": "other-app"}), secret),
+		"token has the wrong issuer":   signJWT(t, header, claims(fiber.Map{"iss": "https://evil.example.com"}), secret),
+		"invalid token signature":      signJWT(t, header, claims(nil), []byte("other-secret")),
+		`unsupported algorithm "none"`: signJWT(t, fiber.Map{"alg": "none"}, claims(nil), []byte{}),
+		"malformed token":              "not-a-token",
+	} {
+		resp, body = authRequest(t, app, http.MethodGet, "/v1/orders/order_auth_jwt", bearer(token))
+		assert.Equal(t, 401, resp.StatusCode, reason)
+		errBody := body["error"].(map[string]interface{})
+		assert.Equal(t, "InvalidCredentials", errBody["code"], reason)
+		assert.Equal(t, reason, errBody["details"].(map[string]interface{})["reason"])
+	}
+}
+
+// Test RS256 bearer tokens verified with a local JWKS file
+func TestAuthJWTRS256(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	jwks, err := json.Marshal(fiber.Map{"keys": []fiber.Map{{
+		"kty": "RSA", "kid": "key-1", "use": "sig", "alg": "RS256",
+		"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}})
+	assert.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	assert.NoError(t, os.WriteFile(path, jwks, 0o600))
+
+	config, err := loadAuthConfig(func(name string) string {
+		return map[string]string{"JWT_JWKS_FILE": path}[name]
+	})
+	assert.NoError(t, err)
+	app := setupAuthApp(config)
+	createPaidOrder(t, setupApp(), "cust_auth_rs256", "order_auth_rs256", nil)
+
+	claims := fiber.Map{"sub": "user_support_1", "exp": time.Now().Add(time.Hour).Unix(), "roles": []string{"support"}}
+	token := signJWT(t, fiber.Map{"alg": "RS256", "kid": "key-1"}, claims, key)
+	resp, body := authRequest(t, app, http.MethodPost, "/v1/orders/order_auth_rs256/cancel", map[string]string{"Authorization": "Bearer " + token})
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "user_support_1", body["order"].(map[string]interface{})["ProcessedBy"])
+
+	// Unknown keys and HMAC tokens are rejected when only RS256 is configured
+	token = signJWT(t, fiber.Map{"alg": "RS256", "kid": "key-2"}, claims, key)
+	resp, _ = authRequest(t, app, http.MethodGet, "/v1/orders/order_auth_rs256", map[string]string{"Authorization": "Bearer " + token})
+	assert.Equal(t, 401, resp.StatusCode)
+	token = signJWT(t, fiber.Map{"alg": "HS256"}, claims, []byte{})
+	resp, body = authRequest(t, app, http.MethodGet, "/v1/orders/order_auth_rs256", map[string]string{"Authorization": "Bearer " + token})
+	assert.Equal(t, 401, resp.StatusCode)
+	assert.Equal(t, "HS256 tokens are not accepted", body["error"].(map[string]interface{})["details"].(map[string]interface{})["reason"])
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Headers carriers use to sign webhook deliveries
+const (
+	carrierSignatureHeader = "X-Carrier-Signature" // "sha256=" + hex HMAC of "<timestamp>.<body>"
+	carrierTimestampHeader = "X-Carrier-Timestamp" // unix seconds
+)
+
+// Maximum age of a signed delivery before it is rejected as a replay
+const carrierSignatureTolerance = 5 * time.Minute
+
+// How long applied event IDs are remembered. Carriers re-sign their retries,
+// so this covers their retry schedule rather than the signature tolerance.
+const carrierEventRetention = 72 * time.Hour
+
+// Struct to represent a tracking event normalized from a carrier payload
+type CarrierEvent struct {
+	EventID        string
+	TrackingNumber string
+	Status         string // one of the shipment statuses
+	Description    string
+	OccurredAt     time.Time
+}
+
+// CarrierParser turns a carrier's webhook payload into tracking events
+type CarrierParser interface {
+	Parse(body []byte) ([]CarrierEvent, error)
+}
+
+// Struct to hold the webhook configuration of a carrier
+type carrierWebhook struct {
+	Parser CarrierParser
+	Secret string
+}
+
+// Registered carriers keyed by the name used in the webhook path
+var carrierWebhooks = make(map[string]*carrierWebhook)
+
+// Carrier events already applied, keyed by carrier and event ID
+var processedCarrierEvents = make(map[string]time.Time)
+
+// pruneCarrierEvents forgets the events applied longer ago than carriers
+// retry them, so the de-duplication does not grow forever
+func pruneCarrierEvents(now time.Time) {
+	for key, processedAt := range processedCarrierEvents {
+		if now.Sub(processedAt) > carrierEventRetention {
+			delete(processedCarrierEvents, key)
+		}
+	}
+}
+
+// Parsers for carriers with their own payload format. Carriers not listed here
+// use GenericCarrierParser.
+var carrierParsers = map[string]CarrierParser{
+	"statuscode": StatusCodeCarrierParser{
+		Codes: map[string]string{
+			"PU": ShipmentInTransit,
+			"IT": ShipmentInTransit,
+			"OD": ShipmentOutForDelivery,
+			"DL": ShipmentDelivered,
+			"EX": ShipmentException,
+		},
+	},
+}
+
+// RegisterCarrier enables webhook ingestion for a carrier
+func RegisterCarrier(name string, parser CarrierParser, secret string) {
+	carrierWebhooks[strings.ToLower(name)] = &carrierWebhook{Parser: parser, Secret: secret}
+}
+
+// configureCarrierWebhooks registers carriers from a "name=secret,name=secret" list
+func configureCarrierWebhooks(config string) {
+	for _, entry := range strings.Split(config, ",") {
+		name, secret, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || name == "" || secret == "" {
+			continue
+		}
+		parser, exists := carrierParsers[name]
+		if !exists {
+			parser = GenericCarrierParser{}
+		}
+		RegisterCarrier(name, parser, secret)
+		log.Info().Str("carrier.name", name).Msg("Carrier webhook enabled")
+	}
+}
+
+// carrierSignature computes the expected signature header value for a delivery
+func carrierSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyCarrierSignature checks the signature and freshness of a delivery
+func verifyCarrierSignature(secret, timestamp, signature string, body []byte, now time.Time) error {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp %q", timestamp)
+	}
+	age := now.Sub(time.Unix(seconds, 0))
+	if age > carrierSignatureTolerance || age < -carrierSignatureTolerance {
+		return fmt.Errorf("timestamp outside of tolerance")
+	}
+	expected := carrierSignature(secret, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
+
+// GenericCarrierParser parses carriers that post our documented JSON format:
+// {"events": [{"event_id", "tracking_number", "status", "description", "occurred_at"}]}
+type GenericCarrierParser struct{}
+
+func (GenericCarrierParser) Parse(body []byte) ([]CarrierEvent, error) {
+	var payload struct {
+		Events []struct {
+			EventID        string    `json:"event_id"`
+			TrackingNumber string    `json:"tracking_number"`
+			Status         string    `json:"status"`
+			Description    string    `json:"description"`
+			OccurredAt     time.Time `json:"occurred_at"`
+		} `json:"events"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	events := make([]CarrierEvent, 0, len(payload.Events))
+	for _, e := range payload.Events {
+		if _, known := shipmentTransitions[e.Status]; !known {
+			return nil, fmt.Errorf("unknown status %q for event %s", e.Status, e.EventID)
+		}
+		events = append(events, CarrierEvent(e))
+	}
+	return events, nil
+}
+
+// StatusCodeCarrierParser parses carriers that post a single scan with their own status codes:
+// {"id", "awb", "code", "remark", "timestamp"}
+type StatusCodeCarrierParser struct {
+	Codes map[string]string // carrier code -> shipment status
+}
+
+func (p StatusCodeCarrierParser) Parse(body []byte) ([]CarrierEvent, error) {
+	var payload struct {
+		ID        string    `json:"id"`
+		AWB       string    `json:"awb"`
+		Code      string    `json:"code"`
+		Remark    string    `json:"remark"`
+		Timestamp time.Time `json:"timestamp"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	status, known := p.Codes[payload.Code]
+	if !known {
+		return nil, fmt.Errorf("unknown status code %q for event %s", payload.Code, payload.ID)
+	}
+	return []CarrierEvent{{
+		EventID:        payload.ID,
+		TrackingNumber: payload.AWB,
+		Status:         status,
+		Description:    payload.Remark,
+		OccurredAt:     payload.Timestamp,
+	}}, nil
+}
+
+// findShipmentByTracking returns the shipment with the given carrier and tracking number
+func findShipmentByTracking(carrier, trackingNumber string) (*Shipment, bool) {
+	for _, shipment := range shipments {
+		if strings.EqualFold(shipment.Carrier, carrier) && shipment.TrackingNumber == trackingNumber {
+			return shipment, true
+		}
+	}
+	return nil, false
+}
+
+func CarrierWebhookHandler(c *fiber.Ctx) error {
+	carrier := strings.ToLower(c.Params("carrier"))
+
+	// Check if the carrier is registered
+	webhook, exists := carrierWebhooks[carrier]
+	if !exists {
+		log.Warn().Msgf("Webhook received for unknown carrier %s", carrier)
+		return ErrCarrierNotFound.WithTarget("carrier")
+	}
+
+	// Verify the delivery was signed by the carrier
+	body := c.Body()
+	if err := verifyCarrierSignature(webhook.Secret, c.Get(carrierTimestampHeader), c.Get(carrierSignatureHeader), body, time.Now()); err != nil {
+		log.Warn().Err(err).Str("carrier.name", carrier).Msg("Invalid carrier webhook signature")
+		return ErrInvalidSignature
+	}
+
+	events, err := webhook.Parser.Parse(body)
+	if err != nil {
+		log.Warn().Err(err).Str("carrier.name", carrier).Msg("Invalid carrier webhook payload")
+		return ErrInvalidRequest.WithMessage("The webhook payload could not be parsed.")
+	}
+
+	// Apply each event once. Unmatched or out-of-order events are acknowledged
+	// so the carrier does not keep retrying them, but are not marked as
+	// processed: a later delivery can still apply them.
+	pruneCarrierEvents(time.Now())
+	var applied, duplicates, ignored int
+	for _, event := range events {
+		// Events without an ID are identified by their content
+		eventID := event.EventID
+		if eventID == "" {
+			eventID = event.TrackingNumber + "|" + event.Status + "|" + event.OccurredAt.Format(time.RFC3339Nano)
+		}
+		key := carrier + ":" + eventID
+		if _, seen := processedCarrierEvents[key]; seen {
+			duplicates++
+			continue
+		}
+
+		shipment, found := findShipmentByTracking(carrier, event.TrackingNumber)
+		if !found {
+			log.Warn().Str("carrier.name", carrier).Msgf("No shipment found for tracking number %s", event.TrackingNumber)
+			ignored++
+			continue
+		}
+
+		occurredAt := event.OccurredAt.UTC()
+		if event.OccurredAt.IsZero() {
+			occurredAt = time.Now().UTC()
+		}
+		if err := applyShipmentStatus(c, shipment, event.Status, event.Description, occurredAt); err != nil {
+			log.Warn().Err(err).Str("carrier.name", carrier).Msg("Ignoring carrier event")
+			ignored++
+			continue
+		}
+		processedCarrierEvents[key] = time.Now().UTC()
+
+		log.Info().
+			Str("event.action", "carrier_tracking_event").
+			Str("carrier.name", carrier).
+			Str("order.id", shipment.OrderID).
+			Str("shipment.id", shipment.ShipmentID).
+			Str("shipment.status", shipment.Status).
+			Msg("Carrier tracking event applied")
+		applied++
+	}
+
+	return c.JSON(fiber.Map{
+		"message":    "Webhook processed",
+		"applied":    applied,
+		"duplicates": duplicates,
+		"ignored":    ignored,
+	})
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// Fake carrier that posts signed tracking events to the webhook endpoint
+type fakeCarrier struct {
+	name   string
+	secret string
+}
+
+func (fc fakeCarrier) post(t *testing.T, app *fiber.App, payload string, signedAt time.Time) (int, map[string]interface{}) {
+	t.Helper()
+
+	timestamp := strconv.FormatInt(signedAt.Unix(), 10)
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/carriers/"+fc.name, bytes.NewBuffer([]byte(payload)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(carrierTimestampHeader, timestamp)
+	req.Header.Set(carrierSignatureHeader, carrierSignature(fc.secret, timestamp, []byte(payload)))
+
+	resp, err := app.Test(req, -1)
+	assert.NoError(t, err)
+
+	var responseBody map[string]interface{}
+	err = json.NewDecoder(resp.Body).Decode(&responseBody)
+	assert.NoError(t, err)
+
+	return resp.StatusCode, responseBody
+}
+
+// Test that signed carrier events update shipments once and are de-duplicated
+func TestCarrierWebhookUpdatesShipment(t *testing.T) {
+	app := setupApp()
+	carrier := fakeCarrier{name: "fakecarrier", secret: "s3cret"}
+	RegisterCarrier(carrier.name, GenericCarrierParser{}, carrier.secret)
+
+	createPaidOrder(t, app, "cust_carrier_webhook", "order_carrier_webhook", nil)
+	packOrder(t, app, "order_carrier_webhook")
+	status, _ := doJSON(t, app, http.MethodPost, "/shipments", `{
+		"order_id": "order_carrier_webhook", "carrier": "FakeCarrier", "tracking_number": "FC123"
+	}`)
+	assert.Equal(t, 201, status)
+
+	inTransit := `{"events": [{"event_id": "evt-1", "tracking_number": "FC123", "status": "in_transit", "occurred_at": "2024-01-01T10:00:00Z"}]}`
+	status, body := carrier.post(t, app, inTransit, time.Now())
+	assert.Equal(t, 200, status)
+	assert.Equal(t, 1.0, body["applied"])
+
+	// Carriers retry deliveries; the repeated event is acknowledged but not applied
+	status, body = carrier.post(t, app, inTransit, time.Now())
+	assert.Equal(t, 200, status)
+	assert.Equal(t, 0.0, body["applied"])
+	assert.Equal(t, 1.0, body["duplicates"])
+
+	// Including retries re-signed long after the signature tolerance
+	processedCarrierEvents["fakecarrier:evt-1"] = time.Now().Add(-carrierSignatureTolerance - time.Hour)
+	status, body = carrier.post(t, app, inTransit, time.Now())
+	assert.Equal(t, 200, status)
+	assert.Equal(t, 1.0, body["duplicates"])
+
+	delivered := `{"events": [
+		{"event_id": "evt-2", "tracking_number": "UNKNOWN", "status": "delivered"},
+		{"event_id": "evt-3", "tracking_number": "FC123", "status": "delivered", "occurred_at": "2024-01-02T10:00:00Z"}
+	]}`
+	status, body = carrier.post(t, app, delivered, time.Now())
+	assert.Equal(t, 200, status)
+	assert.Equal(t, 1.0, body["applied"])
+	assert.Equal(t, 1.0, body["ignored"])
+
+	order := defaultTenant.Orders["order_carrier_webhook"]
+	assert.Equal(t, "Delivered", order.Status)
+	assert.Len(t, order.Shipments[0].Events, 3)
+}
+
+// Test that events arriving before their shipment are applied on a later
+// delivery, and that old de-duplication entries are pruned
+func TestCarrierWebhookRetriesUnmatchedEvents(t *testing.T) {
+	app := setupApp()
+	carrier := fakeCarrier{name: "earlycarrier", secret: "s3cret"}
+	RegisterCarrier(carrier.name, GenericCarrierParser{}, carrier.secret)
+	processedCarrierEvents["earlycarrier:evt-stale"] = time.Now().Add(-carrierEventRetention - time.Minute)
+	processedCarrierEvents["earlycarrier:evt-recent"] = time.Now().Add(-time.Hour)
+
+	createPaidOrder(t, app, "cust_carrier_early", "order_carrier_early", nil)
+	packOrder(t, app, "order_carrier_early")
+
+	inTransit := `{"events": [{"event_id": "evt-early-1", "tracking_number": "EC123", "status": "in_transit"}]}`
+	status, body := carrier.post(t, app, inTransit, time.Now())
+	assert.Equal(t, 200, status)
+	assert.Equal(t, 1.0, body["ignored"])
+	assert.NotContains(t, processedCarrierEvents, "earlycarrier:evt-stale")
+	assert.Contains(t, processedCarrierEvents, "earlycarrier:evt-recent")
+
+	status, _ = doJSON(t, app, http.MethodPost, "/shipments", `{
+		"order_id": "order_carrier_early", "carrier": "EarlyCarrier", "tracking_number": "EC123"
+	}`)
+	assert.Equal(t, 201, status)
+
+	status, body = carrier.post(t, app, inTransit, time.Now())
+	assert.Equal(t, 200, status)
+	assert.Equal(t, 1.0, body["applied"])
+	assert.Equal(t, 0.0, body["duplicates"])
+	assert.Equal(t, ShipmentInTransit, defaultTenant.Orders["order_carrier_early"].Shipments[0].Status)
+}
+
+// Test that unsigned, wrongly signed and stale deliveries are rejected
+func TestCarrierWebhookRejectsInvalidSignature(t *testing.T) {
+	app := setupApp()
+	RegisterCarrier("signedcarrier", GenericCarrierParser{}, "right-secret")
+
+	payload := `{"events": []}`
+
+	status, body := fakeCarrier{name: "signedcarrier", secret: "wrong-secret"}.post(t, app, payload, time.Now())
+	assert.Equal(t, 401, status)
+	assert.Equal(t, "InvalidSignature", body["error"].(map[string]interface{})["code"])
+
+	status, _ = fakeCarrier{name: "signedcarrier", secret: "right-secret"}.post(t, app, payload, time.Now().Add(-time.Hour))
+	assert.Equal(t, 401, status)
+
+	status, _ = doJSON(t, app, http.MethodPost, "/webhooks/carriers/signedcarrier", payload)
+	assert.Equal(t, 401, status)
+
+	status, body = fakeCarrier{name: "unregistered", secret: "x"}.post(t, app, payload, time.Now())
+	assert.Equal(t, 404, status)
+	assert.Equal(t, "CarrierNotFound", body["error"].(map[string]interface{})["code"])
+}
+
+// Test the status code parser maps carrier codes to shipment statuses
+func TestStatusCodeCarrierParser(t *testing.T) {
+	parser := carrierParsers["statuscode"]
+
+	events, err := parser.Parse([]byte(`{"id": "scan-9", "awb": "AWB1", "code": "OD", "remark": "With courier"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, ShipmentOutForDelivery, events[0].Status)
+	assert.Equal(t, "AWB1", events[0].TrackingNumber)
+
+	_, err = parser.Parse([]byte(`{"id": "scan-10", "awb": "AWB1", "code": "??"}`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// Struct to represent a product in the catalog
+type Product struct {
+	SKU      string  `json:"sku"`
+	Name     string  `json:"name"`
+	Price    float64 `json:"price"`
+	Active   bool    `json:"active"`
+	TaxClass string  `json:"tax_class"`
+	Weight   float64 `json:"weight"` // in kilograms
+	Stock    int     `json:"stock"`  // units on hand, taken by paid orders and put back by restocked returns
+}
+
+// Request struct for creating or updating a product
+type ProductRequest struct {
+	SKU      string  `json:"sku"` // taken from the path on update
+	Name     string  `json:"name" validate:"required"`
+	Price    float64 `json:"price" validate:"gte=0"`
+	Active   *bool   `json:"active"` // defaults to true when omitted
+	TaxClass string  `json:"tax_class"`
+	Weight   float64 `json:"weight" validate:"gte=0"`
+	Stock    int     `json:"stock" validate:"gte=0"`
+}
+
+// demoProducts seeds the product catalog of each tenant.
+// The catalog is the source of truth for item names and prices.
+func demoProducts() map[string]*Product {
+	return map[string]*Product{
+		"item001": {SKU: "item001", Name: "Laptop", Price: 1000, Active: true, TaxClass: "standard", Weight: 2.0, Stock: 50},
+		"item002": {SKU: "item002", Name: "Mouse", Price: 50, Active: true, TaxClass: "standard", Weight: 0.1, Stock: 200},
+	}
+}
+
+// catalogItems prices the requested items from the tenant's catalog, ignoring
+// any client-submitted name or price. Unknown or inactive SKUs are rejected.
+// On failure it returns the API error.
+func catalogItems(tenant *Tenant, requested []Item) ([]Item, error) {
+	items := make([]Item, len(requested))
+	for i, item := range requested {
+		if item.Quantity <= 0 {
+			log.Warn().Str("item.id", item.ItemID).Msg("Invalid item quantity")
+			return nil, ErrInvalidRequest.WithMessage("Item quantity must be greater than zero").WithTarget("items")
+		}
+
+		product, exists := tenant.Products[item.ItemID]
+		if !exists {
+			log.Warn().Msgf("Unknown SKU %s", item.ItemID)
+			return nil, ErrUnknownProduct.WithTarget("items").WithDetails(fiber.Map{
+				"item_id": item.ItemID,
+			})
+		}
+
+		if !product.Active {
+			log.Warn().Msgf("Inactive SKU %s", item.ItemID)
+			return nil, ErrProductInactive.WithTarget("items").WithDetails(fiber.Map{
+				"item_id": item.ItemID,
+			})
+		}
+
+		items[i] = Item{
+			ItemID:   product.SKU,
+			Name:     product.Name,
+			Quantity: item.Quantity,
+			Price:    product.Price,
+			TaxClass: product.TaxClass,
+			Weight:   product.Weight,
+		}
+	}
+	return items, nil
+}
+
+// takeStock takes the items of a paid order off the tenant's stock, all or
+// none of them. Products removed from the catalog have no stock left. On
+// failure it returns the API error.
+func takeStock(tenant *Tenant, items []Item) error {
+	requested := make(map[string]int)
+	for _, item := range items {
+		requested[item.ItemID] += item.Quantity
+	}
+
+	for _, item := range items {
+		available := 0
+		if product, exists := tenant.Products[item.ItemID]; exists {
+			available = product.Stock
+		}
+		if requested[item.ItemID] > available {
+			log.Warn().Msgf("Not enough stock of SKU %s: %d requested, %d available", item.ItemID, requested[item.ItemID], available)
+			return ErrOutOfStock.WithTarget("items").WithDetails(fiber.Map{
+				"item_id":   item.ItemID,
+				"requested": requested[item.ItemID],
+				"available": available,
+			})
+		}
+	}
+
+	for sku, quantity := range requested {
+		tenant.Products[sku].Stock -= quantity
+	}
+	return nil
+}
+
+func CreateProductHandler(c *fiber.Ctx) error {
+	var productReq ProductRequest
+
+	// Parse the JSON input for product creation
+	if err := c.BodyParser(&productReq); err != nil {
+		log.Warn().Msg("Invalid JSON input for /products")
+		return ErrInvalidJSON
+	}
+
+	if productReq.SKU == "" {
+		log.Warn().Msg("SKU missing in /products request")
+		return ErrInvalidRequest.WithMessage("Product SKU is required").WithTarget("sku")
+	}
+
+	if err := checkRequest(productReq); err != nil {
+		return err
+	}
+
+	// Check that the SKU is not already in the catalog
+	products := tenantOf(c).Products
+	if _, exists := products[productReq.SKU]; exists {
+		log.Warn().Msgf("Product SKU %s already exists", productReq.SKU)
+		return ErrProductAlreadyExists.WithTarget("sku")
+	}
+
+	active := true
+	if productReq.Active != nil {
+		active = *productReq.Active
+	}
+
+	products[productReq.SKU] = &Product{
+		SKU:      productReq.SKU,
+		Name:     productReq.Name,
+		Price:    productReq.Price,
+		Active:   active,
+		TaxClass: productReq.TaxClass,
+		Weight:   productReq.Weight,
+		Stock:    productReq.Stock,
+	}
+
+	log.Info().Str("event.action", "create_product").
+		Str("product.sku", productReq.SKU).
+		Msg("Product created successfully")
+
+	return c.Status(201).JSON(fiber.Map{
+		"message": "Product created successfully",
+		"product": products[productReq.SKU],
+	})
+}
+
+func GetProductsHandler(c *fiber.Ctx) error {
+	// Return the catalog as a list
+	products := tenantOf(c).Products
+	productList := make([]*Product, 0, len(products))
+	for _, product := range products {
+		productList = append(productList, product)
+	}
+
+	log.Info().Msg("Fetching all products")
+	return c.JSON(fiber.Map{
+		"message":  "All products retrieved successfully",
+		"products": productList,
+	})
+}
+
+func GetProductHandler(c *fiber.Ctx) error {
+	sku := c.Params("sku")
+
+	// Check if product exists
+	product, exists := tenantOf(c).Products[sku]
+	if !exists {
+		log.Warn().Msgf("Product SKU %s not found", sku)
+		return ErrProductNotFound.WithTarget("sku")
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Product retrieved successfully",
+		"product": product,
+	})
+}
+
+func UpdateProductHandler(c *fiber.Ctx) error {
+	sku := c.Params("sku")
+
+	// Check if product exists
+	product, exists := tenantOf(c).Products[sku]
+	if !exists {
+		log.Warn().Msgf("Product SKU %s not found for update", sku)
+		return ErrProductNotFound.WithTarget("sku")
+	}
+
+	var productReq ProductRequest
+	if err := c.BodyParser(&productReq); err != nil {
+		log.Warn().Msg("Invalid JSON input for product update")
+		return ErrInvalidJSON
+	}
+
+	if err := checkRequest(productReq); err != nil {
+		return err
+	}
+
+	// Replace the product details, keeping the SKU from the path
+	product.Name = productReq.Name
+	product.Price = productReq.Price
+	product.TaxClass = productReq.TaxClass
+	product.Weight = productReq.Weight
+	product.Stock = productReq.Stock
+	if productReq.Active != nil {
+		product.Active = *productReq.Active
+	}
+
+	log.Info().Str("event.action", "update_product").
+		Str("product.sku", sku).
+		Msg("Product updated successfully")
+
+	return c.JSON(fiber.Map{
+		"message": "Product updated successfully",
+		"product": product,
+	})
+}
+
+func DeleteProductHandler(c *fiber.Ctx) error {
+	sku := c.Params("sku")
+
+	// Check if product exists
+	products := tenantOf(c).Products
+	if _, exists := products[sku]; !exists {
+		log.Warn().Msgf("Product SKU %s not found for deletion", sku)
+		return ErrProductNotFound.WithTarget("sku")
+	}
+
+	// Existing carts and orders keep their own copy of name and price
+	delete(products, sku)
+
+	log.Info().Str("event.action", "delete_product").
+		Str("product.sku", sku).
+		Msg("Product deleted successfully")
+
+	return c.JSON(fiber.Map{
+		"message": "Product deleted successfully",
+	})
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper function to send a JSON request and decode the JSON response
+func doJSON(t *testing.T, app *fiber.App, method, path, payload string) (int, map[string]interface{}) {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, bytes.NewBuffer([]byte(payload)))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := app.Test(req, -1)
+	assert.NoError(t, err)
+
+	var responseBody map[string]interface{}
+	err = json.NewDecoder(resp.Body).Decode(&responseBody)
+	assert.NoError(t, err)
+
+	return resp.StatusCode, responseBody
+}
+
+// Test that the cart takes name and price from the catalog
+func TestCreateCartUsesCatalogPrice(t *testing.T) {
+	app := setupApp()
+
+	payload := `{
+		"customer_id": "cust_catalog_price",
+		"items": [
+			{"item_id": "item001", "name": "Cheap Laptop", "quantity": 1, "price": 1}
+		]
+	}`
+
+	status, body := doJSON(t, app, http.MethodPost, "/create-cart", payload)
+	assert.Equal(t, 200, status)
+
+	item := body["cart"].(map[string]interface{})["items"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "Laptop", item["name"])
+	assert.Equal(t, 1000.0, item["price"])
+}
+
+// Test that unknown and inactive SKUs are rejected
+func TestCreateCartRejectsUnknownAndInactiveSKU(t *testing.T) {
+	app := setupApp()
+
+	status, body := doJSON(t, app, http.MethodPost, "/create-cart", `{
+		"customer_id": "cust_catalog_unknown",
+		"items": [{"item_id": "does-not-exist", "quantity": 1}]
+	}`)
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "UnknownProduct", body["error"].(map[string]interface{})["code"])
+
+	status, _ = doJSON(t, app, http.MethodPost, "/products", `{
+		"sku": "sku_inactive", "name": "Discontinued", "price": 10, "active": false
+	}`)
+	assert.Equal(t, 201, status)
+
+	status, body = doJSON(t, app, http.MethodPost, "/create-cart", `{
+		"customer_id": "cust_catalog_inactive",
+		"items": [{"item_id": "sku_inactive", "quantity": 1}]
+	}`)
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "ProductInactive", body["error"].(map[string]interface{})["code"])
+}
+
+// Test product CRUD endpoints
+func TestProductCRUD(t *testing.T) {
+	app := setupApp()
+
+	status, body := doJSON(t, app, http.MethodPost, "/products", `{
+		"sku": "sku_crud", "name": "Keyboard", "price": 75, "tax_class": "standard", "weight": 0.8
+	}`)
+	assert.Equal(t, 201, status)
+	assert.Equal(t, true, body["product"].(map[string]interface{})["active"])
+
+	status, _ = doJSON(t, app, http.MethodPost, "/products", `{"sku": "sku_crud", "name": "Keyboard", "price": 75}`)
+	assert.Equal(t, 409, status)
+
+	status, body = doJSON(t, app, http.MethodPut, "/products/sku_crud", `{"name": "Keyboard Pro", "price": 90}`)
+	assert.Equal(t, 200, status)
+	assert.Equal(t, 90.0, body["product"].(map[string]interface{})["price"])
+
+	status, body = doJSON(t, app, http.MethodGet, "/products/sku_crud", "")
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "Keyboard Pro", body["product"].(map[string]interface{})["name"])
+
+	status, _ = doJSON(t, app, http.MethodDelete, "/products/sku_crud", "")
+	assert.Equal(t, 200, status)
+
+	status, body = doJSON(t, app, http.MethodGet, "/products/sku_crud", "")
+	assert.Equal(t, 404, status)
+	assert.Equal(t, "ProductNotFound", body["error"].(map[string]interface{})["code"])
+}
+
+// Test that paid orders take their items off the stock and are rejected
+// when too few units are left
+func TestOrdersTakeStock(t *testing.T) {
+	app := setupApp()
+
+	status, _ := doJSON(t, app, http.MethodPost, "/v1/products", `{"sku": "sku_stock", "name": "Monitor", "price": 300, "stock": 3}`)
+	assert.Equal(t, 201, status)
+	billingAddress := `{"customer_id": "cust_stock", "name": "John Doe", "email": "john@example.com", "phone": "+15555555555", "country": "US"}`
+
+	pay := func(orderID string) (int, map[string]interface{}) {
+		status, _ := doJSON(t, app, http.MethodPost, "/v1/carts", `{"customer_id": "cust_stock", "items": [{"item_id": "sku_stock", "quantity": 2}]}`)
+		assert.Equal(t, 200, status)
+		status, body := doJSON(t, app, http.MethodPost, "/v1/carts/cust_stock/quote", `{"billing_address": `+billingAddress+`}`)
+		assert.Equal(t, 200, status)
+		amount := body["totals"].(map[string]interface{})["grand_total"].(float64)
+		payload, _ := json.Marshal(map[string]interface{}{"order_id": orderID, "amount": amount, "billing_address": json.RawMessage(billingAddress)})
+		return doJSON(t, app, http.MethodPost, "/v1/orders", string(payload))
+	}
+
+	status, _ = pay("order_stock_1")
+	assert.Equal(t, 200, status)
+	assert.Equal(t, 1, defaultTenant.Products["sku_stock"].Stock)
+
+	status, body := pay("order_stock_2")
+	assert.Equal(t, 409, status)
+	assert.Equal(t, "OutOfStock", errorCode(body))
+	details := body["error"].(map[string]interface{})["details"].(map[string]interface{})
+	assert.Equal(t, "sku_stock", details["item_id"])
+	assert.Equal(t, 1.0, details["available"])
+	assert.Equal(t, 1, defaultTenant.Products["sku_stock"].Stock)
+	assert.NotContains(t, defaultTenant.Orders, "order_stock_2")
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// Request struct for creating or updating a customer. Omitted fields are left
+// unchanged on update.
+type CustomerRequest struct {
+	ID              string           `json:"customer_id"` // generated when omitted on create
+	Name            *string          `json:"name"`
+	Email           *string          `json:"email" validate:"omitempty,email"`
+	Phone           *string          `json:"phone" validate:"omitempty,e164"`
+	BillingAddress  *BillingAddress  `json:"billing_address"`
+	ShippingAddress *ShippingAddress `json:"shipping_address"`
+}
+
+// applyCustomerRequest copies the fields set in the request onto the customer
+func applyCustomerRequest(customer *CustomerInfo, req CustomerRequest) {
+	if req.Name != nil {
+		customer.Name = *req.Name
+	}
+	if req.Email != nil {
+		customer.Email = *req.Email
+	}
+	if req.Phone != nil {
+		customer.Phone = *req.Phone
+	}
+	if req.BillingAddress != nil {
+		address := *req.BillingAddress
+		address.CustomerID = customer.ID
+		customer.BillingAddress = &address
+	}
+	if req.ShippingAddress != nil {
+		address := *req.ShippingAddress
+		customer.ShippingAddress = &address
+	}
+}
+
+// validateCustomer returns an error message when the customer is incomplete
+func validateCustomer(customer *CustomerInfo) string {
+	if customer.Name == "" {
+		return "Customer name is required"
+	}
+	if customer.Email == "" {
+		return "Customer email is required"
+	}
+	if customer.ShippingAddress != nil {
+		if _, msg := validateShippingAddress(*customer.ShippingAddress); msg != "" {
+			return msg
+		}
+	}
+	return ""
+}
+
+// withSavedBillingAddress fills in billing details the request left out from
+// the customer's account with the tenant. Contact details are filled field by field; the postal
+// address is only taken from the saved billing address when the request has none,
+// so two addresses are never mixed.
+func withSavedBillingAddress(tenant *Tenant, address BillingAddress) BillingAddress {
+	customer, exists := tenant.Customers[address.CustomerID]
+	if !exists {
+		return address
+	}
+
+	if address.Name == "" {
+		address.Name = customer.Name
+	}
+	if address.Email == "" {
+		address.Email = customer.Email
+	}
+	if address.Phone == "" {
+		address.Phone = customer.Phone
+	}
+
+	saved := customer.BillingAddress
+	if saved != nil && address.Address == "" && address.Country == "" {
+		address.Address = saved.Address
+		address.City = saved.City
+		address.PostalCode = saved.PostalCode
+		address.Region = saved.Region
+		address.Country = saved.Country
+	}
+	return address
+}
+
+// findCustomer looks up the customer in the route. On failure it returns the
+// API error.
+func findCustomer(c *fiber.Ctx) (*CustomerInfo, error) {
+	customerID := c.Params("id")
+
+	customer, exists := tenantOf(c).Customers[customerID]
+	if !exists {
+		log.Warn().Msgf("Customer ID %s not found", customerID)
+		return nil, ErrCustomerNotFound.WithTarget("id")
+	}
+	return customer, nil
+}
+
+func CreateCustomerHandler(c *fiber.Ctx) error {
+	var customerReq CustomerRequest
+
+	// Parse the JSON input for customer creation
+	if err := c.BodyParser(&customerReq); err != nil {
+		log.Warn().Msg("Invalid JSON input for /customers")
+		return ErrInvalidJSON
+	}
+
+	if err := checkRequest(customerReq); err != nil {
+		return err
+	}
+
+	if customerReq.ID == "" {
+		customerReq.ID = uuid.New().String()
+	}
+	if err := authorizeOwner(c, PermCustomersWrite, customerReq.ID); err != nil {
+		return err
+	}
+
+	// Check that the customer ID is not taken
+	customers := tenantOf(c).Customers
+	if _, exists := customers[customerReq.ID]; exists {
+		log.Warn().Msgf("Customer ID %s already exists", customerReq.ID)
+		return ErrCustomerAlreadyExists.WithTarget("customer_id")
+	}
+
+	customer := &CustomerInfo{
+		ID:        customerReq.ID,
+		CreatedAt: time.Now().UTC(),
+	}
+	applyCustomerRequest(customer, customerReq)
+
+	if msg := validateCustomer(customer); msg != "" {
+		log.Warn().Str("customer.id", customer.ID).Msg(msg)
+		return ErrInvalidRequest.WithMessage(msg)
+	}
+
+	customers[customer.ID] = customer
+
+	log.Info().Str("event.action", "create_customer").
+		Str("customer.id", customer.ID).
+		Msg("Customer created successfully")
+
+	return c.Status(201).JSON(fiber.Map{
+		"message":  "Customer created successfully",
+		"customer": customer,
+	})
+}
+
+func GetCustomersHandler(c *fiber.Ctx) error {
+	// Customers only see their own account
+	ownOnly := ownedOnly(c, PermCustomersRead)
+	customers := tenantOf(c).Customers
+	customerList := make([]*CustomerInfo, 0, len(customers))
+	for _, customer := range customers {
+		if !ownOnly || isOwnedBy(c, customer.ID) {
+			customerList = append(customerList, customer)
+		}
+	}
+
+	log.Info().Msg("Fetching all customers")
+	return c.JSON(fiber.Map{
+		"message":   "All customers retrieved successfully",
+		"customers": customerList,
+	})
+}
+
+func GetCustomerHandler(c *fiber.Ctx) error {
+	customer, err := findCustomer(c)
+	if err != nil {
+		return err
+	}
+	if err := authorizeOwner(c, PermCustomersRead, customer.ID); err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{
+		"message":  "Customer retrieved successfully",
+		"customer": customer,
+	})
+}
+
+func UpdateCustomerHandler(c *fiber.Ctx) error {
+	customer, err := findCustomer(c)
+	if err != nil {
+		return err
+	}
+	if err := authorizeOwner(c, PermCustomersWrite, customer.ID); err != nil {
+		return err
+	}
+
+	var customerReq CustomerRequest
+
+	// Parse the JSON input for the customer update
+	if err := c.BodyParser(&customerReq); err != nil {
+		log.Warn().Msg("Invalid JSON input for customer update")
+		return ErrInvalidJSON
+	}
+
+	if err := checkRequest(customerReq); err != nil {
+		return err
+	}
+
+	// Validate a copy so a rejected update leaves the customer unchanged
+	updated := *customer
+	applyCustomerRequest(&updated, customerReq)
+	if msg := validateCustomer(&updated); msg != "" {
+		log.Warn().Str("customer.id", customer.ID).Msg(msg)
+		return ErrInvalidRequest.WithMessage(msg)
+	}
+	*customer = updated
+
+	log.Info().Str("event.action", "update_customer").
+		Str("customer.id", customer.ID).
+		Msg("Customer updated successfully")
+
+	return c.JSON(fiber.Map{
+		"message":  "Customer updated successfully",
+		"customer": customer,
+	})
+}
+
+func GetCustomerOrdersHandler(c *fiber.Ctx) error {
+	customer, err := findCustomer(c)
+	if err != nil {
+		return err
+	}
+	if err := authorizeOwner(c, PermOrdersRead, customer.ID); err != nil {
+		return err
+	}
+
+	// Orders are linked to the customer through the billing customer ID
+	customerOrders := []*Order{}
+	for _, order := range tenantOf(c).Orders {
+		if order.Customer.CustomerID == customer.ID {
+			customerOrders = append(customerOrders, order)
+		}
+	}
+	sort.Slice(customerOrders, func(i, j int) bool {
+		return customerOrders[i].CreatedAt.Before(customerOrders[j].CreatedAt)
+	})
+
+	log.Info().Str("customer.id", customer.ID).Msg("Fetching customer orders")
+	return c.JSON(fiber.Map{
+		"message": "Customer orders retrieved successfully",
+		"orders":  customerOrders,
+	})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test creating, reading and partially updating a customer
+func TestCustomerCRUD(t *testing.T) {
+	app := setupApp()
+
+	status, body := doJSON(t, app, http.MethodPost, "/customers", `{"customer_id": "cust_account", "name": "Siti"}`)
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "InvalidRequest", body["error"].(map[string]interface{})["code"])
+
+	status, body = doJSON(t, app, http.MethodPost, "/customers", `{
+		"customer_id": "cust_account",
+		"name": "Siti",
+		"email": "siti@example.com",
+		"phone": "+628111111111",
+		"shipping_address": {"name": "Siti", "address": "Jl. Merdeka 1", "city": "Jakarta", "postal_code": "10110", "country": "ID"}
+	}`)
+	assert.Equal(t, 201, status)
+	customer := body["customer"].(map[string]interface{})
+	assert.Equal(t, "cust_account", customer["ID"])
+	assert.Equal(t, "Jakarta", customer["ShippingAddress"].(map[string]interface{})["city"])
+
+	status, body = doJSON(t, app, http.MethodPost, "/customers", `{"customer_id": "cust_account", "name": "Other", "email": "o@example.com"}`)
+	assert.Equal(t, 409, status)
+	assert.Equal(t, "CustomerAlreadyExists", body["error"].(map[string]interface{})["code"])
+
+	// Only the fields sent are changed
+	status, body = doJSON(t, app, http.MethodPatch, "/customers/cust_account", `{"phone": "+628222222222"}`)
+	assert.Equal(t, 200, status)
+	customer = body["customer"].(map[string]interface{})
+	assert.Equal(t, "+628222222222", customer["Phone"])
+	assert.Equal(t, "siti@example.com", customer["Email"])
+
+	// Invalid updates are rejected without changing the customer
+	status, _ = doJSON(t, app, http.MethodPatch, "/customers/cust_account", `{"email": "not-an-email"}`)
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "siti@example.com", defaultTenant.Customers["cust_account"].Email)
+
+	status, body = doJSON(t, app, http.MethodGet, "/customers/cust_missing", "")
+	assert.Equal(t, 404, status)
+	assert.Equal(t, "CustomerNotFound", body["error"].(map[string]interface{})["code"])
+}
+
+// Test paying with saved billing details and listing the customer's orders
+func TestCustomerOrderHistory(t *testing.T) {
+	app := setupApp()
+
+	status, _ := doJSON(t, app, http.MethodPost, "/customers", `{
+		"customer_id": "cust_history",
+		"name": "Budi",
+		"email": "budi@example.com",
+		"phone": "+628123456789",
+		"billing_address": {"address": "1 Main St", "city": "Springfield", "postal_code": "62701", "region": "IL", "country": "US"}
+	}`)
+	assert.Equal(t, 201, status)
+
+	// Only the customer ID is sent; the rest comes from the account
+	doJSON(t, app, http.MethodPost, "/create-cart", `{"customer_id": "cust_history", "items": [{"item_id": "item001", "quantity": 1}, {"item_id": "item002", "quantity": 2}]}`)
+	status, body := doJSON(t, app, http.MethodPost, "/process-payment", `{
+		"order_id": "order_history_1", "amount": 1100, "billing_address": {"customer_id": "cust_history"}
+	}`)
+	assert.Equal(t, 200, status)
+	billing := body["order"].(map[string]interface{})["Customer"].(map[string]interface{})
+	assert.Equal(t, "Budi", billing["name"])
+	assert.Equal(t, "1 Main St", billing["address"])
+
+	createPaidOrder(t, app, "cust_history", "order_history_2", nil)
+	createPaidOrder(t, app, "cust_history_other", "order_history_other", nil)
+
+	status, body = doJSON(t, app, http.MethodGet, "/customers/cust_history/orders", "")
+	assert.Equal(t, 200, status)
+	history := body["orders"].([]interface{})
+	assert.Len(t, history, 2)
+	assert.Equal(t, "order_history_1", history[0].(map[string]interface{})["ID"])
+	assert.Equal(t, "order_history_2", history[1].(map[string]interface{})["ID"])
+}
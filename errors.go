@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Struct to represent an error returned by the API. Handlers return an
+// *APIError and ErrorHandler renders it.
+type APIError struct {
+	Status  int         `json:"-"`
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Target  string      `json:"target,omitempty"`  // request field or parameter the error is about
+	Details interface{} `json:"details,omitempty"` // extra information, e.g. the invalid fields
+}
+
+func (e *APIError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// WithMessage returns a copy of the error with a more specific message
+func (e *APIError) WithMessage(message string) *APIError {
+	copied := *e
+	copied.Message = message
+	return &copied
+}
+
+// WithTarget returns a copy of the error pointing at a request field
+func (e *APIError) WithTarget(target string) *APIError {
+	copied := *e
+	copied.Target = target
+	return &copied
+}
+
+// WithDetails returns a copy of the error with extra information
+func (e *APIError) WithDetails(details interface{}) *APIError {
+	copied := *e
+	copied.Details = details
+	return &copied
+}
+
+// Struct to represent an entry of the error catalog
+type ErrorCatalogEntry struct {
+	Code    string `json:"code"`
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// Catalog of every error code the API returns, keyed by code
+var errorCatalog = make(map[string]*APIError)
+
+// defineError adds an error code to the catalog
+func defineError(status int, code, message string) *APIError {
+	apiErr := &APIError{Status: status, Code: code, Message: message}
+	errorCatalog[code] = apiErr
+	return apiErr
+}
+
+// Request errors
+var (
+	ErrInvalidRequest         = defineError(400, "InvalidRequest", "The request is invalid.")
+	ErrMissingOrderID         = defineError(400, "MissingOrderID", "Order ID is required.")
+	ErrInvalidShippingAddress = defineError(400, "InvalidShippingAddress", "The shipping address is not valid for its country.")
+	ErrRouteNotFound          = defineError(404, "RouteNotFound", "No endpoint matches the request path.")
+	ErrMethodNotAllowed       = defineError(405, "MethodNotAllowed", "The endpoint does not support this method.")
+	ErrRateLimited            = defineError(429, "RateLimited", "Too many requests. Retry after the time given in the Retry-After header.")
+	ErrInternalError          = defineError(500, "InternalError", "An unexpected error occurred.")
+
+	// Most invalid requests are bodies that are not JSON
+	ErrInvalidJSON = ErrInvalidRequest.WithMessage("Invalid JSON payload")
+)
+
+// Catalog, cart and customer errors
+var (
+	ErrUnknownProduct             = defineError(400, "UnknownProduct", "The item is not in the product catalog.")
+	ErrProductInactive            = defineError(400, "ProductInactive", "The item is no longer available for sale.")
+	ErrProductNotFound            = defineError(404, "ProductNotFound", "The product SKU provided does not exist.")
+	ErrProductAlreadyExists       = defineError(409, "ProductAlreadyExists", "A product with the given SKU already exists.")
+	ErrOutOfStock                 = defineError(409, "OutOfStock", "Not enough units of the item are in stock.")
+	ErrCartNotFound               = defineError(404, "CartNotFound", "Cart for the given customer ID not found")
+	ErrCustomerNotFound           = defineError(404, "CustomerNotFound", "The customer ID provided does not exist.")
+	ErrCustomerAlreadyExists      = defineError(409, "CustomerAlreadyExists", "A customer with the given ID already exists.")
+	ErrShippingMethodUnavailable  = defineError(400, "ShippingMethodUnavailable", "The shipping method is not available for this destination and cart.")
+	ErrPromotionNotFound          = defineError(404, "PromotionNotFound", "The coupon code provided does not exist.")
+	ErrPromotionNotActive         = defineError(400, "PromotionNotActive", "The coupon code is not valid at this time.")
+	ErrMinimumSpendNotReached     = defineError(400, "MinimumSpendNotReached", "The cart does not reach the minimum spend for this coupon code.")
+	ErrPromotionUsageLimitReached = defineError(400, "PromotionUsageLimitReached", "The coupon code has already been used the maximum number of times.")
+	ErrPromotionAlreadyExists     = defineError(409, "PromotionAlreadyExists", "A promotion with the given coupon code already exists.")
+)
+
+// Order and payment errors
+var (
+	ErrOrderNotFound          = defineError(404, "OrderNotFound", "The order ID provided does not exist.")
+	ErrOrderExists            = defineError(409, "OrderExists", "An order with the given ID already exists.")
+	ErrAmountMismatch         = defineError(400, "AmountMismatch", "The payment amount does not match the total cart amount")
+	ErrPaymentNotProcessed    = defineError(400, "PaymentNotProcessed", "Payment has not been processed for this order.")
+	ErrPaymentAlreadyRefunded = defineError(400, "PaymentAlreadyRefunded", "Payment has already been refunded for this order.")
+	ErrOrderAlreadyCancelled  = defineError(400, "OrderAlreadyCancelled", "The order has already been cancelled.")
+	ErrOrderAlreadyFulfilled  = defineError(400, "OrderAlreadyFulfilled", "The order has already been fulfilled and cannot be cancelled. Request a return instead.")
+	ErrOrderNotFulfilled      = defineError(400, "OrderNotFulfilled", "The order must be fulfilled first.")
+)
+
+// Fulfillment and shipment errors
+var (
+	ErrOrderNotRouted            = defineError(400, "OrderNotRouted", "The order must be routed before fulfillment.")
+	ErrInvalidFulfillmentStep    = defineError(409, "InvalidFulfillmentStep", "The order cannot move to this fulfillment step.")
+	ErrPickupCodeRequired        = defineError(400, "PickupCodeRequired", "Pickup and locker orders are fulfilled through the fulfillment steps and pickup code verification.")
+	ErrInvalidPickupCode         = defineError(403, "InvalidPickupCode", "The pickup code provided is not valid for this order.")
+	ErrItemsNotShipped           = defineError(409, "ItemsNotShipped", "Some items of the order have not been added to a shipment yet.")
+	ErrShipmentNotAllowed        = defineError(400, "ShipmentNotAllowed", "Pickup orders are collected in store and cannot be shipped.")
+	ErrOrderNotPacked            = defineError(409, "OrderNotPacked", "The order must be packed before it can be shipped.")
+	ErrInvalidShipmentItems      = defineError(400, "InvalidShipmentItems", "Shipment items must be order items with a quantity not exceeding what is left to ship.")
+	ErrShipmentNotFound          = defineError(404, "ShipmentNotFound", "The shipment ID provided does not exist.")
+	ErrInvalidShipmentTransition = defineError(409, "InvalidShipmentTransition", "The shipment cannot move to this status.")
+	ErrCarrierNotFound           = defineError(404, "CarrierNotFound", "The carrier is not registered for webhooks.")
+	ErrInvalidSignature          = defineError(401, "InvalidSignature", "The webhook signature is missing, expired or invalid.")
+)
+
+// Return errors
+var (
+	ErrInvalidReturnItems  = defineError(400, "InvalidReturnItems", "Returned items must be order items with a quantity not exceeding what can still be returned.")
+	ErrReturnNotFound      = defineError(404, "ReturnNotFound", "The return ID provided does not exist.")
+	ErrInvalidReturnStatus = defineError(409, "InvalidReturnStatus", "The return is not in the right status for this step.")
+)
+
+// Webhook errors
+var (
+	ErrWebhookSubscriptionNotFound = defineError(404, "WebhookSubscriptionNotFound", "The webhook subscription ID provided does not exist.")
+	ErrWebhookDeliveryNotFound     = defineError(404, "WebhookDeliveryNotFound", "The delivery ID provided is not in the subscription's delivery log.")
+)
+
+// Event stream errors
+var (
+	ErrEventsExpired = defineError(410, "EventsExpired", "Some events after the last event ID are no longer kept; reconnect without it to start from the oldest kept event.")
+)
+
+// Authentication and authorization errors
+var (
+	ErrUnauthenticated    = defineError(401, "Unauthenticated", "An API key or bearer token is required.")
+	ErrInvalidCredentials = defineError(401, "InvalidCredentials", "The API key or bearer token is invalid or expired.")
+	ErrPermissionDenied   = defineError(403, "PermissionDenied", "Your roles do not allow this operation.")
+	ErrNotResourceOwner   = defineError(403, "NotResourceOwner", "Customers can only access their own carts, orders, returns and account.")
+	ErrTenantMismatch     = defineError(403, "TenantMismatch", "The credentials belong to another tenant.")
+	ErrTenantNotFound     = defineError(404, "TenantNotFound", "The tenant ID provided does not exist.")
+)
+
+// wantsProblemJSON reports whether the client asked for RFC 7807 problem details
+func wantsProblemJSON(c *fiber.Ctx) bool {
+	return problemJSON || strings.Contains(c.Get(fiber.HeaderAccept), "application/problem+json")
+}
+
+// Render every error as problem+json, e.g. with PROBLEM_JSON=true
+var problemJSON = false
+
+// ErrorHandler renders errors returned by handlers. API errors are rendered in
+// the {"error": {...}} envelope, or as RFC 7807 problem details when the client
+// accepts application/problem+json. Other errors become InternalError without
+// leaking their message.
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		var fiberErr *fiber.Error
+		switch {
+		case errors.As(err, &fiberErr) && fiberErr.Code == fiber.StatusNotFound:
+			apiErr = ErrRouteNotFound
+		case errors.As(err, &fiberErr) && fiberErr.Code == fiber.StatusMethodNotAllowed:
+			apiErr = ErrMethodNotAllowed
+		case errors.As(err, &fiberErr) && fiberErr.Code < 500:
+			apiErr = ErrInvalidRequest.WithMessage(fiberErr.Message)
+			apiErr.Status = fiberErr.Code
+		default:
+			log.Error().Err(err).Str("url.path", c.Path()).Msg("Unhandled error")
+			apiErr = ErrInternalError
+		}
+	}
+
+	if wantsProblemJSON(c) {
+		problem := fiber.Map{
+			"type":     "/v1/errors/" + apiErr.Code,
+			"title":    errorTitle(apiErr.Code),
+			"status":   apiErr.Status,
+			"detail":   apiErr.Message,
+			"instance": c.OriginalURL(),
+			"code":     apiErr.Code,
+		}
+		if apiErr.Target != "" {
+			problem["target"] = apiErr.Target
+		}
+		if apiErr.Details != nil {
+			problem["details"] = apiErr.Details
+		}
+		c.Set(fiber.HeaderContentType, "application/problem+json")
+		return c.Status(apiErr.Status).JSON(problem, "application/problem+json")
+	}
+
+	return c.Status(apiErr.Status).JSON(fiber.Map{"error": apiErr})
+}
+
+// errorTitle returns the general message of a catalog error code
+func errorTitle(code string) string {
+	if catalogErr, exists := errorCatalog[code]; exists {
+		return catalogErr.Message
+	}
+	return code
+}
+
+// GetErrorHandler describes an error code from the catalog; problem details
+// link here through their type
+func GetErrorHandler(c *fiber.Ctx) error {
+	catalogErr, exists := errorCatalog[c.Params("code")]
+	if !exists {
+		return ErrRouteNotFound.WithMessage("The error code is not in the catalog.").WithTarget("code")
+	}
+
+	return c.JSON(fiber.Map{
+		"code":    catalogErr.Code,
+		"status":  catalogErr.Status,
+		"message": catalogErr.Message,
+	})
+}
+
+// GetErrorsHandler lists the error catalog
+func GetErrorsHandler(c *fiber.Ctx) error {
+	codes := make([]string, 0, len(errorCatalog))
+	for code := range errorCatalog {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	errorList := make([]ErrorCatalogEntry, 0, len(codes))
+	for _, code := range codes {
+		catalogErr := errorCatalog[code]
+		errorList = append(errorList, ErrorCatalogEntry{Code: catalogErr.Code, Status: catalogErr.Status, Message: catalogErr.Message})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Error catalog retrieved successfully",
+		"errors":  errorList,
+	})
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// Test that handler errors keep the {"error": {...}} envelope
+func TestErrorEnvelope(t *testing.T) {
+	app := setupApp()
+
+	status, body := doJSON(t, app, http.MethodPost, "/capture-payment", `{"order_id": "order_missing_envelope"}`)
+	assert.Equal(t, 404, status)
+	assert.Equal(t, map[string]interface{}{
+		"code":    "OrderNotFound",
+		"message": "The order ID provided does not exist.",
+		"target":  "order_id",
+	}, body["error"])
+
+	status, body = doJSON(t, app, http.MethodGet, "/no-such-route", "")
+	assert.Equal(t, 404, status)
+	assert.Equal(t, "RouteNotFound", body["error"].(map[string]interface{})["code"])
+}
+
+// Test RFC 7807 problem details when the client asks for them
+func TestErrorProblemJSON(t *testing.T) {
+	app := setupApp()
+
+	req := httptest.NewRequest(http.MethodPost, "/create-cart", strings.NewReader(`{"items": [{"item_id": "item001", "quantity": 1}]}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/problem+json")
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 400, resp.StatusCode)
+	assert.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+
+	var problem map[string]interface{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+	assert.Equal(t, "/v1/errors/InvalidRequest", problem["type"])
+	assert.Equal(t, "The request is invalid.", problem["title"])
+	assert.Equal(t, float64(400), problem["status"])
+	assert.Equal(t, "One or more fields are invalid", problem["detail"])
+	assert.Equal(t, "/create-cart", problem["instance"])
+	assert.Equal(t, "customer_id", problem["target"])
+	assert.NotEmpty(t, problem["details"])
+
+	// The type links to the catalog entry
+	status, body := doJSON(t, app, http.MethodGet, "/v1/errors/InvalidRequest", "")
+	assert.Equal(t, 200, status)
+	assert.Equal(t, float64(400), body["status"])
+}
+
+// Test that unexpected errors are not leaked to the client
+func TestErrorHandlerInternalError(t *testing.T) {
+	app := setupApp()
+	app.Get("/test-internal-error", func(c *fiber.Ctx) error {
+		return errors.New("database password is wrong")
+	})
+
+	status, body := doJSON(t, app, http.MethodGet, "/test-internal-error", "")
+	assert.Equal(t, 500, status)
+	assert.Equal(t, map[string]interface{}{
+		"code":    "InternalError",
+		"message": "An unexpected error occurred.",
+	}, body["error"])
+}
+
+// Test that the catalog lists every code once with its status
+func TestErrorCatalog(t *testing.T) {
+	app := setupApp()
+
+	status, body := doJSON(t, app, http.MethodGet, "/errors", "")
+	assert.Equal(t, 200, status)
+	listed := body["errors"].([]interface{})
+	assert.Len(t, listed, len(errorCatalog))
+	assert.Equal(t, "AmountMismatch", listed[0].(map[string]interface{})["code"])
+
+	for code, catalogErr := range errorCatalog {
+		assert.Equal(t, code, catalogErr.Code)
+		assert.GreaterOrEqual(t, catalogErr.Status, 400, code)
+	}
+}
@@ -0,0 +1,595 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// Domain event types
+const (
+	EventCartCreated      = "cart.created"
+	EventPaymentProcessed = "payment.processed" // the order is created
+	EventOrderRouted      = "order.routed"
+	EventOrderShipped     = "order.shipped"
+	EventOrderFulfilled   = "order.fulfilled"
+	EventPaymentCaptured  = "payment.captured"
+	EventPaymentRefunded  = "payment.refunded" // full, partial, return and exchange credit refunds
+	EventOrderCancelled   = "order.cancelled"
+
+	// Every change of the order status, also the ones announced by an event above
+	EventOrderStatusChanged = "order.status_changed"
+)
+
+// Every domain event type, e.g. to check subscriptions
+var domainEventTypes = []string{
+	EventCartCreated, EventPaymentProcessed, EventOrderRouted, EventOrderShipped,
+	EventOrderFulfilled, EventPaymentCaptured, EventPaymentRefunded, EventOrderCancelled,
+	EventOrderStatusChanged,
+}
+
+// Struct to represent a state change other systems can react to
+type Event struct {
+	ID         string    `json:"event_id"`
+	Sequence   uint64    `json:"sequence"` // position in the outbox, set when committed
+	Type       string    `json:"type"`
+	TenantID   string    `json:"tenant_id"`
+	OrderID    string    `json:"order_id,omitempty"`
+	CustomerID string    `json:"customer_id"`
+	Status     string    `json:"status,omitempty"` // order status after the change
+	Actor      string    `json:"actor,omitempty"`  // caller of the step, see processedBy
+	OccurredAt time.Time `json:"occurred_at"`
+	Data       EventData `json:"data"`
+}
+
+// EventData is the typed payload of an event; its type names the event
+type EventData interface {
+	EventType() string
+}
+
+// Struct to represent the payload of EventCartCreated
+type CartCreated struct {
+	CartID   string  `json:"cart_id"`
+	Items    int     `json:"items"`
+	Subtotal float64 `json:"subtotal"`
+	Currency string  `json:"currency"`
+}
+
+// Struct to represent the payload of EventPaymentProcessed
+type PaymentProcessed struct {
+	Amount          float64 `json:"amount"`
+	Currency        string  `json:"currency"`
+	FulfillmentType string  `json:"fulfillment_type"`
+	ShippingMethod  string  `json:"shipping_method"`
+	ExchangeOf      string  `json:"exchange_of,omitempty"` // original order of a replacement
+}
+
+// Struct to represent the payload of EventOrderRouted
+type OrderRouted struct {
+	Location string `json:"location"` // store, DC or locker fulfilling the order
+}
+
+// Struct to represent the payload of EventOrderShipped
+type OrderShipped struct {
+	Shipments []string `json:"shipments,omitempty"` // shipment IDs, when shipped in packages
+}
+
+// Struct to represent the payload of EventOrderFulfilled
+type OrderFulfilled struct {
+	FulfillmentType string `json:"fulfillment_type"`
+	Location        string `json:"location"`
+}
+
+// Struct to represent the payload of EventPaymentCaptured
+type PaymentCaptured struct {
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency"`
+}
+
+// Struct to represent the payload of EventPaymentRefunded
+type PaymentRefunded struct {
+	RefundID       string  `json:"refund_id"`
+	Amount         float64 `json:"amount"`
+	Currency       string  `json:"currency"`
+	Method         string  `json:"method"`
+	ReturnID       string  `json:"return_id,omitempty"`
+	RefundedAmount float64 `json:"refunded_amount"` // total refunded on the order so far
+}
+
+// Struct to represent the payload of EventOrderCancelled
+type OrderCancelled struct {
+	Amount   float64 `json:"amount"` // payment released by the cancellation
+	Currency string  `json:"currency"`
+}
+
+// Struct to represent the payload of EventOrderStatusChanged; the new status
+// is the event's status
+type OrderStatusChanged struct {
+	PreviousStatus string `json:"previous_status,omitempty"` // empty for a new order
+}
+
+func (CartCreated) EventType() string        { return EventCartCreated }
+func (PaymentProcessed) EventType() string   { return EventPaymentProcessed }
+func (OrderRouted) EventType() string        { return EventOrderRouted }
+func (OrderShipped) EventType() string       { return EventOrderShipped }
+func (OrderFulfilled) EventType() string     { return EventOrderFulfilled }
+func (PaymentCaptured) EventType() string    { return EventPaymentCaptured }
+func (PaymentRefunded) EventType() string    { return EventPaymentRefunded }
+func (OrderCancelled) EventType() string     { return EventOrderCancelled }
+func (OrderStatusChanged) EventType() string { return EventOrderStatusChanged }
+
+// Fiber context key of the events a request recorded
+const eventsKey = "events"
+
+// newEvent describes a change made by the request
+func newEvent(c *fiber.Ctx, customerID string, data EventData) Event {
+	return Event{
+		ID:         uuid.New().String(),
+		Type:       data.EventType(),
+		TenantID:   tenantOf(c).ID,
+		CustomerID: customerID,
+		Actor:      processedBy(c),
+		OccurredAt: time.Now().UTC(),
+		Data:       data,
+	}
+}
+
+// recordEvent stages an event of the request. CommitEvents writes it to the
+// outbox once the handler has succeeded.
+func recordEvent(c *fiber.Ctx, event Event) {
+	events, _ := c.Locals(eventsKey).([]Event)
+	c.Locals(eventsKey, append(events, event))
+}
+
+// recordOrderEvent stages an event about an order, after the order changed
+func recordOrderEvent(c *fiber.Ctx, order *Order, data EventData) {
+	event := newEvent(c, order.Customer.CustomerID, data)
+	event.TenantID = order.TenantID
+	event.OrderID = order.ID
+	event.Status = order.Status
+	recordEvent(c, event)
+}
+
+// recordStatusChange stages an order.status_changed event when the order
+// has moved on from the previous status
+func recordStatusChange(c *fiber.Ctx, order *Order, previous string) {
+	if order.Status != previous {
+		recordOrderEvent(c, order, OrderStatusChanged{PreviousStatus: previous})
+	}
+}
+
+// recordRefundEvent stages the events of a refund recorded on an order that
+// had the previous status
+func recordRefundEvent(c *fiber.Ctx, order *Order, refund Refund, previous string) {
+	recordOrderEvent(c, order, PaymentRefunded{
+		RefundID:       refund.RefundID,
+		Amount:         refund.Amount,
+		Currency:       order.Currency,
+		Method:         refund.Method,
+		ReturnID:       refund.ReturnID,
+		RefundedAmount: order.RefundedAmount,
+	})
+	recordStatusChange(c, order, previous)
+}
+
+// recordFulfillmentEvents stages the events of a fulfillment step the order
+// has just moved to
+func recordFulfillmentEvents(c *fiber.Ctx, order *Order, step string) {
+	if step == StepShipped {
+		shipped := OrderShipped{}
+		for _, shipment := range order.Shipments {
+			shipped.Shipments = append(shipped.Shipments, shipment.ShipmentID)
+		}
+		recordOrderEvent(c, order, shipped)
+	}
+	if order.Fulfilled && order.Fulfillment.isFinalStep(step) {
+		recordOrderEvent(c, order, OrderFulfilled{FulfillmentType: order.Fulfillment.Type, Location: order.Fulfillment.Location})
+	}
+}
+
+// CommitEvents writes the events a handler recorded to the outbox when the
+// handler succeeded, before the response is sent. The outbox is kept in memory
+// next to the orders, so a change and its events are committed together: the
+// outbox never has events of failed requests, and no successful change misses
+// its events. With a database both would be written in one transaction.
+func CommitEvents() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		err := c.Next()
+		events, _ := c.Locals(eventsKey).([]Event)
+		if err != nil || c.Response().StatusCode() >= 400 || len(events) == 0 {
+			return err
+		}
+		outbox.Append(events...)
+		return nil
+	}
+}
+
+// EventHandler reacts to an event. Returning an error has the event delivered
+// again later.
+type EventHandler func(event Event) error
+
+// Struct to represent a handler subscribed to the event bus
+type subscription struct {
+	name    string
+	types   map[string]bool // every type when empty
+	handler EventHandler
+	key     func(Event) string // events with the same key are delivered in order
+}
+
+// EventBus hands committed events to the handlers subscribed to their type
+type EventBus struct {
+	mu            sync.RWMutex
+	subscriptions []subscription
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{}
+}
+
+// Subscribe adds a handler for some event types, or every type when none
+// are given. The name identifies the handler in the outbox, so an event is
+// delivered to it once even when another handler has it retried.
+func (b *EventBus) Subscribe(name string, handler EventHandler, eventTypes ...string) {
+	b.subscribe(subscription{name: name, handler: handler}, eventTypes)
+}
+
+// SubscribeOrdered adds a handler that gets the events with the same key in
+// the order they were committed: while an event is waiting for a retry, the
+// later events with its key wait too.
+func (b *EventBus) SubscribeOrdered(name string, key func(Event) string, handler EventHandler, eventTypes ...string) {
+	b.subscribe(subscription{name: name, handler: handler, key: key}, eventTypes)
+}
+
+func (b *EventBus) subscribe(sub subscription, eventTypes []string) {
+	sub.types = make(map[string]bool)
+	for _, eventType := range eventTypes {
+		sub.types[eventType] = true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscriptions = append(b.subscriptions, sub)
+}
+
+// Unsubscribe removes a handler. Events not delivered to it yet are dropped
+// for it.
+func (b *EventBus) Unsubscribe(name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	kept := b.subscriptions[:0]
+	for _, sub := range b.subscriptions {
+		if sub.name != name {
+			kept = append(kept, sub)
+		}
+	}
+	b.subscriptions = kept
+}
+
+// subscribers returns the subscriptions of an event type
+func (b *EventBus) subscribers(eventType string) []subscription {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	var matched []subscription
+	for _, sub := range b.subscriptions {
+		if len(sub.types) == 0 || sub.types[eventType] {
+			matched = append(matched, sub)
+		}
+	}
+	return matched
+}
+
+// Delivery retries: the delay doubles from the base up to the maximum, and
+// events still failing after the last attempt are given up on
+const (
+	eventRetryBaseDelay = time.Second
+	eventRetryMaxDelay  = 5 * time.Minute
+	maxEventDeliveries  = 10
+)
+
+// Handled events stay in the outbox for streams and resumes for a day, and
+// at most this many; events still to be delivered are always kept
+const (
+	eventRetention   = 24 * time.Hour
+	maxOutboxEntries = 100000
+)
+
+// Struct to represent an event in the outbox with its delivery state
+type OutboxEntry struct {
+	Event       Event
+	Delivered   map[string]bool // subscribers that handled the event
+	Attempts    int
+	NextAttempt time.Time
+	LastError   string
+	DeliveredAt time.Time // when every subscriber had handled the event
+	Failed      bool      // given up after maxEventDeliveries attempts
+}
+
+// Outbox keeps every committed event in order, and the ones still to be
+// delivered to the event bus
+type Outbox struct {
+	mu       sync.Mutex
+	entries  []*OutboxEntry
+	pending  []*OutboxEntry
+	sequence uint64
+	wake     chan struct{}
+	watchers map[chan struct{}]bool
+}
+
+func NewOutbox() *Outbox {
+	return &Outbox{wake: make(chan struct{}, 1), watchers: make(map[chan struct{}]bool)}
+}
+
+// Events committed by the API and the handlers they are delivered to
+var (
+	outbox   = NewOutbox()
+	eventBus = NewEventBus()
+)
+
+// Append commits events to the outbox, numbering them in order, and wakes
+// the dispatcher
+func (o *Outbox) Append(events ...Event) []Event {
+	o.mu.Lock()
+	for i := range events {
+		o.sequence++
+		events[i].Sequence = o.sequence
+		entry := &OutboxEntry{Event: events[i], Delivered: make(map[string]bool)}
+		o.entries = append(o.entries, entry)
+		o.pending = append(o.pending, entry)
+	}
+	watchers := make([]chan struct{}, 0, len(o.watchers))
+	for watcher := range o.watchers {
+		watchers = append(watchers, watcher)
+	}
+	o.mu.Unlock()
+
+	for _, wake := range append(watchers, o.wake) {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+	return events
+}
+
+// Watch returns a channel signalled when events are committed, and a
+// function to stop watching. Watchers read the new events with Events.
+func (o *Outbox) Watch() (<-chan struct{}, func()) {
+	watcher := make(chan struct{}, 1)
+	o.mu.Lock()
+	o.watchers[watcher] = true
+	o.mu.Unlock()
+
+	return watcher, func() {
+		o.mu.Lock()
+		delete(o.watchers, watcher)
+		o.mu.Unlock()
+	}
+}
+
+// Events returns the committed events after a sequence number that match a
+// filter, oldest first
+func (o *Outbox) Events(after uint64, match func(Event) bool) []Event {
+	events, _ := o.EventsSince(after, match)
+	return events
+}
+
+// EventsSince is Events for readers resuming from an earlier read: it also
+// reports whether every event after the sequence number is still kept, which
+// is not the case once they were trimmed or the sequence number comes from
+// before a restart. The entries are ordered by sequence number, so the read
+// starts at the first new one rather than scanning the outbox.
+func (o *Outbox) EventsSince(after uint64, match func(Event) bool) ([]Event, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	start := sort.Search(len(o.entries), func(i int) bool {
+		return o.entries[i].Event.Sequence > after
+	})
+	var events []Event
+	for _, entry := range o.entries[start:] {
+		if match == nil || match(entry.Event) {
+			events = append(events, entry.Event)
+		}
+	}
+	return events, o.keeps(after)
+}
+
+// Keeps reports whether every event after a sequence number is still kept
+func (o *Outbox) Keeps(after uint64) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.keeps(after)
+}
+
+func (o *Outbox) keeps(after uint64) bool {
+	first := o.sequence + 1
+	if len(o.entries) > 0 {
+		first = o.entries[0].Event.Sequence
+	}
+	return after == 0 || (after+1 >= first && after <= o.sequence)
+}
+
+// Dispatch delivers the events that are due to their subscribers, retrying
+// failed deliveries with exponential backoff, and returns how many events
+// were fully delivered. Only one dispatcher may call it at a time.
+func (o *Outbox) Dispatch(bus *EventBus, now time.Time) int {
+	o.mu.Lock()
+	pending := append([]*OutboxEntry{}, o.pending...)
+	o.mu.Unlock()
+
+	// Handlers run without the lock so requests can keep committing events
+	delivered := 0
+	waiting := make(map[string]string) // ordered subscriber and key to the event holding it back
+	for _, entry := range pending {
+		subscribers := bus.subscribers(entry.Event.Type)
+		if now.Before(entry.NextAttempt) {
+			for _, sub := range subscribers {
+				if !entry.Delivered[sub.name] {
+					holdBack(waiting, sub, entry.Event)
+				}
+			}
+			continue
+		}
+
+		var failures, held []string
+		for _, sub := range subscribers {
+			if entry.Delivered[sub.name] {
+				continue
+			}
+			if earlier, waits := waiting[orderingKey(sub, entry.Event)]; waits {
+				held = append(held, fmt.Sprintf("%s: waiting for event %s", sub.name, earlier))
+				continue
+			}
+			if err := deliverEvent(sub, entry.Event); err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %v", sub.name, err))
+				holdBack(waiting, sub, entry.Event)
+				continue
+			}
+			entry.Delivered[sub.name] = true
+		}
+
+		// Events only held back behind an earlier one are not attempts: they
+		// stay due and go out in the pass that delivers the earlier event
+		o.mu.Lock()
+		if len(failures) == 0 && len(held) > 0 {
+			entry.LastError = fmt.Sprint(held)
+			o.mu.Unlock()
+			continue
+		}
+		entry.Attempts++
+		failures = append(failures, held...)
+		if len(failures) == 0 {
+			entry.LastError = ""
+			entry.DeliveredAt = now
+			delivered++
+		} else {
+			entry.LastError = fmt.Sprint(failures)
+			if entry.Attempts >= maxEventDeliveries {
+				entry.Failed = true
+			} else {
+				entry.NextAttempt = now.Add(eventRetryDelay(entry.Attempts))
+			}
+			logEventFailure(entry)
+		}
+		o.mu.Unlock()
+	}
+
+	// Keep the entries that still have to be delivered
+	o.mu.Lock()
+	kept := o.pending[:0]
+	for _, entry := range o.pending {
+		if entry.DeliveredAt.IsZero() && !entry.Failed {
+			kept = append(kept, entry)
+		}
+	}
+	o.pending = kept
+	o.trim(now)
+	o.mu.Unlock()
+	return delivered
+}
+
+// trim drops the oldest handled entries once they are past the retention
+// window or over the maximum, stopping at the first one still to be
+// delivered so the outbox keeps its order. The caller holds the lock.
+func (o *Outbox) trim(now time.Time) {
+	drop := 0
+	for _, entry := range o.entries {
+		handled := !entry.DeliveredAt.IsZero() || entry.Failed
+		expired := now.Sub(entry.Event.OccurredAt) > eventRetention
+		if !handled || (!expired && len(o.entries)-drop <= maxOutboxEntries) {
+			break
+		}
+		drop++
+	}
+	if drop > 0 {
+		o.entries = append([]*OutboxEntry{}, o.entries[drop:]...)
+	}
+}
+
+// orderingKey identifies the events an ordered subscriber gets in order, and
+// is empty for other subscribers
+func orderingKey(sub subscription, event Event) string {
+	if sub.key == nil {
+		return ""
+	}
+	return sub.name + "/" + sub.key(event)
+}
+
+// holdBack makes the later events with the key of an undelivered event wait
+func holdBack(waiting map[string]string, sub subscription, event Event) {
+	key := orderingKey(sub, event)
+	if _, held := waiting[key]; key != "" && !held {
+		waiting[key] = event.ID
+	}
+}
+
+// deliverEvent runs a handler, turning a panic into a failed delivery
+func deliverEvent(sub subscription, event Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return sub.handler(event)
+}
+
+// eventRetryDelay is the wait after a number of failed attempts
+func eventRetryDelay(attempts int) time.Duration {
+	delay := eventRetryBaseDelay << (attempts - 1)
+	if delay <= 0 || delay > eventRetryMaxDelay {
+		return eventRetryMaxDelay
+	}
+	return delay
+}
+
+func logEventFailure(entry *OutboxEntry) {
+	logEvent := log.Warn()
+	if entry.Failed {
+		logEvent = log.Error()
+	}
+	logEvent.
+		Str("event.id", entry.Event.ID).
+		Str("event.action", entry.Event.Type).
+		Str("order.id", entry.Event.OrderID).
+		Int("event.attempts", entry.Attempts).
+		Bool("event.failed", entry.Failed).
+		Msgf("Event delivery failed: %s", entry.LastError)
+}
+
+// RunDispatcher delivers outbox events until the context is cancelled, as
+// soon as they are committed and on every interval for retries. It returns
+// once its last Dispatch is over, so the caller can dispatch after it.
+func RunDispatcher(ctx context.Context, outbox *Outbox, bus *EventBus, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		outbox.Dispatch(bus, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-outbox.wake:
+		}
+	}
+}
+
+// LogEventHandler writes every event to the log
+func LogEventHandler(event Event) error {
+	log.Info().
+		Str("event.id", event.ID).
+		Str("event.action", event.Type).
+		Str("tenant.id", event.TenantID).
+		Str("order.id", event.OrderID).
+		Str("customer.id", event.CustomerID).
+		Uint64("event.sequence", event.Sequence).
+		Msg("Domain event published")
+	return nil
+}
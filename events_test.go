@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// orderEvents returns the committed events of an order
+func orderEvents(orderID string) []Event {
+	return outbox.Events(0, func(event Event) bool { return event.OrderID == orderID })
+}
+
+func eventTypes(events []Event) []string {
+	types := make([]string, 0, len(events))
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+// Test that each workflow step commits its event, and failed steps none
+func TestOrderLifecycleEvents(t *testing.T) {
+	app := setupApp()
+	createPaidOrder(t, app, "cust_events", "order_events", nil)
+
+	cartEvents := outbox.Events(0, func(event Event) bool {
+		return event.Type == EventCartCreated && event.CustomerID == "cust_events"
+	})
+	assert.Len(t, cartEvents, 1)
+	assert.Equal(t, 2, cartEvents[0].Data.(CartCreated).Items)
+
+	// Capturing before fulfillment fails and records nothing
+	status, _ := doJSON(t, app, http.MethodPost, "/v1/orders/order_events/capture", "")
+	assert.Equal(t, 400, status)
+
+	for _, action := range []string{"route", "fulfill", "capture", "refund"} {
+		status, _ = doJSON(t, app, http.MethodPost, "/v1/orders/order_events/"+action, "")
+		assert.Equal(t, 200, status, action)
+	}
+
+	var events, statusChanges []Event
+	for _, event := range orderEvents("order_events") {
+		if event.Type == EventOrderStatusChanged {
+			statusChanges = append(statusChanges, event)
+		} else {
+			events = append(events, event)
+		}
+	}
+	assert.Equal(t, []string{
+		EventPaymentProcessed, EventOrderRouted, EventOrderShipped, EventOrderFulfilled, EventPaymentCaptured, EventPaymentRefunded,
+	}, eventTypes(events))
+	for i, event := range events {
+		assert.Equal(t, "cust_events", event.CustomerID)
+		assert.Equal(t, defaultTenantID, event.TenantID)
+		assert.Equal(t, "System", event.Actor)
+		if i > 0 {
+			assert.Greater(t, event.Sequence, events[i-1].Sequence)
+		}
+	}
+	assert.Equal(t, 1100.0, events[0].Data.(PaymentProcessed).Amount)
+	assert.Equal(t, "Order Routed", events[1].Status)
+	assert.Equal(t, defaultDistributionCenter, events[1].Data.(OrderRouted).Location)
+	assert.Equal(t, RefundToPayment, events[5].Data.(PaymentRefunded).Method)
+	assert.Equal(t, "Payment Refunded", events[5].Status)
+
+	// Every status the order went through has its status change
+	var statuses []string
+	for _, event := range statusChanges {
+		statuses = append(statuses, event.Data.(OrderStatusChanged).PreviousStatus+" -> "+event.Status)
+	}
+	assert.Equal(t, []string{
+		" -> Payment Processed", "Payment Processed -> Order Routed", "Order Routed -> Items Picked",
+		"Items Picked -> Items Packed", "Items Packed -> Fulfillment Completed",
+		"Fulfillment Completed -> Payment Captured", "Payment Captured -> Payment Refunded",
+	}, statuses)
+}
+
+// Test that failed deliveries are retried with backoff for that subscriber only
+func TestOutboxDispatchRetries(t *testing.T) {
+	box := NewOutbox()
+	bus := NewEventBus()
+	delivered := map[string]int{}
+	bus.Subscribe("audit", func(event Event) error {
+		delivered["audit"]++
+		return nil
+	})
+	bus.Subscribe("routing", func(event Event) error {
+		delivered["routing"]++
+		if delivered["routing"] == 1 {
+			return errors.New("downstream unavailable")
+		}
+		return nil
+	}, EventOrderRouted)
+
+	now := time.Now()
+	box.Append(Event{ID: "evt_routed", Type: EventOrderRouted, OccurredAt: now}, Event{ID: "evt_captured", Type: EventPaymentCaptured, OccurredAt: now})
+	assert.Equal(t, 1, box.Dispatch(bus, now))
+	assert.Equal(t, map[string]int{"audit": 2, "routing": 1}, delivered)
+	assert.Equal(t, "[routing: downstream unavailable]", box.entries[0].LastError)
+
+	// Not retried before the backoff, and then only to the failed subscriber
+	assert.Equal(t, 0, box.Dispatch(bus, now.Add(500*time.Millisecond)))
+	assert.Equal(t, 1, box.Dispatch(bus, now.Add(eventRetryBaseDelay)))
+	assert.Equal(t, map[string]int{"audit": 2, "routing": 2}, delivered)
+	assert.Empty(t, box.pending)
+
+	// Events still failing after the last attempt are given up on
+	bus.Subscribe("broken", func(event Event) error { return errors.New("always failing") }, EventOrderCancelled)
+	box.Append(Event{ID: "evt_cancelled", Type: EventOrderCancelled, OccurredAt: now})
+	for i := 0; i < maxEventDeliveries; i++ {
+		box.Dispatch(bus, now.Add(time.Duration(i)*time.Hour))
+	}
+	assert.True(t, box.entries[2].Failed)
+	assert.Equal(t, maxEventDeliveries, box.entries[2].Attempts)
+	assert.Empty(t, box.pending)
+
+	assert.Equal(t, []uint64{1, 2, 3}, []uint64{box.entries[0].Event.Sequence, box.entries[1].Event.Sequence, box.entries[2].Event.Sequence})
+}
+
+// Test that the retry delay doubles up to the maximum
+func TestEventRetryDelay(t *testing.T) {
+	assert.Equal(t, time.Second, eventRetryDelay(1))
+	assert.Equal(t, 4*time.Second, eventRetryDelay(3))
+	assert.Equal(t, eventRetryMaxDelay, eventRetryDelay(20))
+	assert.Equal(t, eventRetryMaxDelay, eventRetryDelay(100))
+}
+
+// Test that handled events are trimmed after the retention window or over
+// the maximum, but events still to be delivered are kept
+func TestOutboxRetention(t *testing.T) {
+	box := NewOutbox()
+	bus := NewEventBus()
+	bus.Subscribe("routing", func(event Event) error { return errors.New("downstream unavailable") }, EventOrderRouted)
+
+	now := time.Now()
+	old := now.Add(-eventRetention - time.Hour)
+	box.Append(
+		Event{ID: "evt_old", Type: EventPaymentCaptured, OccurredAt: old},
+		Event{ID: "evt_old_pending", Type: EventOrderRouted, OccurredAt: old},
+		Event{ID: "evt_old_after_pending", Type: EventPaymentCaptured, OccurredAt: old},
+	)
+	box.Dispatch(bus, now)
+	assert.Equal(t, []string{"evt_old_pending", "evt_old_after_pending"}, eventIDs(box.Events(0, nil)))
+
+	bus.Unsubscribe("routing")
+	box.Dispatch(bus, now.Add(eventRetryBaseDelay))
+	assert.Empty(t, box.Events(0, nil))
+
+	for i := 0; i < maxOutboxEntries+5; i++ {
+		box.Append(Event{Type: EventPaymentCaptured, OccurredAt: now})
+	}
+	box.Dispatch(bus, now)
+	events := box.Events(0, nil)
+	assert.Len(t, events, maxOutboxEntries)
+	assert.Equal(t, uint64(9), events[0].Sequence)
+
+	// Readers resuming after trimmed events, or after events never committed,
+	// are told so
+	last := uint64(maxOutboxEntries + 8)
+	assert.True(t, box.Keeps(0))
+	assert.True(t, box.Keeps(8))
+	assert.False(t, box.Keeps(7))
+	assert.True(t, box.Keeps(last))
+	assert.False(t, box.Keeps(last+1))
+	resumed, kept := box.EventsSince(last-2, nil)
+	assert.True(t, kept)
+	assert.Equal(t, []uint64{last - 1, last}, []uint64{resumed[0].Sequence, resumed[1].Sequence})
+}
+
+func eventIDs(events []Event) []string {
+	ids := make([]string, 0, len(events))
+	for _, event := range events {
+		ids = append(ids, event.ID)
+	}
+	return ids
+}
+
+// Test that the dispatcher returns once cancelled, so a final dispatch
+// cannot run alongside it
+func TestRunDispatcherStops(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		RunDispatcher(ctx, NewOutbox(), NewEventBus(), time.Hour)
+		close(stopped)
+	}()
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("dispatcher did not stop")
+	}
+}
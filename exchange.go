@@ -0,0 +1,152 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// Request struct for exchanging returned lines for new items
+type ExchangeRequest struct {
+	OrderID     string       `json:"order_id"`
+	ReturnItems []ReturnItem `json:"return_items" validate:"required,max=50"`
+	NewItems    []Item       `json:"new_items" validate:"required,max=50"`
+	Amount      float64      `json:"amount" validate:"gte=0"` // extra payment when the new items cost more
+}
+
+// ExchangeHandler returns lines of a fulfilled order and creates a linked
+// replacement order in one step. The returned items' value pays for the
+// replacement; the customer pays any difference or gets the remainder refunded
+// once the returned items have been inspected.
+func ExchangeHandler(c *fiber.Ctx) error {
+	var exchangeReq ExchangeRequest
+
+	// Parse JSON input
+	if err := c.BodyParser(&exchangeReq); err != nil {
+		log.Warn().Msg("Invalid JSON input for /exchanges")
+		return ErrInvalidJSON
+	}
+
+	if exchangeReq.OrderID == "" {
+		log.Warn().Msg("Order ID is missing for exchange")
+		return ErrMissingOrderID.WithMessage("Order ID is required to exchange items.")
+	}
+
+	if err := checkRequest(exchangeReq); err != nil {
+		return err
+	}
+
+	// Check if order exists
+	order, exists := tenantOf(c).Orders[exchangeReq.OrderID]
+	if !exists {
+		log.Warn().Msgf("Order ID %s not found for exchange", exchangeReq.OrderID)
+		return ErrOrderNotFound.WithTarget("order_id")
+	}
+	if err := authorizeOwner(c, PermReturnsCreate, order.Customer.CustomerID); err != nil {
+		return err
+	}
+
+	if err := checkOrderReturnable(order); err != nil {
+		return err
+	}
+
+	returnItems, err := checkReturnItems(order, exchangeReq.ReturnItems)
+	if err != nil {
+		return err
+	}
+
+	// Replacement items are priced from the catalog like a new cart
+	newItems, err := catalogItems(tenantOf(c), exchangeReq.NewItems)
+	if err != nil {
+		return err
+	}
+
+	// Net the value of the returned lines against the replacement
+	returnedValue := returnRefundAmount(order, &Return{Items: returnItems})
+	orderItems, totals, _ := priceItems(tenantOf(c).tax, newItems, nil, &order.Customer, order.ShippingAddress, "")
+	newTotal := totals.GrandTotal
+	difference := roundAmount(newTotal - returnedValue)
+
+	// The customer pays the difference when the new items cost more
+	expectedPayment := math.Max(difference, 0)
+	if exchangeReq.Amount != expectedPayment {
+		log.Warn().Msgf("Exchange payment mismatch: expected %.2f, received %.2f", expectedPayment, exchangeReq.Amount)
+		return ErrAmountMismatch.WithMessage("The payment amount does not match the price difference of the exchange").WithTarget("amount").WithDetails(fiber.Map{
+			"price_difference": difference,
+			"expected_amount":  expectedPayment,
+		})
+	}
+
+	// The replacement items must be in stock like those of a new order
+	if err := takeStock(tenantOf(c), newItems); err != nil {
+		return err
+	}
+
+	credit := math.Min(returnedValue, newTotal)
+
+	// The return is approved as part of the exchange and only refunds what the
+	// replacement did not use up
+	rma := newReturn(order, returnItems, ReturnApproved)
+	rma.RefundAmount = roundAmount(returnedValue - credit)
+
+	replacement := &Order{
+		ID:          uuid.New().String(),
+		TenantID:    order.TenantID,
+		Status:      "Payment Processed",
+		Currency:    order.Currency,
+		Amount:      newTotal,
+		Totals:      totals,
+		Items:       orderItems,
+		PaymentDone: true,
+		Customer:    order.Customer,
+		ProcessedBy: processedBy(c),
+		// Replacements ship free with the original method
+		ShippingMethod:  order.ShippingMethod,
+		ShippingAddress: order.ShippingAddress,
+		Fulfillment: &Fulfillment{
+			Type:           order.Fulfillment.Type,
+			PickupLocation: order.Fulfillment.PickupLocation,
+			Step:           StepPending,
+			StepTimes:      map[string]time.Time{},
+		},
+		ExchangeOf:       order.ID,
+		ExchangeReturnID: rma.ReturnID,
+		ExchangeCredit:   credit,
+		CreatedAt:        time.Now().UTC(),
+	}
+	tenantOf(c).Orders[replacement.ID] = replacement
+	rma.ExchangeOrderID = replacement.ID
+
+	// Move the credited value off the original order so it is neither refunded
+	// again nor counted as revenue twice
+	if credit > 0 {
+		previous := order.Status
+		refund := recordRefund(order, credit, RefundToExchangeCredit, "Exchange credit to order "+replacement.ID, rma.ReturnID)
+		recordRefundEvent(c, order, refund, previous)
+	}
+	recordOrderEvent(c, replacement, PaymentProcessed{
+		Amount:          replacement.Amount,
+		Currency:        replacement.Currency,
+		FulfillmentType: replacement.Fulfillment.Type,
+		ShippingMethod:  replacement.ShippingMethod,
+		ExchangeOf:      order.ID,
+	})
+	recordStatusChange(c, replacement, "")
+
+	log.Info().
+		Str("event.action", "exchange_items").
+		Str("order.id", order.ID).
+		Str("return.id", rma.ReturnID).
+		Str("replacement_order.id", replacement.ID).
+		Float64("price_difference", difference).
+		Msg("Exchange created")
+
+	return c.Status(201).JSON(fiber.Map{
+		"message":           "Exchange created",
+		"price_difference":  difference,
+		"return":            rma,
+		"replacement_order": replacement,
+	})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper function to create a paid order that has been fulfilled
+func createFulfilledOrder(t *testing.T, app *fiber.App, customerID, orderID string) {
+	t.Helper()
+
+	createPaidOrder(t, app, customerID, orderID, nil)
+	status, _ := doJSON(t, app, http.MethodPost, "/route-order", `{"order_id": "`+orderID+`"}`)
+	assert.Equal(t, 200, status)
+	status, _ = doJSON(t, app, http.MethodPost, "/fulfill-order", `{"order_id": "`+orderID+`"}`)
+	assert.Equal(t, 200, status)
+}
+
+// Test exchanging for a cheaper item refunds the remainder after inspection
+func TestExchangeRefundsRemainder(t *testing.T) {
+	app := setupApp()
+
+	doJSON(t, app, http.MethodPost, "/products", `{"sku": "sku_exchange_small", "name": "Mouse Pad", "price": 30, "stock": 10}`)
+	reportBefore := revenueReport(defaultTenant.Orders)
+
+	createFulfilledOrder(t, app, "cust_exchange_down", "order_exchange_down")
+
+	status, body := doJSON(t, app, http.MethodPost, "/exchanges", `{
+		"order_id": "order_exchange_down",
+		"return_items": [{"item_id": "item002", "quantity": 2, "reason": "Wrong size"}],
+		"new_items": [{"item_id": "sku_exchange_small", "quantity": 1}]
+	}`)
+	assert.Equal(t, 201, status)
+	assert.Equal(t, -70.0, body["price_difference"])
+	assert.Equal(t, 9, defaultTenant.Products["sku_exchange_small"].Stock)
+
+	rma := body["return"].(map[string]interface{})
+	replacement := body["replacement_order"].(map[string]interface{})
+	returnID := rma["return_id"].(string)
+	assert.Equal(t, "approved", rma["status"])
+	assert.Equal(t, 70.0, rma["refund_amount"])
+	assert.Equal(t, replacement["ID"], rma["exchange_order_id"])
+	assert.Equal(t, "order_exchange_down", replacement["ExchangeOf"])
+	assert.Equal(t, returnID, replacement["ExchangeReturnID"])
+	assert.Equal(t, 30.0, replacement["ExchangeCredit"])
+
+	// The remainder is refunded through the return flow
+	doJSON(t, app, http.MethodPost, "/returns/"+returnID+"/receive", "{}")
+	doJSON(t, app, http.MethodPost, "/returns/"+returnID+"/inspect", "{}")
+	status, _ = doJSON(t, app, http.MethodPost, "/returns/"+returnID+"/refund", "{}")
+	assert.Equal(t, 200, status)
+
+	original := defaultTenant.Orders["order_exchange_down"]
+	assert.Equal(t, 100.0, original.RefundedAmount)
+	assert.Equal(t, RefundToExchangeCredit, original.Refunds[0].Method)
+	assert.Equal(t, RefundToPayment, original.Refunds[1].Method)
+
+	// Net revenue grows by what the customer kept: the laptop and the mouse pad
+	reportAfter := revenueReport(defaultTenant.Orders)
+	assert.InDelta(t, 1030.0, reportAfter.NetRevenue-reportBefore.NetRevenue, 0.001)
+	assert.Equal(t, 1, reportAfter.Exchanges-reportBefore.Exchanges)
+}
+
+// Test exchanging for a more expensive item requires paying the difference
+func TestExchangeChargesDifference(t *testing.T) {
+	app := setupApp()
+
+	doJSON(t, app, http.MethodPost, "/products", `{"sku": "sku_exchange_big", "name": "Gaming Mouse", "price": 150, "stock": 10}`)
+	reportBefore := revenueReport(defaultTenant.Orders)
+
+	createFulfilledOrder(t, app, "cust_exchange_up", "order_exchange_up")
+
+	payload := `{
+		"order_id": "order_exchange_up",
+		"return_items": [{"item_id": "item002", "quantity": 1, "reason": "Upgrade"}],
+		"new_items": [{"item_id": "sku_exchange_big", "quantity": 1}],
+		"amount": %s
+	}`
+
+	status, body := doJSON(t, app, http.MethodPost, "/exchanges", fmt.Sprintf(payload, "0"))
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "AmountMismatch", body["error"].(map[string]interface{})["code"])
+
+	status, body = doJSON(t, app, http.MethodPost, "/exchanges", fmt.Sprintf(payload, "100"))
+	assert.Equal(t, 201, status)
+	assert.Equal(t, 100.0, body["price_difference"])
+	assert.Equal(t, 0.0, body["return"].(map[string]interface{})["refund_amount"])
+
+	replacement := body["replacement_order"].(map[string]interface{})
+	assert.Equal(t, 150.0, replacement["Amount"])
+	assert.Equal(t, 50.0, replacement["ExchangeCredit"])
+
+	// Customer paid 1100 plus the 100 difference
+	reportAfter := revenueReport(defaultTenant.Orders)
+	assert.InDelta(t, 1200.0, reportAfter.NetRevenue-reportBefore.NetRevenue, 0.001)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Fulfillment types an order can be placed with
+const (
+	FulfillmentDelivery = "delivery" // shipped to the customer from a store or DC
+	FulfillmentPickup   = "pickup"   // click-and-collect at a store
+	FulfillmentLocker   = "locker"   // shipped to a parcel locker
+)
+
+// Fulfillment sub-steps advanced by warehouse staff
+const (
+	StepPending        = "pending"
+	StepPicked         = "picked"
+	StepPacked         = "packed"
+	StepShipped        = "shipped"
+	StepReadyForPickup = "ready_for_pickup"
+	StepCollected      = "collected"
+)
+
+// Default distribution center used when routing delivery orders
+const defaultDistributionCenter = "DC-MAIN"
+
+// Ordered sub-steps for each fulfillment type. The last step completes fulfillment.
+var fulfillmentFlows = map[string][]string{
+	FulfillmentDelivery: {StepPicked, StepPacked, StepShipped},
+	FulfillmentPickup:   {StepPicked, StepPacked, StepReadyForPickup, StepCollected},
+	FulfillmentLocker:   {StepPicked, StepPacked, StepShipped, StepReadyForPickup, StepCollected},
+}
+
+// Order status shown after each sub-step
+var fulfillmentStepStatus = map[string]string{
+	StepPicked:         "Items Picked",
+	StepPacked:         "Items Packed",
+	StepShipped:        "Order Shipped",
+	StepReadyForPickup: "Ready for Pickup",
+	StepCollected:      "Order Collected",
+}
+
+// Struct to track how and where an order is fulfilled
+type Fulfillment struct {
+	Type           string               `json:"type"`
+	Location       string               `json:"location"`        // store, DC or locker fulfilling the order
+	PickupLocation string               `json:"pickup_location"` // store or locker for pickup and locker orders
+	Step           string               `json:"step"`
+	PickupCode     string               `json:"-"` // only shown to the owning customer, never listed with the order
+	StepTimes      map[string]time.Time `json:"step_times"`
+}
+
+// requiresPickupCode reports whether the order is handed over against a pickup code
+func (f *Fulfillment) requiresPickupCode() bool {
+	return f.Type == FulfillmentPickup || f.Type == FulfillmentLocker
+}
+
+// nextStep returns the sub-step that follows the current one, or "" when done
+func (f *Fulfillment) nextStep() string {
+	flow := fulfillmentFlows[f.Type]
+	if f.Step == StepPending {
+		return flow[0]
+	}
+	for i, step := range flow {
+		if step == f.Step && i+1 < len(flow) {
+			return flow[i+1]
+		}
+	}
+	return ""
+}
+
+// isFinalStep reports whether step completes fulfillment for this type
+func (f *Fulfillment) isFinalStep(step string) bool {
+	flow := fulfillmentFlows[f.Type]
+	return flow[len(flow)-1] == step
+}
+
+// generatePickupCode returns a random 6-digit code for pickup verification
+func generatePickupCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// advanceFulfillment moves the order to step and completes it on the final step
+func advanceFulfillment(order *Order, step string) {
+	f := order.Fulfillment
+	f.Step = step
+	f.StepTimes[step] = time.Now().UTC()
+
+	if f.isFinalStep(step) {
+		order.Status = "Fulfillment Completed"
+		order.Fulfilled = true
+		return
+	}
+	order.Status = fulfillmentStepStatus[step]
+}
+
+// FulfillmentStepHandler returns a handler that advances an order to the given sub-step
+func FulfillmentStepHandler(step string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Parse JSON input
+		var payload OrderActionRequest
+		if err := parseBody(c, &payload); err != nil {
+			log.Warn().Msgf("Invalid JSON input for fulfillment step %s", step)
+			return ErrInvalidJSON
+		}
+
+		orderID := pathParam(c, "id", payload.OrderID)
+		if orderID == "" {
+			log.Warn().Msgf("Order ID is missing for fulfillment step %s", step)
+			return ErrMissingOrderID.WithMessage("Order ID is required to advance fulfillment.")
+		}
+
+		// Check if order exists
+		order, exists := tenantOf(c).Orders[orderID]
+		if !exists {
+			log.Warn().Msgf("Order ID %s not found for fulfillment step %s", orderID, step)
+			return ErrOrderNotFound.WithTarget("order_id")
+		}
+
+		if order.Cancelled {
+			log.Warn().Msgf("Order ID %s is cancelled and cannot be fulfilled", orderID)
+			return ErrOrderAlreadyCancelled.WithMessage("The order has been cancelled.").WithTarget("order_id")
+		}
+
+		// Fulfillment can only start once the order has been routed
+		f := order.Fulfillment
+		if f.Step == StepPending && order.Status != "Order Routed" {
+			log.Warn().Msgf("Order ID %s has not been routed", orderID)
+			return ErrOrderNotRouted.WithTarget("order_id")
+		}
+
+		// Ensure the requested step is the next one for this fulfillment type
+		if next := f.nextStep(); next != step {
+			log.Warn().Msgf("Invalid fulfillment step %s for Order ID %s at step %s", step, orderID, f.Step)
+			return ErrInvalidFulfillmentStep.WithMessage(fmt.Sprintf("Order with fulfillment type %s is at step %s and cannot move to %s.", f.Type, f.Step, step)).WithTarget("order_id").WithDetails(fiber.Map{
+				"fulfillment_type": f.Type,
+				"current_step":     f.Step,
+				"next_step":        next,
+			})
+		}
+
+		// Orders shipped in several packages complete the ship step through their shipments
+		if step == StepShipped && len(order.Shipments) > 0 && !allItemsShipped(order) {
+			log.Warn().Msgf("Order ID %s still has unshipped items", orderID)
+			return ErrItemsNotShipped.WithTarget("order_id")
+		}
+
+		// Pickup orders are only handed over against the customer's pickup code
+		if step == StepCollected {
+			code := payload.PickupCode
+			if code == "" || subtle.ConstantTimeCompare([]byte(code), []byte(f.PickupCode)) != 1 {
+				log.Warn().Msgf("Invalid pickup code for Order ID %s", orderID)
+				return ErrInvalidPickupCode.WithTarget("pickup_code")
+			}
+		}
+
+		// Issue the pickup code once the order is waiting for the customer
+		if step == StepReadyForPickup {
+			code, err := generatePickupCode()
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to generate pickup code")
+				return ErrInternalError.WithMessage("Failed to generate pickup code")
+			}
+			f.PickupCode = code
+		}
+
+		previous := order.Status
+		advanceFulfillment(order, step)
+		order.ProcessedBy = processedBy(c)
+		recordFulfillmentEvents(c, order, step)
+		recordStatusChange(c, order, previous)
+
+		log.Info().
+			Str("event.action", "fulfillment_"+step).
+			Str("order.id", orderID).
+			Str("fulfillment.type", f.Type).
+			Msg("Fulfillment step completed")
+
+		return c.JSON(fiber.Map{
+			"message": "Fulfillment advanced",
+			"order":   order,
+		})
+	}
+}
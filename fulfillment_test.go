@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper function to create a cart and pay for it, returning the created order
+func createPaidOrder(t *testing.T, app *fiber.App, customerID, orderID string, extra map[string]interface{}) map[string]interface{} {
+	t.Helper()
+
+	status, _ := doJSON(t, app, http.MethodPost, "/create-cart", `{
+		"customer_id": "`+customerID+`",
+		"items": [
+			{"item_id": "item001", "quantity": 1},
+			{"item_id": "item002", "quantity": 2}
+		]
+	}`)
+	assert.Equal(t, 200, status)
+
+	paymentPayload := map[string]interface{}{
+		"order_id": orderID,
+		"amount":   1100,
+		"billing_address": map[string]interface{}{
+			"customer_id": customerID,
+			"name":        "John Doe",
+			"email":       "john@example.com",
+			"phone":       "+15555555555",
+			"country":     "US",
+		},
+	}
+	for k, v := range extra {
+		paymentPayload[k] = v
+	}
+	paymentPayloadBytes, _ := json.Marshal(paymentPayload)
+
+	status, body := doJSON(t, app, http.MethodPost, "/process-payment", string(paymentPayloadBytes))
+	assert.Equal(t, 200, status)
+
+	return body["order"].(map[string]interface{})
+}
+
+// Test the click-and-collect flow including pickup code verification
+func TestPickupFulfillmentRequiresPickupCode(t *testing.T) {
+	app := setupApp()
+
+	createPaidOrder(t, app, "cust_pickup", "order_pickup", map[string]interface{}{
+		"fulfillment_type": "pickup",
+		"pickup_location":  "STORE-JKT-01",
+	})
+
+	orderPayload := `{"order_id": "order_pickup"}`
+
+	status, body := doJSON(t, app, http.MethodPost, "/route-order", orderPayload)
+	assert.Equal(t, 200, status)
+	fulfillment := body["order"].(map[string]interface{})["Fulfillment"].(map[string]interface{})
+	assert.Equal(t, "STORE-JKT-01", fulfillment["location"])
+
+	status, _ = doJSON(t, app, http.MethodPost, "/fulfillment/pick", orderPayload)
+	assert.Equal(t, 200, status)
+	status, _ = doJSON(t, app, http.MethodPost, "/fulfillment/pack", orderPayload)
+	assert.Equal(t, 200, status)
+
+	// Pickup orders cannot be shipped
+	status, body = doJSON(t, app, http.MethodPost, "/fulfillment/ship", orderPayload)
+	assert.Equal(t, 409, status)
+	assert.Equal(t, "InvalidFulfillmentStep", body["error"].(map[string]interface{})["code"])
+
+	status, body = doJSON(t, app, http.MethodPost, "/fulfillment/ready-for-pickup", orderPayload)
+	assert.Equal(t, 200, status)
+	assert.NotContains(t, body, "pickup_code")
+
+	// Without authentication the order shows the pickup code
+	status, body = doJSON(t, app, http.MethodGet, "/v1/orders/order_pickup", "")
+	assert.Equal(t, 200, status)
+	pickupCode := body["pickup_code"].(string)
+	assert.Len(t, pickupCode, 6)
+
+	// With authentication, only the owning customer and staff see it
+	authApp := setupAuthApp(&AuthConfig{JWTSecret: rbacSecret})
+	for _, token := range []string{rbacToken(t, "user_pickup", "cust_pickup", "customer"), rbacToken(t, "user_pickup_staff", "", "warehouse")} {
+		status, body = doAuthJSON(t, authApp, http.MethodGet, "/v1/orders/order_pickup", token, "")
+		assert.Equal(t, 200, status)
+		assert.Equal(t, pickupCode, body["pickup_code"])
+	}
+	status, body = doAuthJSON(t, authApp, http.MethodGet, "/v1/orders/order_pickup", rbacToken(t, "user_pickup_other", "cust_pickup_other", "customer"), "")
+	assert.Equal(t, 403, status)
+	assert.NotContains(t, body, "pickup_code")
+
+	status, body = doJSON(t, app, http.MethodPost, "/fulfillment/collect", `{"order_id": "order_pickup", "pickup_code": "wrong"}`)
+	assert.Equal(t, 403, status)
+	assert.Equal(t, "InvalidPickupCode", body["error"].(map[string]interface{})["code"])
+	assert.False(t, defaultTenant.Orders["order_pickup"].Fulfilled)
+
+	status, body = doJSON(t, app, http.MethodPost, "/fulfillment/collect", `{"order_id": "order_pickup", "pickup_code": "`+pickupCode+`"}`)
+	assert.Equal(t, 200, status)
+	order := body["order"].(map[string]interface{})
+	assert.Equal(t, true, order["Fulfilled"])
+	assert.Equal(t, "Fulfillment Completed", order["Status"])
+}
+
+// Test that delivery orders advance step by step and the legacy endpoint rejects pickup orders
+func TestDeliveryFulfillmentSteps(t *testing.T) {
+	app := setupApp()
+
+	createPaidOrder(t, app, "cust_delivery", "order_delivery", nil)
+	orderPayload := `{"order_id": "order_delivery"}`
+
+	// Steps cannot start before routing
+	status, body := doJSON(t, app, http.MethodPost, "/fulfillment/pick", orderPayload)
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "OrderNotRouted", body["error"].(map[string]interface{})["code"])
+
+	status, body = doJSON(t, app, http.MethodPost, "/route-order", `{"order_id": "order_delivery", "fulfillment_location": "STORE-BDG-02"}`)
+	assert.Equal(t, 200, status)
+	fulfillment := body["order"].(map[string]interface{})["Fulfillment"].(map[string]interface{})
+	assert.Equal(t, "STORE-BDG-02", fulfillment["location"])
+
+	// Packing before picking is rejected
+	status, _ = doJSON(t, app, http.MethodPost, "/fulfillment/pack", orderPayload)
+	assert.Equal(t, 409, status)
+
+	for _, path := range []string{"/fulfillment/pick", "/fulfillment/pack", "/fulfillment/ship"} {
+		status, body = doJSON(t, app, http.MethodPost, path, orderPayload)
+		assert.Equal(t, 200, status, path)
+	}
+	assert.Equal(t, true, body["order"].(map[string]interface{})["Fulfilled"])
+
+	createPaidOrder(t, app, "cust_locker", "order_locker", map[string]interface{}{
+		"fulfillment_type": "locker",
+		"pickup_location":  "LOCKER-17",
+	})
+	status, _ = doJSON(t, app, http.MethodPost, "/route-order", `{"order_id": "order_locker"}`)
+	assert.Equal(t, 200, status)
+
+	status, body = doJSON(t, app, http.MethodPost, "/fulfill-order", `{"order_id": "order_locker"}`)
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "PickupCodeRequired", body["error"].(map[string]interface{})["code"])
+}
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
+	"math"
 	"os"
 	"os/signal"
 	"runtime"
+	"sync"
 	"syscall"
 	"time"
 
@@ -20,47 +23,109 @@ type CustomerInfo struct {
 	Name  string
 	Email string
 	Phone string
+	// Saved addresses used at checkout when the payment leaves them out
+	BillingAddress  *BillingAddress
+	ShippingAddress *ShippingAddress
+	CreatedAt       time.Time
 }
 
 // Struct to represent Order
 type Order struct {
 	ID          string
+	TenantID    string // storefront the order was placed in
 	Status      string
-	Amount      float64
+	Amount      float64 // grand total, same as Totals.GrandTotal
+	Currency    string  // of every amount, from the tenant
 	Items       []OrderItem
 	Fulfilled   bool
 	PaymentDone bool
 	Customer    BillingAddress
-	ProcessedBy string
+	ProcessedBy string // caller of the latest workflow step, "System" without authentication
 	Refunded    bool
 	Cancelled   bool
+	Fulfillment *Fulfillment
+	Shipments   []*Shipment
+	Refunds     []Refund
+	Returns     []*Return
+	// Total refunded so far; the order is Refunded once this reaches Amount
+	RefundedAmount float64
+	// Set on replacement orders created by an exchange
+	ExchangeOf       string  // original order ID
+	ExchangeReturnID string  // return the replacement was issued for
+	ExchangeCredit   float64 // part of Amount paid with the returned items' value
+	// Shipping method selected at checkout and where delivery orders ship to
+	ShippingMethod  string
+	ShippingAddress *ShippingAddress
+	// Promotion as it was at checkout
+	Promotion *AppliedPromotion
+	// Price breakdown from the pricing pipeline
+	Totals Totals
+	// When the order was placed
+	CreatedAt time.Time
+}
+
+// How a refund is paid out
+const (
+	RefundToPayment        = "original_payment" // money back to the customer
+	RefundToExchangeCredit = "exchange_credit"  // value moved to a replacement order
+)
+
+// Struct to represent a refund issued against an order
+type Refund struct {
+	RefundID  string    `json:"refund_id"`
+	Amount    float64   `json:"amount"`
+	Method    string    `json:"method"`
+	Reason    string    `json:"reason"`
+	ReturnID  string    `json:"return_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 // Struct to store billing address details
 type BillingAddress struct {
 	CustomerID string `json:"customer_id"`
 	Name       string `json:"name"`
-	Email      string `json:"email"`
-	Phone      string `json:"phone"`
+	Email      string `json:"email" validate:"omitempty,email"`
+	Phone      string `json:"phone" validate:"omitempty,e164"`
 	Address    string `json:"address"`
 	City       string `json:"city"`
 	PostalCode string `json:"postal_code"`
-	Country    string `json:"country"`
+	Region     string `json:"region"` // state or province, used for tax rates
+	Country    string `json:"country" validate:"omitempty,country"`
 }
 
 // Struct to represent an item in the order
 type OrderItem struct {
-	ItemID   string  `json:"item_id"`
-	Name     string  `json:"name"`
-	Quantity int     `json:"quantity"`
-	Price    float64 `json:"price"`
+	ItemID       string  `json:"item_id"`
+	Name         string  `json:"name"`
+	Quantity     int     `json:"quantity"`
+	Price        float64 `json:"price"`
+	TaxClass     string  `json:"tax_class"`
+	TaxRate      float64 `json:"tax_rate"`
+	Tax          float64 `json:"tax"`           // tax on the whole line
+	TaxInclusive bool    `json:"tax_inclusive"` // tax is already part of the price
+	Discount     float64 `json:"discount"`      // coupon discount on the whole line
+	Total        float64 `json:"total"`         // line amount after discount, including tax
 }
 
 // Struct to represent payment request
 type PaymentRequest struct {
-	OrderID        string         `json:"order_id"`
-	Amount         float64        `json:"amount"`
-	BillingAddress BillingAddress `json:"billing_address"`
+	OrderID         string         `json:"order_id"`
+	Amount          float64        `json:"amount" validate:"gt=0"`
+	BillingAddress  BillingAddress `json:"billing_address" validate:"required_fields=customer_id name email phone"`
+	FulfillmentType string         `json:"fulfillment_type" validate:"omitempty,oneof=delivery pickup locker"` // delivery (default), pickup or locker
+	PickupLocation  string         `json:"pickup_location"`                                                    // store or locker ID for pickup and locker orders
+	ShippingMethod  string         `json:"shipping_method" validate:"omitempty,oneof=standard express pickup"` // standard (default), express or pickup
+	// Delivery address; defaults to the customer's saved shipping address, then the billing address
+	ShippingAddress *ShippingAddress `json:"shipping_address"`
+}
+
+// Struct to represent a request for the amount to pay for a cart
+type CartQuoteRequest struct {
+	BillingAddress  BillingAddress `json:"billing_address" validate:"required_fields=customer_id"`
+	FulfillmentType string         `json:"fulfillment_type" validate:"omitempty,oneof=delivery pickup locker"`
+	ShippingMethod  string         `json:"shipping_method" validate:"omitempty,oneof=standard express pickup"`
+	// Delivery address, resolved like in the payment request
+	ShippingAddress *ShippingAddress `json:"shipping_address"`
 }
 
 // Struct to represent create order request
@@ -68,32 +133,72 @@ type CreateOrderRequest struct {
 	OrderID        string         `json:"order_id"`
 	BillingAddress BillingAddress `json:"billing_address"`
 	Items          []OrderItem    `json:"items"`
+	// Where the order is delivered when it differs from the billing address
+	ShippingAddress *ShippingAddress `json:"shipping_address"`
 }
 
+// Request struct for the order actions. The legacy routes take the order ID in
+// the body, the /v1 routes from the path.
+type OrderActionRequest struct {
+	OrderID             string `json:"order_id"`
+	FulfillmentLocation string `json:"fulfillment_location"` // route: store or DC fulfilling a delivery order
+	PickupCode          string `json:"pickup_code"`          // collect: code handed to the customer
+}
+
+// Struct to represent a cart item; Name and Price are taken from the product catalog
 type Item struct {
-	ItemID   string  `json:"item_id"`
+	ItemID   string  `json:"item_id" validate:"required"`
 	Name     string  `json:"name"`
-	Quantity int     `json:"quantity"`
+	Quantity int     `json:"quantity" validate:"gt=0"`
 	Price    float64 `json:"price"`
+	TaxClass string  `json:"tax_class"`
+	Weight   float64 `json:"weight"`   // per unit, in kilograms
+	Discount float64 `json:"discount"` // coupon discount on the whole line
 }
 
 type Cart struct {
 	CartID     string `json:"cart_id"`
+	TenantID   string `json:"tenant_id"`
 	CustomerID string `json:"customer_id"`
 	Items      []Item `json:"items"`
+	CouponCode string `json:"coupon_code,omitempty"`
+	Currency   string `json:"currency"`
+	Totals     Totals `json:"totals"` // before tax and shipping, which depend on the address
 }
 
-// Mock in-memory storage for carts
-var carts = make(map[string]*Cart)
-
 // Request struct for creating a cart
 type CartRequest struct {
-	CustomerID string `json:"customer_id"`
-	Items      []Item `json:"items"`
+	CustomerID string `json:"customer_id" validate:"required"`
+	Items      []Item `json:"items" validate:"required,max=50"`
 }
 
-// In-memory order storage (for demo purposes)
-var orders = make(map[string]*Order)
+// roundAmount rounds a monetary amount to cents
+func roundAmount(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// recordRefund refunds amount against the order. The order counts as refunded
+// once the refunds add up to the order amount.
+func recordRefund(order *Order, amount float64, method, reason, returnID string) Refund {
+	refund := Refund{
+		RefundID:  uuid.New().String(),
+		Amount:    roundAmount(amount),
+		Method:    method,
+		Reason:    reason,
+		ReturnID:  returnID,
+		CreatedAt: time.Now().UTC(),
+	}
+	order.Refunds = append(order.Refunds, refund)
+	order.RefundedAmount = roundAmount(order.RefundedAmount + refund.Amount)
+
+	if order.RefundedAmount >= order.Amount {
+		order.Status = "Payment Refunded"
+		order.Refunded = true
+	} else {
+		order.Status = "Partially Refunded"
+	}
+	return refund
+}
 
 func CreateCartHandler(c *fiber.Ctx) error {
 	var cartReq CartRequest
@@ -101,31 +206,39 @@ func CreateCartHandler(c *fiber.Ctx) error {
 	// Parse the JSON input for cart creation
 	if err := c.BodyParser(&cartReq); err != nil {
 		log.Warn().Msg("Invalid JSON input for /create-cart")
-		return c.Status(400).JSON(fiber.Map{
-			"error": fiber.Map{
-				"code":    "InvalidRequest",
-				"message": "Invalid JSON payload",
-			},
-		})
+		return ErrInvalidJSON
 	}
 
 	// Validate cart input
-	if cartReq.CustomerID == "" || len(cartReq.Items) == 0 {
-		log.Warn().Msg("Customer ID or items missing in /create-cart request")
-		return c.Status(400).JSON(fiber.Map{
-			"error": fiber.Map{
-				"code":    "InvalidRequest",
-				"message": "Customer ID and items are required",
-			},
-		})
+	if err := checkRequest(cartReq); err != nil {
+		return err
+	}
+	if err := authorizeOwner(c, PermCartsWrite, cartReq.CustomerID); err != nil {
+		return err
+	}
+
+	// Look up name and price from the catalog, ignoring client-submitted values
+	cartItems, err := catalogItems(tenantOf(c), cartReq.Items)
+	if err != nil {
+		return err
 	}
 
-	// Create or update the cart for the customer
-	carts[cartReq.CustomerID] = &Cart{
+	// Create or update the cart for the customer; a new cart starts without a coupon code
+	cart := &Cart{
 		CartID:     uuid.New().String(),
+		TenantID:   tenantOf(c).ID,
 		CustomerID: cartReq.CustomerID,
-		Items:      cartReq.Items,
+		Items:      cartItems,
+		Currency:   tenantOf(c).Currency,
 	}
+	applyCartPromotion(cart, nil)
+	tenantOf(c).Carts[cartReq.CustomerID] = cart
+	recordEvent(c, newEvent(c, cart.CustomerID, CartCreated{
+		CartID:   cart.CartID,
+		Items:    len(cart.Items),
+		Subtotal: cart.Totals.Subtotal,
+		Currency: cart.Currency,
+	}))
 
 	log.Info().Str("event.action", "create_cart").
 		Str("customer.id", cartReq.CustomerID).
@@ -133,7 +246,79 @@ func CreateCartHandler(c *fiber.Ctx) error {
 
 	return c.JSON(fiber.Map{
 		"message": "Cart created successfully",
-		"cart":    carts[cartReq.CustomerID],
+		"cart":    tenantOf(c).Carts[cartReq.CustomerID],
+	})
+}
+
+func QuoteCartHandler(c *fiber.Ctx) error {
+	var quoteReq CartQuoteRequest
+
+	// Parse JSON input
+	if err := parseBody(c, &quoteReq); err != nil {
+		log.Warn().Msg("Invalid JSON input for /quote-cart")
+		return ErrInvalidJSON
+	}
+
+	quoteReq.BillingAddress.CustomerID = pathParam(c, "id", quoteReq.BillingAddress.CustomerID)
+	if err := authorizeOwner(c, PermCartsWrite, quoteReq.BillingAddress.CustomerID); err != nil {
+		return err
+	}
+	quoteReq.BillingAddress = withSavedBillingAddress(tenantOf(c), quoteReq.BillingAddress)
+	if err := checkRequest(quoteReq); err != nil {
+		return err
+	}
+
+	// Retrieve cart associated with the billing address
+	cart, exists := tenantOf(c).Carts[quoteReq.BillingAddress.CustomerID]
+	if !exists {
+		log.Warn().Msgf("Cart for customer ID %s not found", quoteReq.BillingAddress.CustomerID)
+		return ErrCartNotFound
+	}
+
+	if quoteReq.FulfillmentType == "" {
+		quoteReq.FulfillmentType = FulfillmentDelivery
+	}
+	if quoteReq.ShippingMethod == "" {
+		quoteReq.ShippingMethod = defaultShippingMethod(quoteReq.FulfillmentType)
+	}
+
+	// Only delivery orders ship to an address
+	var shippingAddress *ShippingAddress
+	if quoteReq.FulfillmentType == FulfillmentDelivery {
+		var err error
+		shippingAddress, err = deliveryAddress(tenantOf(c), quoteReq.ShippingAddress, quoteReq.BillingAddress)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The coupon code is checked as it will be at payment
+	var promotion *Promotion
+	if cart.CouponCode != "" {
+		var err error
+		promotion, err = checkPromotion(tenantOf(c), cart.CouponCode, cart.CustomerID, cart.Totals.Subtotal, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	// Quote the selected method when it is available
+	orderItems, totals, available := priceItems(tenantOf(c).tax, cart.Items, promotion, &quoteReq.BillingAddress, shippingAddress, quoteReq.ShippingMethod)
+	options := shippingQuotes(quoteReq.FulfillmentType, shippingCountry(&quoteReq.BillingAddress, shippingAddress), cartWeight(cart.Items), totals.discountedSubtotal())
+	if !available || !isShippingMethodAllowed(quoteReq.ShippingMethod, quoteReq.FulfillmentType) {
+		log.Warn().Msgf("Shipping method %s not available for quote", quoteReq.ShippingMethod)
+		return ErrShippingMethodUnavailable.WithTarget("shipping_method").WithDetails(fiber.Map{
+			"shipping_options": options,
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message":          "Cart quoted successfully",
+		"items":            orderItems,
+		"coupon_code":      cart.CouponCode,
+		"shipping_method":  quoteReq.ShippingMethod,
+		"shipping_options": options,
+		"totals":           totals,
 	})
 }
 
@@ -143,58 +328,95 @@ func ProcessPaymentHandler(c *fiber.Ctx) error {
 	// Parse JSON input
 	if err := c.BodyParser(&paymentReq); err != nil {
 		log.Warn().Msg("Invalid JSON input for /process-payment")
-		return c.Status(400).JSON(fiber.Map{
-			"error": fiber.Map{
-				"code":    "InvalidRequest",
-				"message": "Invalid JSON payload",
-			},
-		})
+		return ErrInvalidJSON
 	}
 
+	// Customers can only pay for their own cart
+	if err := authorizeOwner(c, PermOrdersCreate, paymentReq.BillingAddress.CustomerID); err != nil {
+		return err
+	}
+
+	// Fill in billing details saved on the customer's account
+	paymentReq.BillingAddress = withSavedBillingAddress(tenantOf(c), paymentReq.BillingAddress)
+
 	// Validate payment input
-	if paymentReq.Amount <= 0 ||
-		paymentReq.BillingAddress.CustomerID == "" ||
-		paymentReq.BillingAddress.Name == "" ||
-		paymentReq.BillingAddress.Email == "" ||
-		paymentReq.BillingAddress.Phone == "" {
-		log.Warn().Msg("Invalid parameters for /process-payment")
-		return c.Status(400).JSON(fiber.Map{
-			"error": fiber.Map{
-				"code":    "InvalidRequest",
-				"message": "Billing details and valid amount are required",
-			},
-		})
+	if err := checkRequest(paymentReq); err != nil {
+		return err
+	}
+
+	if paymentReq.FulfillmentType == "" {
+		paymentReq.FulfillmentType = FulfillmentDelivery
+	}
+	if paymentReq.FulfillmentType != FulfillmentDelivery && paymentReq.PickupLocation == "" {
+		log.Warn().Msg("Pickup location missing for /process-payment")
+		return ErrInvalidRequest.WithMessage("Pickup location is required for pickup and locker orders").WithTarget("pickup_location")
+	}
+
+	// Validate the selected shipping method
+	if paymentReq.ShippingMethod == "" {
+		paymentReq.ShippingMethod = defaultShippingMethod(paymentReq.FulfillmentType)
+	}
+	if !isShippingMethodAllowed(paymentReq.ShippingMethod, paymentReq.FulfillmentType) {
+		log.Warn().Msgf("Shipping method %s not allowed for %s orders", paymentReq.ShippingMethod, paymentReq.FulfillmentType)
+		return ErrInvalidRequest.WithMessage("Pickup orders must use the pickup shipping method, other orders standard or express").WithTarget("shipping_method")
+	}
+
+	// Only delivery orders ship to an address
+	var shippingAddress *ShippingAddress
+	if paymentReq.FulfillmentType == FulfillmentDelivery {
+		var err error
+		shippingAddress, err = deliveryAddress(tenantOf(c), paymentReq.ShippingAddress, paymentReq.BillingAddress)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Order IDs are generated unless the client sends its own, which must be
+	// new: paying again must not replace another order
+	if paymentReq.OrderID == "" {
+		paymentReq.OrderID = uuid.New().String()
+	} else if _, taken := tenantOf(c).Orders[paymentReq.OrderID]; taken {
+		log.Warn().Msgf("Order ID %s already exists", paymentReq.OrderID)
+		return ErrOrderExists.WithTarget("order_id")
 	}
 
 	// Retrieve cart associated with the billing address
-	cart, exists := carts[paymentReq.BillingAddress.CustomerID]
+	cart, exists := tenantOf(c).Carts[paymentReq.BillingAddress.CustomerID]
 	if !exists {
 		log.Warn().Msgf("Cart for customer ID %s not found", paymentReq.BillingAddress.CustomerID)
-		return c.Status(404).JSON(fiber.Map{
-			"error": fiber.Map{
-				"code":    "CartNotFound",
-				"message": "Cart for the given customer ID not found",
-			},
-		})
+		return ErrCartNotFound
+	}
+
+	// The coupon code must still be valid when the order is paid
+	var promotion *Promotion
+	if cart.CouponCode != "" {
+		var err error
+		promotion, err = checkPromotion(tenantOf(c), cart.CouponCode, cart.CustomerID, cart.Totals.Subtotal, time.Now())
+		if err != nil {
+			return err
+		}
 	}
 
-	// Calculate total cart amount
-	var totalAmount float64
-	for _, item := range cart.Items {
-		totalAmount += float64(item.Quantity) * item.Price
+	// Calculate total cart amount including discount, tax and shipping
+	orderItems, totals, available := priceItems(tenantOf(c).tax, cart.Items, promotion, &paymentReq.BillingAddress, shippingAddress, paymentReq.ShippingMethod)
+	if !available {
+		log.Warn().Msgf("Shipping method %s not available for country %s", paymentReq.ShippingMethod, shippingCountry(&paymentReq.BillingAddress, shippingAddress))
+		return ErrShippingMethodUnavailable.WithTarget("shipping_method")
 	}
 
 	// Check if the total amount matches the payment amount
-	if totalAmount != paymentReq.Amount {
-		log.Warn().Msgf("Payment amount mismatch: expected %.2f, received %.2f", totalAmount, paymentReq.Amount)
-		return c.Status(400).JSON(fiber.Map{
-			"error": fiber.Map{
-				"code":    "AmountMismatch",
-				"message": "The payment amount does not match the total cart amount",
-			},
+	if totals.GrandTotal != paymentReq.Amount {
+		log.Warn().Msgf("Payment amount mismatch: expected %.2f, received %.2f", totals.GrandTotal, paymentReq.Amount)
+		return ErrAmountMismatch.WithDetails(fiber.Map{
+			"totals": totals,
 		})
 	}
 
+	// The items must be in stock; paying takes them off it
+	if err := takeStock(tenantOf(c), cart.Items); err != nil {
+		return err
+	}
+
 	// If amounts match, process payment (In real-world scenario, integrate with payment gateway)
 	log.Info().Str("event.action", "process_payment").
 		Str("customer.id", paymentReq.BillingAddress.CustomerID).
@@ -202,59 +424,79 @@ func ProcessPaymentHandler(c *fiber.Ctx) error {
 		Msg("Payment processed successfully")
 
 	// Create the order after successful payment
-	orderID := paymentReq.OrderID // Example, should be unique
-
-	// Convert cart.Items (of type []Item) to []OrderItem
-	orderItems := make([]OrderItem, len(cart.Items))
-	for i, item := range cart.Items {
-		orderItems[i] = OrderItem(item) // Simple type conversion
+	orderID := paymentReq.OrderID
+
+	tenantOf(c).Orders[orderID] = &Order{
+		ID:              orderID,
+		TenantID:        tenantOf(c).ID,
+		Status:          "Payment Processed",
+		Amount:          totals.GrandTotal,
+		Currency:        tenantOf(c).Currency,
+		Totals:          totals,
+		Items:           orderItems,
+		PaymentDone:     true,
+		Customer:        paymentReq.BillingAddress,
+		ShippingMethod:  paymentReq.ShippingMethod,
+		ShippingAddress: shippingAddress,
+		ProcessedBy:     processedBy(c),
+		CreatedAt:       time.Now().UTC(),
+		Fulfillment: &Fulfillment{
+			Type:           paymentReq.FulfillmentType,
+			PickupLocation: paymentReq.PickupLocation,
+			Step:           StepPending,
+			StepTimes:      map[string]time.Time{},
+		},
 	}
 
-	orders[orderID] = &Order{
-		ID:          orderID,
-		Status:      "Payment Processed",
-		Amount:      totalAmount,
-		Items:       orderItems, // Use the converted orderItems
-		PaymentDone: true,
-		Customer:    paymentReq.BillingAddress,
-		ProcessedBy: "System",
+	// Snapshot the promotion so later changes to it do not affect the order
+	if promotion != nil {
+		tenantOf(c).Orders[orderID].Promotion = &AppliedPromotion{
+			Code:     promotion.Code,
+			Type:     promotion.Type,
+			Value:    promotion.Value,
+			Discount: totals.Discount,
+		}
+		recordPromotionUsage(tenantOf(c), promotion.Code, cart.CustomerID)
 	}
+	recordOrderEvent(c, tenantOf(c).Orders[orderID], PaymentProcessed{
+		Amount:          totals.GrandTotal,
+		Currency:        tenantOf(c).Currency,
+		FulfillmentType: paymentReq.FulfillmentType,
+		ShippingMethod:  paymentReq.ShippingMethod,
+	})
+	recordStatusChange(c, tenantOf(c).Orders[orderID], "")
+
+	// The cart became the order: paying again needs a new cart, so a replayed
+	// request cannot create a second order or take the stock twice
+	delete(tenantOf(c).Carts, cart.CustomerID)
 
 	return c.JSON(fiber.Map{
 		"message": "Payment processed successfully and order created",
-		"order":   orders[orderID],
+		"order":   tenantOf(c).Orders[orderID],
 	})
 }
 
 func WaitGracePeriodHandler(c *fiber.Ctx) error {
-	// Get the order ID from the query parameter
-	orderID := c.Query("order_id")
+	// Get the order ID from the path, or the query parameter on the legacy route
+	orderID := pathParam(c, "id", c.Query("order_id"))
 	if orderID == "" {
-		return c.Status(400).JSON(fiber.Map{
-			"error": fiber.Map{
-				"code":    "InvalidRequest",
-				"message": "Order ID is required",
-			},
-		})
+		return ErrInvalidRequest.WithMessage("Order ID is required")
 	}
 
 	// Check if order exists
-	order, exists := orders[orderID]
+	order, exists := tenantOf(c).Orders[orderID]
 	if !exists {
-		return c.Status(404).JSON(fiber.Map{
-			"error": fiber.Map{
-				"code":    "OrderNotFound",
-				"message": "Order not found",
-			},
-		})
+		return ErrOrderNotFound.WithMessage("Order not found")
 	}
 
-	// Simulate a grace period (e.g., 5 seconds)
+	// Simulate a grace period, configured per tenant
 	log.Info().Str("order.id", orderID).Msg("Starting grace period for order")
-	time.Sleep(5 * time.Second) // You can change this to a configurable time
+	time.Sleep(tenantOf(c).gracePeriod)
 
 	// Update the order status after grace period
+	previous := order.Status
 	order.Status = "Grace Period Completed"
+	recordStatusChange(c, order, previous)
 
 	log.Info().Str("order.id", orderID).Msg("Grace period completed for order")
 
@@ -267,55 +509,56 @@ func WaitGracePeriodHandler(c *fiber.Ctx) error {
 
 func RouteOrderHandler(c *fiber.Ctx) error {
 	// Parse JSON input
-	var payload map[string]string
-	if err := c.BodyParser(&payload); err != nil {
+	var payload OrderActionRequest
+	if err := parseBody(c, &payload); err != nil {
 		log.Warn().Msg("Invalid JSON input")
-		return c.Status(400).JSON(fiber.Map{
-			"error": fiber.Map{
-				"code":    "InvalidRequest",
-				"message": "Invalid JSON payload",
-			},
-		})
+		return ErrInvalidJSON
 	}
 
-	orderID := payload["order_id"]
+	orderID := pathParam(c, "id", payload.OrderID)
 	if orderID == "" {
 		log.Warn().Msg("Order ID is missing in the route order request")
-		return c.Status(400).JSON(fiber.Map{
-			"error": fiber.Map{
-				"code":    "MissingOrderID",
-				"message": "Order ID is required to route the order.",
-			},
-		})
+		return ErrMissingOrderID.WithMessage("Order ID is required to route the order.")
 	}
 
 	// Check if order exists
-	order, exists := orders[orderID]
+	order, exists := tenantOf(c).Orders[orderID]
 	if !exists {
 		log.Warn().Msgf("Order ID %s not found", orderID)
-		return c.Status(404).JSON(fiber.Map{
-			"error": fiber.Map{
-				"code":    "OrderNotFound",
-				"message": "The order ID provided does not exist.",
-				"target":  "order_id",
-				"details": fiber.Map{
-					"order_id": orderID,
-				},
-			},
+		return ErrOrderNotFound.WithTarget("order_id").WithDetails(fiber.Map{
+			"order_id": orderID,
 		})
 	}
 
 	// Simulate routing success or failure
 	success := true // This would be replaced by real routing logic
 	if success {
+		// Pickup and locker orders are fulfilled where the customer collects them,
+		// delivery orders ship from the requested store or the DC serving the
+		// shipping address
+		switch {
+		case order.Fulfillment.Type != FulfillmentDelivery:
+			order.Fulfillment.Location = order.Fulfillment.PickupLocation
+		case payload.FulfillmentLocation != "":
+			order.Fulfillment.Location = payload.FulfillmentLocation
+		default:
+			order.Fulfillment.Location = distributionCenterFor(order.ShippingAddress)
+		}
+
+		previous := order.Status
 		order.Status = "Order Routed"
+		order.ProcessedBy = processedBy(c)
+		recordOrderEvent(c, order, OrderRouted{Location: order.Fulfillment.Location})
+		recordStatusChange(c, order, previous)
 		log.Info().Msgf("Order ID %s successfully routed", orderID)
 		return c.JSON(fiber.Map{
 			"message": "Order routed",
 			"order":   order,
 		})
 	} else {
+		previous := order.Status
 		order.Status = "Routing Failed"
+		recordStatusChange(c, order, previous)
 		log.Warn().Msgf("Routing failed for Order ID %s", orderID)
 		return c.JSON(fiber.Map{
 			"message": "Routing failed, items on hold",
@@ -326,56 +569,45 @@ func RouteOrderHandler(c *fiber.Ctx) error {
 
 func FullfillOrderHandler(c *fiber.Ctx) error {
 	// Parse JSON input
-	var payload map[string]string
-	if err := c.BodyParser(&payload); err != nil {
+	var payload OrderActionRequest
+	if err := parseBody(c, &payload); err != nil {
 		log.Warn().Msg("Invalid JSON input for fulfillment")
-		return c.Status(400).JSON(fiber.Map{
-			"error": fiber.Map{
-				"code":    "InvalidRequest",
-				"message": "Invalid JSON payload",
-			},
-		})
+		return ErrInvalidJSON
 	}
 
-	orderID := payload["order_id"]
+	orderID := pathParam(c, "id", payload.OrderID)
 	if orderID == "" {
 		log.Warn().Msg("Order ID is missing for fulfillment")
-		return c.Status(400).JSON(fiber.Map{
-			"error": fiber.Map{
-				"code":    "MissingOrderID",
-				"message": "Order ID is required to process fulfillment.",
-			},
-		})
+		return ErrMissingOrderID.WithMessage("Order ID is required to process fulfillment.")
 	}
 
 	// Check if order exists and has been routed
-	order, exists := orders[orderID]
+	order, exists := tenantOf(c).Orders[orderID]
 	if !exists {
 		log.Warn().Msgf("Order ID %s not found for fulfillment", orderID)
-		return c.Status(404).JSON(fiber.Map{
-			"error": fiber.Map{
-				"code":    "OrderNotFound",
-				"message": "The order ID provided does not exist.",
-				"target":  "order_id",
-			},
-		})
+		return ErrOrderNotFound.WithTarget("order_id")
 	}
 
 	// Ensure the order has been routed before fulfillment
 	if order.Status != "Order Routed" {
 		log.Warn().Msgf("Order ID %s has not been routed", orderID)
-		return c.Status(400).JSON(fiber.Map{
-			"error": fiber.Map{
-				"code":    "OrderNotRouted",
-				"message": "The order must be routed before fulfillment.",
-				"target":  "order_id",
-			},
-		})
+		return ErrOrderNotRouted.WithTarget("order_id")
 	}
 
-	// Simulate fulfillment
-	order.Status = "Fulfillment Completed"
-	order.Fulfilled = true
+	// Pickup and locker orders are only fulfilled once collected with the pickup code
+	if order.Fulfillment.requiresPickupCode() {
+		log.Warn().Msgf("Order ID %s requires pickup code verification", orderID)
+		return ErrPickupCodeRequired.WithTarget("order_id")
+	}
+
+	// Simulate fulfillment by completing all delivery steps at once
+	order.ProcessedBy = processedBy(c)
+	for _, step := range fulfillmentFlows[FulfillmentDelivery] {
+		previous := order.Status
+		advanceFulfillment(order, step)
+		recordFulfillmentEvents(c, order, step)
+		recordStatusChange(c, order, previous)
+	}
 
 	// Log successful fulfillment
 	log.Info().
@@ -391,56 +623,38 @@ func FullfillOrderHandler(c *fiber.Ctx) error {
 
 func CapturePaymentHandler(c *fiber.Ctx) error {
 	// Parse JSON input
-	var payload map[string]string
-	if err := c.BodyParser(&payload); err != nil {
+	var payload OrderActionRequest
+	if err := parseBody(c, &payload); err != nil {
 		log.Warn().Msg("Invalid JSON input for payment capture")
-		return c.Status(400).JSON(fiber.Map{
-			"error": fiber.Map{
-				"code":    "InvalidRequest",
-				"message": "Invalid JSON payload",
-			},
-		})
+		return ErrInvalidJSON
 	}
 
-	orderID := payload["order_id"]
+	orderID := pathParam(c, "id", payload.OrderID)
 	if orderID == "" {
 		log.Warn().Msg("Order ID is missing for payment capture")
-		return c.Status(400).JSON(fiber.Map{
-			"error": fiber.Map{
-				"code":    "MissingOrderID",
-				"message": "Order ID is required to capture the payment.",
-			},
-		})
+		return ErrMissingOrderID.WithMessage("Order ID is required to capture the payment.")
 	}
 
 	// Check if order exists
-	order, exists := orders[orderID]
+	order, exists := tenantOf(c).Orders[orderID]
 	if !exists {
 		log.Warn().Msgf("Order ID %s not found for payment capture", orderID)
-		return c.Status(404).JSON(fiber.Map{
-			"error": fiber.Map{
-				"code":    "OrderNotFound",
-				"message": "The order ID provided does not exist.",
-				"target":  "order_id",
-			},
-		})
+		return ErrOrderNotFound.WithTarget("order_id")
 	}
 
 	// Ensure the order is fulfilled before capturing payment
 	if !order.Fulfilled {
 		log.Warn().Msgf("Order ID %s has not been fulfilled yet", orderID)
-		return c.Status(400).JSON(fiber.Map{
-			"error": fiber.Map{
-				"code":    "OrderNotFulfilled",
-				"message": "The order must be fulfilled before capturing payment.",
-				"target":  "order_id",
-			},
-		})
+		return ErrOrderNotFulfilled.WithMessage("The order must be fulfilled before capturing payment.").WithTarget("order_id")
 	}
 
 	// Capture the payment
+	previous := order.Status
 	order.Status = "Payment Captured"
 	order.PaymentDone = true
+	order.ProcessedBy = processedBy(c)
+	recordOrderEvent(c, order, PaymentCaptured{Amount: order.Amount, Currency: order.Currency})
+	recordStatusChange(c, order, previous)
 
 	// Log successful payment capture
 	log.Info().
@@ -456,67 +670,41 @@ func CapturePaymentHandler(c *fiber.Ctx) error {
 
 func RefundPaymentHandler(c *fiber.Ctx) error {
 	// Parse JSON input
-	var payload map[string]string
-	if err := c.BodyParser(&payload); err != nil {
+	var payload OrderActionRequest
+	if err := parseBody(c, &payload); err != nil {
 		log.Warn().Msg("Invalid JSON input for refund payment")
-		return c.Status(400).JSON(fiber.Map{
-			"error": fiber.Map{
-				"code":    "InvalidRequest",
-				"message": "Invalid JSON payload",
-			},
-		})
+		return ErrInvalidJSON
 	}
 
-	orderID := payload["order_id"]
+	orderID := pathParam(c, "id", payload.OrderID)
 	if orderID == "" {
 		log.Warn().Msg("Order ID is missing for refund payment")
-		return c.Status(400).JSON(fiber.Map{
-			"error": fiber.Map{
-				"code":    "MissingOrderID",
-				"message": "Order ID is required to process the refund.",
-			},
-		})
+		return ErrMissingOrderID.WithMessage("Order ID is required to process the refund.")
 	}
 
 	// Check if order exists
-	order, exists := orders[orderID]
+	order, exists := tenantOf(c).Orders[orderID]
 	if !exists {
 		log.Warn().Msgf("Order ID %s not found for refund", orderID)
-		return c.Status(404).JSON(fiber.Map{
-			"error": fiber.Map{
-				"code":    "OrderNotFound",
-				"message": "The order ID provided does not exist.",
-				"target":  "order_id",
-			},
-		})
+		return ErrOrderNotFound.WithTarget("order_id")
 	}
 
 	// Check if payment has been made and the refund has not already been processed
 	if !order.PaymentDone {
 		log.Warn().Msgf("Payment was not processed for Order ID %s", orderID)
-		return c.Status(400).JSON(fiber.Map{
-			"error": fiber.Map{
-				"code":    "PaymentNotProcessed",
-				"message": "Payment has not been processed for this order.",
-				"target":  "order_id",
-			},
-		})
+		return ErrPaymentNotProcessed.WithTarget("order_id")
 	}
 
 	if order.Refunded {
 		log.Warn().Msgf("Payment has already been refunded for Order ID %s", orderID)
-		return c.Status(400).JSON(fiber.Map{
-			"error": fiber.Map{
-				"code":    "PaymentAlreadyRefunded",
-				"message": "Payment has already been refunded for this order.",
-				"target":  "order_id",
-			},
-		})
+		return ErrPaymentAlreadyRefunded.WithTarget("order_id")
 	}
 
-	// Refund the payment
-	order.Status = "Payment Refunded"
-	order.Refunded = true
+	// Refund whatever has not been refunded through returns yet
+	previous := order.Status
+	refund := recordRefund(order, order.Amount-order.RefundedAmount, RefundToPayment, "Full refund", "")
+	order.ProcessedBy = processedBy(c)
+	recordRefundEvent(c, order, refund, previous)
 
 	// Log successful refund
 	log.Info().
@@ -532,67 +720,46 @@ func RefundPaymentHandler(c *fiber.Ctx) error {
 
 func CancelOrderHandler(c *fiber.Ctx) error {
 	// Parse JSON input
-	var payload map[string]string
-	if err := c.BodyParser(&payload); err != nil {
+	var payload OrderActionRequest
+	if err := parseBody(c, &payload); err != nil {
 		log.Warn().Msg("Invalid JSON input for order cancellation")
-		return c.Status(400).JSON(fiber.Map{
-			"error": fiber.Map{
-				"code":    "InvalidRequest",
-				"message": "Invalid JSON payload",
-			},
-		})
+		return ErrInvalidJSON
 	}
 
-	orderID := payload["order_id"]
+	orderID := pathParam(c, "id", payload.OrderID)
 	if orderID == "" {
 		log.Warn().Msg("Order ID is missing for cancellation")
-		return c.Status(400).JSON(fiber.Map{
-			"error": fiber.Map{
-				"code":    "MissingOrderID",
-				"message": "Order ID is required to cancel the order.",
-			},
-		})
+		return ErrMissingOrderID.WithMessage("Order ID is required to cancel the order.")
 	}
 
 	// Check if order exists
-	order, exists := orders[orderID]
+	order, exists := tenantOf(c).Orders[orderID]
 	if !exists {
 		log.Warn().Msgf("Order ID %s not found for cancellation", orderID)
-		return c.Status(404).JSON(fiber.Map{
-			"error": fiber.Map{
-				"code":    "OrderNotFound",
-				"message": "The order ID provided does not exist.",
-				"target":  "order_id",
-			},
-		})
+		return ErrOrderNotFound.WithTarget("order_id")
+	}
+	if err := authorizeOwner(c, PermOrdersCancel, order.Customer.CustomerID); err != nil {
+		return err
 	}
 
 	// Check if order is already fulfilled or cancelled
 	if order.Fulfilled {
 		log.Warn().Msgf("Order ID %s has already been fulfilled and cannot be cancelled", orderID)
-		return c.Status(400).JSON(fiber.Map{
-			"error": fiber.Map{
-				"code":    "OrderAlreadyFulfilled",
-				"message": "The order has already been fulfilled and cannot be cancelled.",
-				"target":  "order_id",
-			},
-		})
+		return ErrOrderAlreadyFulfilled.WithTarget("order_id")
 	}
 
 	if order.Cancelled {
 		log.Warn().Msgf("Order ID %s has already been cancelled", orderID)
-		return c.Status(400).JSON(fiber.Map{
-			"error": fiber.Map{
-				"code":    "OrderAlreadyCancelled",
-				"message": "The order has already been cancelled.",
-				"target":  "order_id",
-			},
-		})
+		return ErrOrderAlreadyCancelled.WithTarget("order_id")
 	}
 
 	// Cancel the order
+	previous := order.Status
 	order.Status = "Order Cancelled"
 	order.Cancelled = true
+	order.ProcessedBy = processedBy(c)
+	recordOrderEvent(c, order, OrderCancelled{Amount: order.Amount, Currency: order.Currency})
+	recordStatusChange(c, order, previous)
 
 	// Log successful cancellation
 	log.Info().
@@ -607,12 +774,23 @@ func CancelOrderHandler(c *fiber.Ctx) error {
 }
 
 func GetOrdersHandler(c *fiber.Ctx) error {
+	// Customers only see their own orders
+	visible := tenantOf(c).Orders
+	if ownedOnly(c, PermOrdersRead) {
+		visible = make(map[string]*Order)
+		for id, order := range tenantOf(c).Orders {
+			if isOwnedBy(c, order.Customer.CustomerID) {
+				visible[id] = order
+			}
+		}
+	}
+
 	// If no orders are available, return an empty list
-	if len(orders) == 0 {
+	if len(visible) == 0 {
 		log.Info().Msg("No orders available")
 		return c.JSON(fiber.Map{
 			"message": "No orders found",
-			"orders":  []Order{},
+			"orders":  map[string]*Order{},
 		})
 	}
 
@@ -620,29 +798,148 @@ func GetOrdersHandler(c *fiber.Ctx) error {
 	log.Info().Msg("Fetching all orders")
 	return c.JSON(fiber.Map{
 		"message": "All orders retrieved successfully",
-		"orders":  orders,
+		"orders":  visible,
 	})
 }
 
+func GetOrderHandler(c *fiber.Ctx) error {
+	orderID := c.Params("id")
+
+	order, exists := tenantOf(c).Orders[orderID]
+	if !exists {
+		log.Warn().Msgf("Order ID %s not found", orderID)
+		return ErrOrderNotFound.WithTarget("id")
+	}
+	if err := authorizeOwner(c, PermOrdersRead, order.Customer.CustomerID); err != nil {
+		return err
+	}
+
+	response := fiber.Map{
+		"message": "Order retrieved successfully",
+		"order":   order,
+	}
+	// The pickup code is shown to the customer collecting the order, to staff
+	// reading every order, and to everyone when authentication is off
+	principal := currentPrincipal(c)
+	canSeeCode := principal == nil || principal.grants(PermOrdersRead) || isOwnedBy(c, order.Customer.CustomerID)
+	if f := order.Fulfillment; f != nil && f.PickupCode != "" && !order.Fulfilled && canSeeCode {
+		response["pickup_code"] = f.PickupCode
+	}
+
+	return c.JSON(response)
+}
+
 // setupRoutes sets up the necessary routes for the application
 func setupRoutes(app *fiber.App) {
-	app.Post("/create-cart", CreateCartHandler)
-	app.Post("/process-payment", ProcessPaymentHandler)
-	app.Get("/wait-grace-period", WaitGracePeriodHandler)
-	app.Post("/route-order", RouteOrderHandler)
-	app.Post("/fulfill-order", FullfillOrderHandler)
-	app.Post("/capture-payment", CapturePaymentHandler)
-	app.Post("/refund-payment", RefundPaymentHandler)
-	app.Post("/cancel-order", CancelOrderHandler)
-
-	app.Get("/orders", GetOrdersHandler)
+	setupV1Routes(app.Group("/v1"))
+
+	app.Get("/openapi.json", OpenAPIHandler)
+	app.Get("/docs", DocsHandler)
+
+	// Legacy routes, kept until clients have moved to /v1
+	app.Post("/create-cart", deprecated("/v1/carts"), authorize(PermCartsWrite), CreateCartHandler)
+	app.Post("/quote-cart", deprecated("/v1/carts/{id}/quote"), authorize(PermCartsWrite), QuoteCartHandler)
+	app.Post("/apply-coupon", deprecated("/v1/carts/{id}/coupon"), authorize(PermCartsWrite), ApplyCouponHandler)
+	app.Post("/remove-coupon", deprecated("/v1/carts/{id}/coupon"), authorize(PermCartsWrite), RemoveCouponHandler)
+	app.Post("/process-payment", deprecated("/v1/orders"), authorize(PermOrdersCreate), ProcessPaymentHandler)
+	app.Get("/wait-grace-period", deprecated("/v1/orders/{id}/grace-period"), authorize(PermOrdersRoute), WaitGracePeriodHandler)
+	app.Post("/route-order", deprecated("/v1/orders/{id}/route"), authorize(PermOrdersRoute), RouteOrderHandler)
+	app.Post("/fulfill-order", deprecated("/v1/orders/{id}/fulfill"), authorize(PermOrdersFulfill), FullfillOrderHandler)
+	app.Post("/capture-payment", deprecated("/v1/orders/{id}/capture"), authorize(PermPaymentsCapture), CapturePaymentHandler)
+	app.Post("/refund-payment", deprecated("/v1/orders/{id}/refund"), authorize(PermPaymentsRefund), RefundPaymentHandler)
+	app.Post("/cancel-order", deprecated("/v1/orders/{id}/cancel"), authorize(PermOrdersCancel), CancelOrderHandler)
+
+	app.Get("/orders", deprecated("/v1/orders"), authorize(PermOrdersRead), GetOrdersHandler)
+
+	app.Post("/fulfillment/pick", deprecated("/v1/orders/{id}/fulfillment/pick"), authorize(PermOrdersFulfill), FulfillmentStepHandler(StepPicked))
+	app.Post("/fulfillment/pack", deprecated("/v1/orders/{id}/fulfillment/pack"), authorize(PermOrdersFulfill), FulfillmentStepHandler(StepPacked))
+	app.Post("/fulfillment/ship", deprecated("/v1/orders/{id}/fulfillment/ship"), authorize(PermOrdersFulfill), FulfillmentStepHandler(StepShipped))
+	app.Post("/fulfillment/ready-for-pickup", deprecated("/v1/orders/{id}/fulfillment/ready-for-pickup"), authorize(PermOrdersFulfill), FulfillmentStepHandler(StepReadyForPickup))
+	app.Post("/fulfillment/collect", deprecated("/v1/orders/{id}/fulfillment/collect"), authorize(PermOrdersFulfill), FulfillmentStepHandler(StepCollected))
+
+	app.Post("/shipments", deprecated("/v1/shipments"), authorize(PermOrdersFulfill), CreateShipmentHandler)
+	app.Get("/shipments/:id", deprecated("/v1/shipments/{id}"), authorize(PermOrdersRead), GetShipmentHandler)
+	app.Post("/shipments/:id/status", deprecated("/v1/shipments/{id}/status"), authorize(PermOrdersFulfill), UpdateShipmentStatusHandler)
+
+	app.Post("/webhooks/carriers/:carrier", deprecated("/v1/webhooks/carriers/{carrier}"), CarrierWebhookHandler)
+
+	app.Post("/returns", deprecated("/v1/returns"), authorize(PermReturnsCreate), CreateReturnHandler)
+	app.Get("/returns/:id", deprecated("/v1/returns/{id}"), authorize(PermReturnsRead), GetReturnHandler)
+	app.Post("/returns/:id/approve", deprecated("/v1/returns/{id}/approve"), authorize(PermReturnsManage), ApproveReturnHandler)
+	app.Post("/returns/:id/reject", deprecated("/v1/returns/{id}/reject"), authorize(PermReturnsManage), RejectReturnHandler)
+	app.Post("/returns/:id/receive", deprecated("/v1/returns/{id}/receive"), authorize(PermReturnsManage), ReceiveReturnHandler)
+	app.Post("/returns/:id/inspect", deprecated("/v1/returns/{id}/inspect"), authorize(PermReturnsManage), InspectReturnHandler)
+	app.Post("/returns/:id/refund", deprecated("/v1/returns/{id}/refund"), authorize(PermPaymentsRefund), RefundReturnHandler)
+
+	app.Post("/exchanges", deprecated("/v1/exchanges"), authorize(PermReturnsCreate), ExchangeHandler)
+
+	app.Get("/reports/revenue", deprecated("/v1/reports/revenue"), authorize(PermReportsRead), RevenueReportHandler)
+
+	app.Post("/products", deprecated("/v1/products"), authorize(PermProductsWrite), CreateProductHandler)
+	app.Get("/products", deprecated("/v1/products"), authorize(PermProductsRead), GetProductsHandler)
+	app.Get("/products/:sku", deprecated("/v1/products/{sku}"), authorize(PermProductsRead), GetProductHandler)
+	app.Put("/products/:sku", deprecated("/v1/products/{sku}"), authorize(PermProductsWrite), UpdateProductHandler)
+	app.Delete("/products/:sku", deprecated("/v1/products/{sku}"), authorize(PermProductsWrite), DeleteProductHandler)
+
+	app.Post("/customers", deprecated("/v1/customers"), authorize(PermCustomersWrite), CreateCustomerHandler)
+	app.Get("/customers", deprecated("/v1/customers"), authorize(PermCustomersRead), GetCustomersHandler)
+	app.Get("/customers/:id", deprecated("/v1/customers/{id}"), authorize(PermCustomersRead), GetCustomerHandler)
+	app.Patch("/customers/:id", deprecated("/v1/customers/{id}"), authorize(PermCustomersWrite), UpdateCustomerHandler)
+	app.Get("/customers/:id/orders", deprecated("/v1/customers/{id}/orders"), authorize(PermOrdersRead), GetCustomerOrdersHandler)
+
+	app.Post("/promotions", deprecated("/v1/promotions"), authorize(PermPromotionsWrite), CreatePromotionHandler)
+	app.Get("/promotions", deprecated("/v1/promotions"), authorize(PermPromotionsRead), GetPromotionsHandler)
+	app.Delete("/promotions/:code", deprecated("/v1/promotions/{code}"), authorize(PermPromotionsWrite), DeletePromotionHandler)
+
+	app.Get("/errors", deprecated("/v1/errors"), GetErrorsHandler)
+	app.Get("/errors/:code", deprecated("/v1/errors/{code}"), GetErrorHandler)
 }
 
 func main() {
 	// Initialize zerolog logger
 	log = zerolog.New(os.Stdout).With().Timestamp().Logger()
 
-	app := fiber.New()
+	// Enable carrier tracking webhooks, e.g. CARRIER_WEBHOOK_SECRETS="jne=secret1,sicepat=secret2"
+	configureCarrierWebhooks(os.Getenv("CARRIER_WEBHOOK_SECRETS"))
+
+	// Allow webhook receivers on http:// and internal hosts, for local development only
+	allowInsecureWebhooks = os.Getenv("WEBHOOK_ALLOW_INSECURE") == "true"
+
+	// Render every error as RFC 7807 problem details, not only when asked for
+	problemJSON = os.Getenv("PROBLEM_JSON") == "true"
+
+	// Require an API key or bearer token once credentials are configured
+	authConfig, err := loadAuthConfig(os.Getenv)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid authentication configuration")
+	}
+
+	// Limit requests per client, e.g. RATE_LIMIT="120/1m" and RATE_LIMIT_PAYMENTS="10/1m"
+	rateLimitConfig, err := loadRateLimitConfig(os.Getenv)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid rate limit configuration")
+	}
+
+	// Publish domain events to a message broker, e.g. BROKER_URL=nats://localhost:4222
+	brokerConfig, err := loadBrokerConfig(os.Getenv)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid broker configuration")
+	}
+
+	// Serve several storefronts, e.g. TENANTS_FILE=tenants.json
+	if path := os.Getenv("TENANTS_FILE"); path != "" {
+		if err := configureTenants(path); err != nil {
+			log.Fatal().Err(err).Msg("Invalid tenants configuration")
+		}
+	}
+
+	// Check requests against the OpenAPI spec; OPENAPI_VALIDATION=strict also checks responses
+	validationMode := os.Getenv("OPENAPI_VALIDATION")
+	if validationMode == "" {
+		validationMode = ValidateRequests
+	}
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
 
 	// Middleware to recover from panics
 	app.Use(func(c *fiber.Ctx) error {
@@ -660,7 +957,7 @@ func main() {
 					Bytes("error.stack_trace", stackBuf).
 					Msgf("Panic: %v", r)
 
-				if err := c.Status(500).SendString("Internal Server Error"); err != nil {
+				if err := ErrorHandler(c, ErrInternalError); err != nil {
 					log.Error().Err(err).Msg("Failed to send response")
 				}
 
@@ -669,8 +966,29 @@ func main() {
 		return c.Next()
 	})
 
+	app.Use(RateLimitByIP(rateLimitConfig))
+	app.Use(Authenticate(authConfig))
+	app.Use(ResolveTenant())
+	app.Use(RateLimitByClient(rateLimitConfig))
+	app.Use(OpenAPIValidator(validationMode))
+	app.Use(CommitEvents())
+
 	setupRoutes(app)
 
+	// Deliver domain events from the outbox: they are always logged, and
+	// published to the broker in order per order when one is configured
+	eventBus.Subscribe("log", LogEventHandler)
+	if brokerConfig != nil {
+		eventBus.SubscribeOrdered("broker", eventKey, PublishEventHandler(brokerConfig))
+	}
+	dispatcherCtx, stopDispatcher := context.WithCancel(context.Background())
+	var dispatcher sync.WaitGroup
+	dispatcher.Add(1)
+	go func() {
+		defer dispatcher.Done()
+		RunDispatcher(dispatcherCtx, outbox, eventBus, time.Second)
+	}()
+
 	// Graceful shutdown on SIGTERM or SIGINT
 	go func() {
 		// Listen for system signals
@@ -681,10 +999,22 @@ func main() {
 
 		log.Info().Msg("Gracefully shutting down...")
 
+		// Open event streams would keep the server from shutting down
+		closeEventStreams()
+
 		if err := app.Shutdown(); err != nil {
 			log.Error().Err(err).Msg("Error shutting down the server")
 		}
 
+		// Hand over the events of the last requests once the dispatcher has
+		// stopped, as only one may dispatch at a time
+		stopDispatcher()
+		dispatcher.Wait()
+		outbox.Dispatch(eventBus, time.Now())
+		if brokerConfig != nil {
+			brokerConfig.Publisher.Close()
+		}
+
 		log.Info().Msg("Server successfully shut down.")
 	}()
 
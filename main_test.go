@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/gofiber/fiber/v2"
@@ -13,7 +14,10 @@ import (
 
 // Helper function to set up the Fiber app for testing
 func setupApp() *fiber.App {
-	app := fiber.New()
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	app.Use(ResolveTenant())
+	app.Use(OpenAPIValidator(ValidateStrict)) // every test also checks the API contract
+	app.Use(CommitEvents())
 	setupRoutes(app) // Ensure that your routes are initialized
 	return app
 }
@@ -113,11 +117,11 @@ func TestProcessPayment(t *testing.T) {
 			"customer_id": "cust_12345",
 			"name":        "John Doe",
 			"email":       "john@example.com",
-			"phone":       "555-5555",
+			"phone":       "+15555555555",
 			"address":     "123 Main St",
 			"city":        "New York",
 			"postal_code": "10001",
-			"country":     "USA",
+			"country":     "US",
 		},
 		"amount": 1100, // Amount matches the total of the cart (Laptop + 2 Mice)
 	}
@@ -144,3 +148,32 @@ func TestProcessPayment(t *testing.T) {
 	assert.Equal(t, 1100.0, order["Amount"])
 	assert.Equal(t, "John Doe", order["Customer"].(map[string]interface{})["name"])
 }
+
+// Test that paying cannot replace an existing order, order IDs are
+// generated when none is sent, and a replayed payment creates no second order
+func TestProcessPaymentOrderIDs(t *testing.T) {
+	app := setupApp()
+	createPaidOrder(t, app, "cust_order_owner", "order_taken", nil)
+
+	status, _ := doJSON(t, app, http.MethodPost, "/v1/carts", `{"customer_id": "cust_order_other", "items": [{"item_id": "item001", "quantity": 1}, {"item_id": "item002", "quantity": 2}]}`)
+	assert.Equal(t, 200, status)
+	payment := `{"order_id": "order_taken", "amount": 1100,
+		"billing_address": {"customer_id": "cust_order_other", "name": "Eve", "email": "eve@example.com", "phone": "+15555555555", "country": "US"}}`
+	status, body := doJSON(t, app, http.MethodPost, "/v1/orders", payment)
+	assert.Equal(t, 409, status)
+	assert.Equal(t, "OrderExists", errorCode(body))
+	assert.Equal(t, "cust_order_owner", defaultTenant.Orders["order_taken"].Customer.CustomerID)
+
+	status, body = doJSON(t, app, http.MethodPost, "/v1/orders", strings.Replace(payment, `"order_id": "order_taken", `, "", 1))
+	assert.Equal(t, 200, status)
+	orderID := body["order"].(map[string]interface{})["ID"].(string)
+	assert.NotEmpty(t, orderID)
+	assert.Equal(t, "cust_order_other", defaultTenant.Orders[orderID].Customer.CustomerID)
+
+	// The cart was used up by the order
+	stock := defaultTenant.Products["item001"].Stock
+	status, body = doJSON(t, app, http.MethodPost, "/v1/orders", strings.Replace(payment, `"order_id": "order_taken", `, "", 1))
+	assert.Equal(t, 404, status)
+	assert.Equal(t, "CartNotFound", errorCode(body))
+	assert.Equal(t, stock, defaultTenant.Products["item001"].Stock)
+}
@@ -0,0 +1,512 @@
+package main
+
+import (
+	_ "embed"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Struct to describe an API operation for the OpenAPI spec. The spec is built
+// from these descriptions and the Go types of the request and response bodies.
+type Operation struct {
+	Method     string
+	Path       string // Fiber route path, e.g. /v1/orders/:id
+	Summary    string
+	Tag        string
+	Status     int         // success status, 200 when zero
+	Query      []string    // query parameters
+	Filters    []string    // optional query parameters
+	Request    interface{} // zero value of the JSON body type, nil when there is no body
+	Omit       []string    // body fields not used on this route, e.g. taken from the path
+	Optional   bool        // the body may be left out
+	Response   fiber.Map   // fields of the success response besides "message"
+	Sometimes  []string    // response fields only sent to some callers
+	Stream     bool        // answered with a Server-Sent Events stream of events
+	Deprecated bool
+}
+
+// Operations of the /v1 API, in the order they are documented
+var apiOperations = []Operation{
+	{Method: "POST", Path: "/v1/carts", Tag: "Carts", Summary: "Create or replace a customer's cart", Request: CartRequest{}, Response: fiber.Map{"cart": Cart{}}},
+	{Method: "POST", Path: "/v1/carts/:id/quote", Tag: "Carts", Summary: "Price the cart for a billing address, fulfillment type and shipping method", Request: CartQuoteRequest{}, Optional: true, Response: quoteResponse},
+	{Method: "POST", Path: "/v1/carts/:id/coupon", Tag: "Carts", Summary: "Apply a coupon code to the cart", Request: ApplyCouponRequest{}, Omit: []string{"customer_id"}, Response: fiber.Map{"cart": Cart{}}},
+	{Method: "DELETE", Path: "/v1/carts/:id/coupon", Tag: "Carts", Summary: "Remove the coupon code from the cart", Response: fiber.Map{"cart": Cart{}}},
+
+	{Method: "POST", Path: "/v1/orders", Tag: "Orders", Summary: "Pay for the cart and create the order", Request: PaymentRequest{}, Response: fiber.Map{"order": Order{}}},
+	{Method: "GET", Path: "/v1/orders", Tag: "Orders", Summary: "List orders by order ID", Response: fiber.Map{"orders": map[string]*Order{}}},
+	{Method: "GET", Path: "/v1/orders/:id", Tag: "Orders", Summary: "Get an order, with its pickup code while it waits for collection", Response: fiber.Map{"order": Order{}, "pickup_code": ""}, Sometimes: []string{"pickup_code"}},
+	{Method: "GET", Path: "/v1/orders/:id/events", Tag: "Orders", Summary: "Stream the order's status changes, from its history on", Filters: []string{"last_event_id"}, Stream: true},
+	{Method: "POST", Path: "/v1/orders/:id/grace-period", Tag: "Orders", Summary: "Wait for the grace period before routing", Response: fiber.Map{"order": Order{}}},
+	{Method: "POST", Path: "/v1/orders/:id/route", Tag: "Orders", Summary: "Route the order to a store or DC", Request: OrderActionRequest{}, Omit: []string{"order_id", "pickup_code"}, Optional: true, Response: fiber.Map{"order": Order{}}},
+	{Method: "POST", Path: "/v1/orders/:id/fulfill", Tag: "Orders", Summary: "Fulfill a delivery order in one step", Response: fiber.Map{"order": Order{}}},
+	{Method: "POST", Path: "/v1/orders/:id/capture", Tag: "Orders", Summary: "Capture the payment of a fulfilled order", Response: fiber.Map{"order": Order{}}},
+	{Method: "POST", Path: "/v1/orders/:id/refund", Tag: "Orders", Summary: "Refund what has not been refunded yet", Response: fiber.Map{"order": Order{}}},
+	{Method: "POST", Path: "/v1/orders/:id/cancel", Tag: "Orders", Summary: "Cancel an order that has not been fulfilled", Response: fiber.Map{"order": Order{}}},
+
+	{Method: "POST", Path: "/v1/orders/:id/fulfillment/pick", Tag: "Fulfillment", Summary: "Mark the order's items picked", Response: fiber.Map{"order": Order{}}},
+	{Method: "POST", Path: "/v1/orders/:id/fulfillment/pack", Tag: "Fulfillment", Summary: "Mark the order's items packed", Response: fiber.Map{"order": Order{}}},
+	{Method: "POST", Path: "/v1/orders/:id/fulfillment/ship", Tag: "Fulfillment", Summary: "Mark a delivery order shipped", Response: fiber.Map{"order": Order{}}},
+	{Method: "POST", Path: "/v1/orders/:id/fulfillment/ready-for-pickup", Tag: "Fulfillment", Summary: "Mark a pickup or locker order ready and issue the pickup code", Response: fiber.Map{"order": Order{}}},
+	{Method: "POST", Path: "/v1/orders/:id/fulfillment/collect", Tag: "Fulfillment", Summary: "Hand over a pickup or locker order against its pickup code", Request: OrderActionRequest{}, Omit: []string{"order_id", "fulfillment_location"}, Response: fiber.Map{"order": Order{}}},
+
+	{Method: "POST", Path: "/v1/shipments", Tag: "Shipments", Summary: "Ship items of an order in a package", Status: 201, Request: CreateShipmentRequest{}, Response: fiber.Map{"shipment": Shipment{}, "order": Order{}}},
+	{Method: "GET", Path: "/v1/shipments/:id", Tag: "Shipments", Summary: "Get a shipment", Response: fiber.Map{"shipment": Shipment{}}},
+	{Method: "POST", Path: "/v1/shipments/:id/status", Tag: "Shipments", Summary: "Record a carrier status update", Request: ShipmentStatusRequest{}, Response: fiber.Map{"shipment": Shipment{}, "order": Order{}}},
+	{Method: "POST", Path: "/v1/webhooks/carriers/:carrier", Tag: "Shipments", Summary: "Receive signed tracking events from a carrier", Request: map[string]interface{}{}, Response: fiber.Map{"applied": 0, "duplicates": 0, "ignored": 0}},
+
+	{Method: "POST", Path: "/v1/webhooks/subscriptions", Tag: "Webhooks", Summary: "Subscribe a URL to order events; the signing secret is only returned here", Status: 201, Request: WebhookSubscriptionRequest{}, Response: fiber.Map{"subscription": WebhookSubscription{}, "secret": ""}},
+	{Method: "GET", Path: "/v1/webhooks/subscriptions", Tag: "Webhooks", Summary: "List webhook subscriptions", Response: fiber.Map{"subscriptions": []WebhookSubscription{}}},
+	{Method: "GET", Path: "/v1/webhooks/subscriptions/:id", Tag: "Webhooks", Summary: "Get a webhook subscription", Response: fiber.Map{"subscription": WebhookSubscription{}}},
+	{Method: "DELETE", Path: "/v1/webhooks/subscriptions/:id", Tag: "Webhooks", Summary: "Delete a webhook subscription and its pending retries", Response: fiber.Map{}},
+	{Method: "GET", Path: "/v1/webhooks/subscriptions/:id/deliveries", Tag: "Webhooks", Summary: "The latest delivery attempts, oldest first", Response: fiber.Map{"deliveries": []WebhookDelivery{}}},
+	{Method: "POST", Path: "/v1/webhooks/subscriptions/:id/deliveries/:delivery_id/redeliver", Tag: "Webhooks", Summary: "Send the event of a delivery again", Response: fiber.Map{"delivery": WebhookDelivery{}}},
+
+	{Method: "GET", Path: "/v1/events", Tag: "Events", Summary: "Stream the events of every order of the tenant", Filters: []string{"type", "customer_id", "order_id", "last_event_id"}, Stream: true},
+
+	{Method: "POST", Path: "/v1/returns", Tag: "Returns", Summary: "Request a return", Status: 201, Request: ReturnRequest{}, Response: fiber.Map{"return": Return{}}},
+	{Method: "GET", Path: "/v1/returns/:id", Tag: "Returns", Summary: "Get a return", Response: fiber.Map{"return": Return{}}},
+	{Method: "POST", Path: "/v1/returns/:id/approve", Tag: "Returns", Summary: "Approve a requested return", Response: fiber.Map{"return": Return{}}},
+	{Method: "POST", Path: "/v1/returns/:id/reject", Tag: "Returns", Summary: "Reject a requested return", Request: RejectReturnRequest{}, Optional: true, Response: fiber.Map{"return": Return{}}},
+	{Method: "POST", Path: "/v1/returns/:id/receive", Tag: "Returns", Summary: "Receive the returned items", Response: fiber.Map{"return": Return{}}},
+	{Method: "POST", Path: "/v1/returns/:id/inspect", Tag: "Returns", Summary: "Record which returned items are restocked", Request: InspectReturnRequest{}, Optional: true, Response: fiber.Map{"return": Return{}}},
+	{Method: "POST", Path: "/v1/returns/:id/refund", Tag: "Returns", Summary: "Refund an inspected return", Response: fiber.Map{"return": Return{}, "order": Order{}}},
+	{Method: "POST", Path: "/v1/exchanges", Tag: "Returns", Summary: "Exchange items of a fulfilled order for new items", Status: 201, Request: ExchangeRequest{}, Response: fiber.Map{"price_difference": 0.0, "return": Return{}, "replacement_order": Order{}}},
+
+	{Method: "GET", Path: "/v1/reports/revenue", Tag: "Reports", Summary: "Revenue across all paid orders", Response: fiber.Map{"report": RevenueReport{}}},
+
+	{Method: "POST", Path: "/v1/products", Tag: "Products", Summary: "Add a product to the catalog", Status: 201, Request: ProductRequest{}, Response: fiber.Map{"product": Product{}}},
+	{Method: "GET", Path: "/v1/products", Tag: "Products", Summary: "List products", Response: fiber.Map{"products": []Product{}}},
+	{Method: "GET", Path: "/v1/products/:sku", Tag: "Products", Summary: "Get a product", Response: fiber.Map{"product": Product{}}},
+	{Method: "PUT", Path: "/v1/products/:sku", Tag: "Products", Summary: "Update a product", Request: ProductRequest{}, Omit: []string{"sku"}, Response: fiber.Map{"product": Product{}}},
+	{Method: "DELETE", Path: "/v1/products/:sku", Tag: "Products", Summary: "Delete a product", Response: fiber.Map{}},
+
+	{Method: "POST", Path: "/v1/customers", Tag: "Customers", Summary: "Create a customer", Status: 201, Request: CustomerRequest{}, Response: fiber.Map{"customer": CustomerInfo{}}},
+	{Method: "GET", Path: "/v1/customers", Tag: "Customers", Summary: "List customers", Response: fiber.Map{"customers": []CustomerInfo{}}},
+	{Method: "GET", Path: "/v1/customers/:id", Tag: "Customers", Summary: "Get a customer", Response: fiber.Map{"customer": CustomerInfo{}}},
+	{Method: "PATCH", Path: "/v1/customers/:id", Tag: "Customers", Summary: "Update the fields sent", Request: CustomerRequest{}, Omit: []string{"customer_id"}, Response: fiber.Map{"customer": CustomerInfo{}}},
+	{Method: "GET", Path: "/v1/customers/:id/orders", Tag: "Customers", Summary: "The customer's orders, oldest first", Response: fiber.Map{"orders": []Order{}}},
+
+	{Method: "POST", Path: "/v1/promotions", Tag: "Promotions", Summary: "Create a promotion", Status: 201, Request: Promotion{}, Response: fiber.Map{"promotion": Promotion{}}},
+	{Method: "GET", Path: "/v1/promotions", Tag: "Promotions", Summary: "List promotions", Response: fiber.Map{"promotions": []Promotion{}}},
+	{Method: "DELETE", Path: "/v1/promotions/:code", Tag: "Promotions", Summary: "Delete a promotion", Response: fiber.Map{}},
+
+	{Method: "GET", Path: "/v1/errors", Tag: "Errors", Summary: "List the error catalog", Response: fiber.Map{"errors": []ErrorCatalogEntry{}}},
+	{Method: "GET", Path: "/v1/errors/:code", Tag: "Errors", Summary: "Describe an error code", Response: fiber.Map{"code": "", "status": 0}},
+}
+
+// Response of the cart quote
+var quoteResponse = fiber.Map{
+	"items":            []OrderItem{},
+	"coupon_code":      "",
+	"shipping_method":  "",
+	"shipping_options": []ShippingQuote{},
+	"totals":           Totals{},
+}
+
+// Legacy routes and the /v1 operation replacing them. Their summary and
+// response come from the /v1 operation; the order or customer ID is sent in
+// the body, or the query for the grace period.
+var legacyOperations = []Operation{
+	legacyOperation("POST", "/create-cart", "POST /v1/carts", CartRequest{}),
+	legacyOperation("POST", "/quote-cart", "POST /v1/carts/:id/quote", CartQuoteRequest{}),
+	legacyOperation("POST", "/apply-coupon", "POST /v1/carts/:id/coupon", ApplyCouponRequest{}),
+	legacyOperation("POST", "/remove-coupon", "DELETE /v1/carts/:id/coupon", RemoveCouponRequest{}),
+	legacyOperation("POST", "/process-payment", "POST /v1/orders", PaymentRequest{}),
+	legacyOperation("GET", "/wait-grace-period", "POST /v1/orders/:id/grace-period", nil, "order_id"),
+	legacyOperation("POST", "/route-order", "POST /v1/orders/:id/route", OrderActionRequest{}),
+	legacyOperation("POST", "/fulfill-order", "POST /v1/orders/:id/fulfill", OrderActionRequest{}),
+	legacyOperation("POST", "/capture-payment", "POST /v1/orders/:id/capture", OrderActionRequest{}),
+	legacyOperation("POST", "/refund-payment", "POST /v1/orders/:id/refund", OrderActionRequest{}),
+	legacyOperation("POST", "/cancel-order", "POST /v1/orders/:id/cancel", OrderActionRequest{}),
+	legacyOperation("GET", "/orders", "GET /v1/orders", nil),
+	legacyOperation("POST", "/fulfillment/pick", "POST /v1/orders/:id/fulfillment/pick", OrderActionRequest{}),
+	legacyOperation("POST", "/fulfillment/pack", "POST /v1/orders/:id/fulfillment/pack", OrderActionRequest{}),
+	legacyOperation("POST", "/fulfillment/ship", "POST /v1/orders/:id/fulfillment/ship", OrderActionRequest{}),
+	legacyOperation("POST", "/fulfillment/ready-for-pickup", "POST /v1/orders/:id/fulfillment/ready-for-pickup", OrderActionRequest{}),
+	legacyOperation("POST", "/fulfillment/collect", "POST /v1/orders/:id/fulfillment/collect", OrderActionRequest{}),
+}
+
+// Resources without a legacy route at the same path: orders and carts have
+// the RPC routes of legacyOperations, and resources added since /v1 none
+var noLegacyResourcePaths = []string{"/v1/orders", "/v1/carts", "/v1/webhooks/subscriptions", "/v1/events"}
+
+// legacyResourceOperations describes the resource routes that are served at
+// the same path without the /v1 prefix
+func legacyResourceOperations() []Operation {
+	var ops []Operation
+	for _, op := range apiOperations {
+		if hasAnyPrefix(op.Path, noLegacyResourcePaths) {
+			continue
+		}
+		op.Path = strings.TrimPrefix(op.Path, "/v1")
+		op.Deprecated = true
+		ops = append(ops, op)
+	}
+	return ops
+}
+
+func hasAnyPrefix(path string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// legacyOperation describes a legacy route from the /v1 operation replacing it
+func legacyOperation(method, path, successor string, request interface{}, query ...string) Operation {
+	for _, op := range apiOperations {
+		if op.Method+" "+op.Path == successor {
+			op.Method = method
+			op.Path = path
+			op.Request = request
+			op.Omit = nil
+			op.Optional = false
+			op.Query = query
+			op.Deprecated = true
+			return op
+		}
+	}
+	panic("unknown successor operation " + successor)
+}
+
+// Routes serving the spec itself
+var specOperations = []Operation{
+	{Method: "GET", Path: "/openapi.json", Tag: "Docs", Summary: "This OpenAPI document"},
+	{Method: "GET", Path: "/docs", Tag: "Docs", Summary: "API documentation page"},
+}
+
+var fiberParamPattern = regexp.MustCompile(`:(\w+)`)
+
+// openAPIPath converts a Fiber route path to an OpenAPI path
+func openAPIPath(path string) string {
+	return fiberParamPattern.ReplaceAllString(path, "{$1}")
+}
+
+// openAPISpec builds the OpenAPI document of every route
+func openAPISpec() fiber.Map {
+	b := &specBuilder{schemas: fiber.Map{}}
+
+	paths := fiber.Map{}
+	for _, group := range [][]Operation{apiOperations, legacyOperations, legacyResourceOperations(), specOperations} {
+		for _, op := range group {
+			path := openAPIPath(op.Path)
+			item, exists := paths[path].(fiber.Map)
+			if !exists {
+				item = fiber.Map{}
+				paths[path] = item
+			}
+			item[strings.ToLower(op.Method)] = b.operation(op)
+		}
+	}
+
+	b.schemas["APIError"] = b.structSchema(reflect.TypeOf(APIError{}))
+	b.schemas["Problem"] = fiber.Map{
+		"type": "object",
+		"properties": fiber.Map{
+			"type":     fiber.Map{"type": "string"},
+			"title":    fiber.Map{"type": "string"},
+			"status":   fiber.Map{"type": "integer"},
+			"detail":   fiber.Map{"type": "string"},
+			"instance": fiber.Map{"type": "string"},
+			"code":     fiber.Map{"type": "string"},
+			"target":   fiber.Map{"type": "string"},
+			"details":  fiber.Map{},
+		},
+		"required": []string{"type", "title", "status", "code"},
+	}
+
+	return fiber.Map{
+		"openapi": "3.1.0",
+		"info": fiber.Map{
+			"title":       "Fiber Order Workflow API",
+			"description": "Order workflow with carts, payment, routing, fulfillment, shipments, returns and refunds. Routes outside /v1 are deprecated.",
+			"version":     "1.0.0",
+		},
+		"servers": []fiber.Map{{"url": "http://localhost:3000", "description": "Local development server"}},
+		"paths":   paths,
+		// Either credential is accepted once authentication is configured
+		"security": []fiber.Map{{"ApiKeyAuth": []string{}}, {"BearerAuth": []string{}}},
+		"components": fiber.Map{
+			"schemas": b.schemas,
+			"securitySchemes": fiber.Map{
+				"ApiKeyAuth": fiber.Map{"type": "apiKey", "in": "header", "name": apiKeyHeader},
+				"BearerAuth": fiber.Map{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			},
+			"responses": fiber.Map{
+				"Error": fiber.Map{
+					"description": "Error listed in the error catalog",
+					"content": fiber.Map{
+						"application/json": fiber.Map{"schema": fiber.Map{
+							"type":       "object",
+							"properties": fiber.Map{"error": fiber.Map{"$ref": "#/components/schemas/APIError"}},
+							"required":   []string{"error"},
+						}},
+						"application/problem+json": fiber.Map{"schema": fiber.Map{"$ref": "#/components/schemas/Problem"}},
+					},
+				},
+			},
+		},
+	}
+}
+
+// Builds the schemas of an OpenAPI document, collecting named structs as components
+type specBuilder struct {
+	schemas fiber.Map
+}
+
+// operation builds the OpenAPI operation object
+func (b *specBuilder) operation(op Operation) fiber.Map {
+	operation := fiber.Map{
+		"summary":     op.Summary,
+		"operationId": operationID(op),
+		"tags":        []string{op.Tag},
+	}
+	if op.Deprecated {
+		operation["deprecated"] = true
+	}
+	if isPublicRoute(op.Path) {
+		operation["security"] = []fiber.Map{} // served without credentials
+	}
+
+	parameters := []fiber.Map{}
+	for _, match := range fiberParamPattern.FindAllStringSubmatch(op.Path, -1) {
+		parameters = append(parameters, fiber.Map{"name": match[1], "in": "path", "required": true, "schema": fiber.Map{"type": "string"}})
+	}
+	for _, name := range op.Query {
+		parameters = append(parameters, fiber.Map{"name": name, "in": "query", "required": true, "schema": fiber.Map{"type": "string"}})
+	}
+	for _, name := range op.Filters {
+		parameters = append(parameters, fiber.Map{"name": name, "in": "query", "required": false, "schema": fiber.Map{"type": "string"}})
+	}
+	if op.Stream {
+		parameters = append(parameters, fiber.Map{
+			"name": lastEventIDHeader, "in": "header", "required": false, "schema": fiber.Map{"type": "string"},
+			"description": "Resume after this event, sent by EventSource when it reconnects",
+		})
+	}
+	if !isPublicRoute(op.Path) {
+		parameters = append(parameters, fiber.Map{
+			"name": tenantHeader, "in": "header", "required": false, "schema": fiber.Map{"type": "string"},
+			"description": "Storefront of the request when the credentials are not bound to one",
+		})
+	}
+	if len(parameters) > 0 {
+		operation["parameters"] = parameters
+	}
+
+	if op.Request != nil {
+		schema := b.schemaFor(reflect.TypeOf(op.Request))
+		if len(op.Omit) > 0 {
+			schema = b.withoutFields(reflect.TypeOf(op.Request), op.Omit)
+		}
+		operation["requestBody"] = fiber.Map{
+			"required": !op.Optional,
+			"content":  fiber.Map{"application/json": fiber.Map{"schema": schema}},
+		}
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = 200
+	}
+	operation["responses"] = fiber.Map{
+		strconv.Itoa(status): b.response(op),
+		"default":            fiber.Map{"$ref": "#/components/responses/Error"},
+	}
+	return operation
+}
+
+// response builds the success response of an operation
+func (b *specBuilder) response(op Operation) fiber.Map {
+	switch op.Path {
+	case "/openapi.json":
+		return fiber.Map{"description": op.Summary, "content": fiber.Map{"application/json": fiber.Map{"schema": fiber.Map{"type": "object"}}}}
+	case "/docs":
+		return fiber.Map{"description": op.Summary, "content": fiber.Map{"text/html": fiber.Map{"schema": fiber.Map{"type": "string"}}}}
+	}
+	if op.Stream {
+		return fiber.Map{"description": op.Summary, "content": fiber.Map{"text/event-stream": fiber.Map{"schema": fiber.Map{
+			"type":        "string",
+			"description": "Events as `id: <sequence>`, `event: <type>` and `data: <event JSON>` messages",
+		}}}}
+	}
+
+	properties := fiber.Map{"message": fiber.Map{"type": "string"}}
+	required := []string{"message"}
+	for name, value := range op.Response {
+		properties[name] = b.schemaFor(reflect.TypeOf(value))
+		if !containsString(op.Sometimes, name) {
+			required = append(required, name)
+		}
+	}
+	sort.Strings(required[1:])
+
+	return fiber.Map{
+		"description": op.Summary,
+		"content": fiber.Map{"application/json": fiber.Map{"schema": fiber.Map{
+			"type":       "object",
+			"properties": properties,
+			"required":   required,
+		}}},
+	}
+}
+
+// operationID names an operation after its method and path, e.g. postV1OrdersIdRoute
+func operationID(op Operation) string {
+	id := strings.ToLower(op.Method)
+	for _, part := range strings.FieldsFunc(op.Path, func(r rune) bool { return r == '/' || r == ':' || r == '-' || r == '.' }) {
+		id += strings.ToUpper(part[:1]) + part[1:]
+	}
+	return id
+}
+
+// schemaFor returns the schema of a Go type; named structs are referenced from components
+func (b *specBuilder) schemaFor(t reflect.Type) fiber.Map {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return nullable(b.schemaFor(t.Elem()))
+	case reflect.Slice:
+		return fiber.Map{"type": []string{"array", "null"}, "items": b.schemaFor(t.Elem())}
+	case reflect.Map:
+		return fiber.Map{"type": []string{"object", "null"}, "additionalProperties": b.schemaFor(t.Elem())}
+	case reflect.Interface:
+		return fiber.Map{}
+	case reflect.String:
+		return fiber.Map{"type": "string"}
+	case reflect.Bool:
+		return fiber.Map{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return fiber.Map{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return fiber.Map{"type": "number"}
+	case reflect.Struct:
+		if t == reflect.TypeOf(time.Time{}) {
+			return fiber.Map{"type": "string", "format": "date-time"}
+		}
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		if _, exists := b.schemas[t.Name()]; !exists {
+			b.schemas[t.Name()] = fiber.Map{} // placeholder for recursive types
+			b.schemas[t.Name()] = b.structSchema(t)
+		}
+		return fiber.Map{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return fiber.Map{}
+}
+
+// nullable allows null besides the schema
+func nullable(schema fiber.Map) fiber.Map {
+	switch schemaType := schema["type"].(type) {
+	case string:
+		copied := fiber.Map{}
+		for key, value := range schema {
+			copied[key] = value
+		}
+		copied["type"] = []string{schemaType, "null"}
+		return copied
+	case []string:
+		return schema // already nullable
+	}
+	return fiber.Map{"anyOf": []fiber.Map{schema, {"type": "null"}}}
+}
+
+// structSchema describes the JSON fields of a struct, with the rules of their
+// `validate` tags
+func (b *specBuilder) structSchema(t reflect.Type) fiber.Map {
+	properties := fiber.Map{}
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() || field.Tag.Get("json") == "-" {
+			continue
+		}
+		name := jsonFieldName(field)
+		schema := b.schemaFor(field.Type)
+
+		for _, rule := range strings.Split(field.Tag.Get("validate"), ",") {
+			ruleName, arg, _ := strings.Cut(rule, "=")
+			switch ruleName {
+			case "required":
+				required = append(required, name)
+			case "email":
+				schema["format"] = "email"
+			case "e164":
+				schema["format"] = "e164"
+				schema["pattern"] = e164Pattern.String()
+			case "country":
+				schema["format"] = "country-code"
+				schema["pattern"] = "^[A-Za-z]{2}$"
+			case "url":
+				schema["format"] = "uri"
+			case "oneof":
+				schema["enum"] = strings.Fields(arg)
+			case "gt":
+				schema["exclusiveMinimum"], _ = strconv.ParseFloat(arg, 64)
+			case "gte":
+				schema["minimum"], _ = strconv.ParseFloat(arg, 64)
+			case "max":
+				if field.Type.Kind() == reflect.Slice {
+					schema["maxItems"], _ = strconv.Atoi(arg)
+				}
+			case "required_fields":
+				// Checked by the handler after the saved account details are
+				// filled in, so clients may leave these out
+				schema = fiber.Map{"allOf": []fiber.Map{schema}, "x-required-fields": strings.Fields(arg)}
+			}
+		}
+		properties[name] = schema
+	}
+
+	schema := fiber.Map{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// withoutFields returns an inline schema of the struct without the fields that
+// come from the path
+func (b *specBuilder) withoutFields(t reflect.Type, fields []string) fiber.Map {
+	b.schemaFor(t)
+	schema := b.structSchema(t)
+	properties := schema["properties"].(fiber.Map)
+	for _, name := range fields {
+		delete(properties, name)
+	}
+	if required, exists := schema["required"].([]string); exists {
+		kept := []string{}
+		for _, name := range required {
+			if _, exists := properties[name]; exists {
+				kept = append(kept, name)
+			}
+		}
+		schema["required"] = kept
+		if len(kept) == 0 {
+			delete(schema, "required")
+		}
+	}
+	return schema
+}
+
+// OpenAPIHandler serves the OpenAPI document
+func OpenAPIHandler(c *fiber.Ctx) error {
+	return c.JSON(openAPISpec())
+}
+
+//go:embed docs.html
+var docsPage string
+
+// DocsHandler serves the documentation page, which renders /openapi.json
+func DocsHandler(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+	return c.SendString(docsPage)
+}
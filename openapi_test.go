@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+// Test that every route in setupRoutes is in the spec and every documented
+// operation has a route
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	app := setupApp()
+
+	routes := map[string]bool{}
+	for _, route := range app.GetRoutes(true) {
+		if route.Method == http.MethodHead {
+			continue // added by Fiber for every GET route
+		}
+		routes[route.Method+" "+openAPIPath(route.Path)] = true
+	}
+
+	documented := map[string]bool{}
+	for path, item := range openAPISpec()["paths"].(fiber.Map) {
+		for method := range item.(fiber.Map) {
+			documented[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+
+	assert.Equal(t, sortedKeys(routes), sortedKeys(documented))
+}
+
+// Test that openapi.yaml is the generated spec. Regenerate it with
+// UPDATE_OPENAPI=1 go test -run TestOpenAPIYAMLUpToDate
+func TestOpenAPIYAMLUpToDate(t *testing.T) {
+	generated, err := openAPIYAML()
+	assert.NoError(t, err)
+
+	if os.Getenv("UPDATE_OPENAPI") == "1" {
+		assert.NoError(t, os.WriteFile("openapi.yaml", generated, 0o644))
+	}
+
+	committed, err := os.ReadFile("openapi.yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, string(generated), string(committed), "openapi.yaml is out of date, run UPDATE_OPENAPI=1 go test -run TestOpenAPIYAMLUpToDate")
+}
+
+// Test that the spec and the docs page are served
+func TestOpenAPIServed(t *testing.T) {
+	app := setupApp()
+
+	status, body := doJSON(t, app, http.MethodGet, "/openapi.json", "")
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "3.1.0", body["openapi"])
+	route := body["paths"].(map[string]interface{})["/v1/orders/{id}/route"].(map[string]interface{})["post"].(map[string]interface{})
+	assert.Equal(t, "id", route["parameters"].([]interface{})[0].(map[string]interface{})["name"])
+
+	// Legacy routes are documented as deprecated with their body parameters
+	legacy := body["paths"].(map[string]interface{})["/route-order"].(map[string]interface{})["post"].(map[string]interface{})
+	assert.Equal(t, true, legacy["deprecated"])
+	assert.Equal(t, "#/components/schemas/OrderActionRequest", legacy["requestBody"].(map[string]interface{})["content"].(map[string]interface{})["application/json"].(map[string]interface{})["schema"].(map[string]interface{})["$ref"])
+
+	// Request rules come from the validate tags
+	cartRequest := body["components"].(map[string]interface{})["schemas"].(map[string]interface{})["CartRequest"].(map[string]interface{})
+	assert.Equal(t, []interface{}{"customer_id", "items"}, cartRequest["required"])
+	assert.Equal(t, float64(50), cartRequest["properties"].(map[string]interface{})["items"].(map[string]interface{})["maxItems"])
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/docs", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Type"), "text/html")
+}
+
+// openAPIYAML renders the spec as YAML with the top-level keys in their usual order
+func openAPIYAML() ([]byte, error) {
+	spec := openAPISpec()
+	doc := &yaml.Node{Kind: yaml.MappingNode}
+	for _, key := range []string{"openapi", "info", "servers", "paths", "security", "components"} {
+		data, err := json.Marshal(spec[key])
+		if err != nil {
+			return nil, err
+		}
+		var value yaml.Node
+		if err := yaml.Unmarshal(data, &value); err != nil {
+			return nil, err
+		}
+		// JSON is parsed in flow style; print it as block YAML
+		setBlockStyle(value.Content[0])
+		doc.Content = append(doc.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value.Content[0])
+	}
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), encoder.Close()
+}
+
+// setBlockStyle clears the flow style of a node and its children
+func setBlockStyle(node *yaml.Node) {
+	node.Style &^= yaml.FlowStyle | yaml.DoubleQuotedStyle
+	for _, child := range node.Content {
+		setBlockStyle(child)
+	}
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// How much of the API contract is checked against the OpenAPI spec
+const (
+	ValidateOff      = "off"      // no checks
+	ValidateRequests = "requests" // path, query and body of incoming requests (default)
+	ValidateStrict   = "strict"   // requests and outgoing responses, for tests and staging
+)
+
+// Schema formats backed by the rules of the `validate` tags, so both report the same reasons
+var formatRules = map[string]string{
+	"email":        "email",
+	"e164":         "e164",
+	"country-code": "country",
+}
+
+// Struct to represent a documented operation the validator matches requests to
+type specOperation struct {
+	segments  []string // path split on "/", "{name}" for parameters
+	params    int
+	operation fiber.Map
+}
+
+// Struct to represent an OpenAPI spec prepared for validation
+type specValidator struct {
+	components    fiber.Map
+	errorResponse fiber.Map                  // response every operation returns on errors
+	operations    map[string][]specOperation // by HTTP method
+}
+
+// newSpecValidator indexes the operations of a spec by method and path
+func newSpecValidator(spec fiber.Map) *specValidator {
+	components := spec["components"].(fiber.Map)
+	v := &specValidator{
+		components:    components["schemas"].(fiber.Map),
+		errorResponse: components["responses"].(fiber.Map)["Error"].(fiber.Map),
+		operations:    make(map[string][]specOperation),
+	}
+	for path, item := range spec["paths"].(fiber.Map) {
+		for method, operation := range item.(fiber.Map) {
+			op := specOperation{segments: strings.Split(path, "/"), operation: operation.(fiber.Map)}
+			for _, segment := range op.segments {
+				if strings.HasPrefix(segment, "{") {
+					op.params++
+				}
+			}
+			v.operations[strings.ToUpper(method)] = append(v.operations[strings.ToUpper(method)], op)
+		}
+	}
+	// Literal paths win over paths with parameters, e.g. /v1/errors before /v1/{id}
+	for _, ops := range v.operations {
+		sort.Slice(ops, func(i, j int) bool { return ops[i].params < ops[j].params })
+	}
+	return v
+}
+
+// match finds the operation for a request path
+func (v *specValidator) match(method, path string) fiber.Map {
+	segments := strings.Split(strings.TrimSuffix(path, "/"), "/")
+	for _, op := range v.operations[method] {
+		if len(op.segments) != len(segments) {
+			continue
+		}
+		matched := true
+		for i, segment := range op.segments {
+			if !strings.HasPrefix(segment, "{") && segment != segments[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return op.operation
+		}
+	}
+	return nil
+}
+
+// OpenAPIValidator checks requests, and in strict mode responses, against the
+// OpenAPI spec of the API. Requests for undocumented routes are passed on.
+func OpenAPIValidator(mode string) fiber.Handler {
+	v := newSpecValidator(openAPISpec())
+
+	return func(c *fiber.Ctx) error {
+		if mode == ValidateOff {
+			return c.Next()
+		}
+		operation := v.match(c.Method(), c.Path())
+		if operation == nil {
+			return c.Next()
+		}
+
+		if err := v.checkRequest(c, operation); err != nil {
+			return err
+		}
+
+		if err := c.Next(); err != nil || mode != ValidateStrict {
+			return err
+		}
+		return v.checkResponse(c, operation)
+	}
+}
+
+// checkRequest validates the query parameters and JSON body of a request
+func (v *specValidator) checkRequest(c *fiber.Ctx, operation fiber.Map) error {
+	var errs []FieldError
+
+	parameters, _ := operation["parameters"].([]fiber.Map)
+	for _, parameter := range parameters {
+		name := parameter["name"].(string)
+		if parameter["in"] == "query" && parameter["required"] == true && c.Query(name) == "" {
+			errs = append(errs, FieldError{Field: name, Reason: "is required"})
+		}
+	}
+
+	if requestBody, exists := operation["requestBody"].(fiber.Map); exists {
+		schema := requestBody["content"].(fiber.Map)["application/json"].(fiber.Map)["schema"].(fiber.Map)
+		switch {
+		case len(c.Body()) > 0:
+			var body interface{}
+			if err := json.Unmarshal(c.Body(), &body); err != nil {
+				log.Warn().Str("url.path", c.Path()).Msg("Request body is not valid JSON")
+				return ErrInvalidJSON
+			}
+			v.validate(body, schema, "", true, &errs)
+		case requestBody["required"] == true:
+			errs = append(errs, FieldError{Field: "body", Reason: "is required"})
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	log.Warn().Int("error.count", len(errs)).Msgf("Request does not match the OpenAPI spec on %s", errs[0].Field)
+	return ErrInvalidRequest.WithMessage("One or more fields are invalid").WithTarget(errs[0].Field).WithDetails(errs)
+}
+
+// checkResponse validates a JSON response against the documented response of its status
+func (v *specValidator) checkResponse(c *fiber.Ctx, operation fiber.Map) error {
+	contentType := strings.Split(string(c.Response().Header.ContentType()), ";")[0]
+	status := c.Response().StatusCode()
+
+	responses := operation["responses"].(fiber.Map)
+	response, documented := responses[strconv.Itoa(status)].(fiber.Map)
+	if !documented {
+		response = responses["default"].(fiber.Map)
+	}
+	if ref, isRef := response["$ref"].(string); isRef && strings.HasSuffix(ref, "/responses/Error") {
+		response = v.errorResponse
+	}
+	if !documented && status < 400 {
+		return v.responseViolation(c, status, []FieldError{{Field: "status", Reason: "is not documented"}})
+	}
+
+	content, described := response["content"].(fiber.Map)[contentType].(fiber.Map)
+	if !described {
+		return v.responseViolation(c, status, []FieldError{{Field: "content_type", Reason: "is not documented: " + contentType}})
+	}
+	if contentType != fiber.MIMEApplicationJSON && contentType != "application/problem+json" {
+		return nil
+	}
+
+	var body interface{}
+	if err := json.Unmarshal(c.Response().Body(), &body); err != nil {
+		return v.responseViolation(c, status, []FieldError{{Field: "body", Reason: "is not valid JSON"}})
+	}
+	var errs []FieldError
+	v.validate(body, content["schema"].(fiber.Map), "", false, &errs)
+	if len(errs) > 0 {
+		return v.responseViolation(c, status, errs)
+	}
+	return nil
+}
+
+// responseViolation replaces a response that breaks the contract with an internal error
+func (v *specValidator) responseViolation(c *fiber.Ctx, status int, errs []FieldError) error {
+	log.Error().
+		Str("url.path", c.Path()).
+		Int("http.response.status_code", status).
+		Interface("error.details", errs).
+		Msg("Response does not match the OpenAPI spec")
+
+	c.Response().ResetBody()
+	return ErrInternalError.WithMessage("The response does not match the OpenAPI spec.").WithDetails(fiber.Map{
+		"status": status,
+		"fields": errs,
+	})
+}
+
+// validate checks a decoded JSON value against a schema and collects every
+// invalid field. In requests, required properties must be present and not
+// empty, like the required rule of the `validate` tags; responses only need
+// them present.
+func (v *specValidator) validate(value interface{}, schema fiber.Map, path string, request bool, errs *[]FieldError) {
+	schema = v.resolve(schema)
+	if allOf, exists := schema["allOf"].([]fiber.Map); exists {
+		for _, part := range allOf {
+			v.validate(value, part, path, request, errs)
+		}
+	}
+	if anyOf, exists := schema["anyOf"].([]fiber.Map); exists {
+		var closest []FieldError
+		for _, option := range anyOf {
+			var optionErrs []FieldError
+			v.validate(value, option, path, request, &optionErrs)
+			if len(optionErrs) == 0 {
+				return
+			}
+			// Report the fields of the option with the value's type, such as
+			// the object of a nullable nested struct
+			if closest == nil && checkType(value, v.resolve(option)["type"]) == "" {
+				closest = optionErrs
+			}
+		}
+		if closest == nil {
+			closest = []FieldError{{Field: fieldOrBody(path), Reason: "does not match any allowed schema"}}
+		}
+		*errs = append(*errs, closest...)
+		return
+	}
+
+	if reason := checkType(value, schema["type"]); reason != "" {
+		*errs = append(*errs, FieldError{Field: fieldOrBody(path), Reason: reason})
+		return
+	}
+
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		if required, exists := schema["required"].([]string); exists {
+			for _, name := range required {
+				if value, present := typed[name]; !present || request && isEmptyJSON(value) {
+					*errs = append(*errs, FieldError{Field: joinPath(path, name), Reason: "is required"})
+				}
+			}
+		}
+		properties, _ := schema["properties"].(fiber.Map)
+		names := make([]string, 0, len(typed))
+		for name := range typed {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if property, exists := properties[name].(fiber.Map); exists {
+				// Empty required fields are already reported as required
+				if !request || !isEmptyJSON(typed[name]) || !isRequired(schema, name) {
+					v.validate(typed[name], property, joinPath(path, name), request, errs)
+				}
+			} else if additional, exists := schema["additionalProperties"].(fiber.Map); exists {
+				v.validate(typed[name], additional, joinPath(path, name), request, errs)
+			}
+		}
+
+	case []interface{}:
+		if reason := checkKeywordRules(value, schema); reason != "" {
+			*errs = append(*errs, FieldError{Field: fieldOrBody(path), Reason: reason})
+			return
+		}
+		if items, exists := schema["items"].(fiber.Map); exists {
+			for i, item := range typed {
+				v.validate(item, items, path+"["+strconv.Itoa(i)+"]", request, errs)
+			}
+		}
+
+	case string, float64:
+		if reason := checkKeywordRules(value, schema); reason != "" {
+			*errs = append(*errs, FieldError{Field: fieldOrBody(path), Reason: reason})
+		}
+	}
+}
+
+// resolve returns the component schema a $ref points to
+func (v *specValidator) resolve(schema fiber.Map) fiber.Map {
+	if ref, exists := schema["$ref"].(string); exists {
+		return v.components[strings.TrimPrefix(ref, "#/components/schemas/")].(fiber.Map)
+	}
+	return schema
+}
+
+// checkType checks the JSON type of a value against a schema type, which may
+// be a list such as ["array", "null"]
+func checkType(value interface{}, schemaType interface{}) string {
+	var allowed []string
+	switch typed := schemaType.(type) {
+	case string:
+		allowed = []string{typed}
+	case []string:
+		allowed = typed
+	default:
+		return ""
+	}
+
+	for _, name := range allowed {
+		switch name {
+		case "null":
+			if value == nil {
+				return ""
+			}
+		case "string":
+			if _, ok := value.(string); ok {
+				return ""
+			}
+		case "number":
+			if _, ok := value.(float64); ok {
+				return ""
+			}
+		case "integer":
+			if number, ok := value.(float64); ok && number == float64(int64(number)) {
+				return ""
+			}
+		case "boolean":
+			if _, ok := value.(bool); ok {
+				return ""
+			}
+		case "object":
+			if _, ok := value.(map[string]interface{}); ok {
+				return ""
+			}
+		case "array":
+			if _, ok := value.([]interface{}); ok {
+				return ""
+			}
+		}
+	}
+
+	reasons := map[string]string{
+		"string": "a string", "number": "a number", "integer": "an integer",
+		"boolean": "a boolean", "object": "an object", "array": "a list",
+	}
+	if allowed[0] == "null" {
+		return "must be null"
+	}
+	return "must be " + reasons[allowed[0]]
+}
+
+// checkKeywordRules applies enum, bounds and formats by translating them back to
+// `validate` tag rules, so reasons match those of checkRequest. Empty strings
+// are only checked by required.
+func checkKeywordRules(value interface{}, schema fiber.Map) string {
+	var rules []string
+	if format, exists := schema["format"].(string); exists && formatRules[format] != "" {
+		rules = append(rules, formatRules[format])
+	}
+	if enum, exists := schema["enum"].([]string); exists {
+		rules = append(rules, "oneof="+strings.Join(enum, " "))
+	}
+	if limit, exists := schema["exclusiveMinimum"].(float64); exists {
+		rules = append(rules, "gt="+strconv.FormatFloat(limit, 'f', -1, 64))
+	}
+	if limit, exists := schema["minimum"].(float64); exists {
+		rules = append(rules, "gte="+strconv.FormatFloat(limit, 'f', -1, 64))
+	}
+	if limit, exists := schema["maxItems"].(int); exists {
+		rules = append(rules, "max="+strconv.Itoa(limit))
+	}
+	if len(rules) == 0 {
+		return ""
+	}
+	if _, isString := value.(string); isString {
+		rules = append([]string{"omitempty"}, rules...)
+	}
+	return checkRules(reflect.ValueOf(value), strings.Join(rules, ","))
+}
+
+// isEmptyJSON reports whether a property is missing or has an empty value
+func isEmptyJSON(value interface{}) bool {
+	switch typed := value.(type) {
+	case nil:
+		return true
+	case string:
+		return typed == ""
+	case float64:
+		return typed == 0
+	case bool:
+		return !typed
+	case []interface{}:
+		return len(typed) == 0
+	}
+	return false
+}
+
+// isRequired reports whether a schema requires the property
+func isRequired(schema fiber.Map, name string) bool {
+	required, _ := schema["required"].([]string)
+	for _, requiredName := range required {
+		if requiredName == name {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldOrBody names the field of an error, "body" for the whole body
+func fieldOrBody(path string) string {
+	if path == "" {
+		return "body"
+	}
+	return path
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// Test that request bodies are checked against the spec before the handler runs
+func TestOpenAPIValidatorRequestBody(t *testing.T) {
+	app := setupApp()
+
+	status, body := doJSON(t, app, http.MethodPost, "/v1/carts", `{"customer_id": "cust_spec", "items": [{"item_id": "item001", "quantity": "two"}]}`)
+	assert.Equal(t, 400, status)
+	errBody := body["error"].(map[string]interface{})
+	assert.Equal(t, "InvalidRequest", errBody["code"])
+	assert.Equal(t, "items[0].quantity", errBody["target"])
+	assert.Equal(t, "must be an integer", errBody["details"].([]interface{})[0].(map[string]interface{})["reason"])
+
+	// The cart was never created
+	_, exists := defaultTenant.Carts["cust_spec"]
+	assert.False(t, exists)
+
+	status, body = doJSON(t, app, http.MethodPost, "/v1/carts", `{"customer_id": "cust_spec", "items": {"item_id": "item001"}}`)
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "items", body["error"].(map[string]interface{})["target"])
+}
+
+// Test that required query parameters are checked
+func TestOpenAPIValidatorQuery(t *testing.T) {
+	app := setupApp()
+
+	status, body := doJSON(t, app, http.MethodGet, "/wait-grace-period", "")
+	assert.Equal(t, 400, status)
+	errBody := body["error"].(map[string]interface{})
+	assert.Equal(t, "order_id", errBody["target"])
+	assert.Equal(t, "is required", errBody["details"].([]interface{})[0].(map[string]interface{})["reason"])
+}
+
+// Test that strict mode replaces responses that break the contract, and that
+// the default mode passes them on
+func TestOpenAPIValidatorResponses(t *testing.T) {
+	for mode, wantStatus := range map[string]int{ValidateStrict: 500, ValidateRequests: 200, ValidateOff: 200} {
+		app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+		app.Use(OpenAPIValidator(mode))
+		app.Get("/v1/orders/:id", func(c *fiber.Ctx) error {
+			return c.JSON(fiber.Map{"message": "Order found", "order": "order_spec"})
+		})
+
+		status, body := doJSON(t, app, http.MethodGet, "/v1/orders/order_spec", "")
+		assert.Equal(t, wantStatus, status, mode)
+		if mode != ValidateStrict {
+			continue
+		}
+		errBody := body["error"].(map[string]interface{})
+		assert.Equal(t, "InternalError", errBody["code"])
+		details := errBody["details"].(map[string]interface{})
+		assert.Equal(t, float64(200), details["status"])
+		assert.Equal(t, "order", details["fields"].([]interface{})[0].(map[string]interface{})["field"])
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+// Struct to represent the price breakdown of a cart or order
+type Totals struct {
+	Subtotal    float64 `json:"subtotal"`     // item prices before discounts
+	Discount    float64 `json:"discount"`     // coupon discounts on the items
+	Tax         float64 `json:"tax"`          // tax added on top of the prices
+	IncludedTax float64 `json:"included_tax"` // tax already part of the prices, shown on receipts
+	Shipping    float64 `json:"shipping"`
+	GrandTotal  float64 `json:"grand_total"` // amount to pay
+}
+
+// priceItems is the pricing pipeline shared by carts, checkout and orders. It
+// takes catalog priced items through the coupon discount, tax on the discounted
+// lines and shipping on the discounted subtotal, and returns the priced order
+// lines with their totals. Tax and shipping follow the shipping address, or
+// the billing address for orders without one. A nil
+// billing address skips tax and shipping, and an empty shipping method skips
+// shipping. Tax comes from the tenant's calculator. It returns false when the
+// shipping method is not available for the destination and weight.
+func priceItems(tax TaxCalculator, items []Item, promotion *Promotion, address *BillingAddress, shipTo *ShippingAddress, shippingMethod string) ([]OrderItem, Totals, bool) {
+	var discounts []float64
+	if promotion != nil {
+		discounts = promotion.lineDiscounts(items)
+	}
+
+	lines := make([]OrderItem, len(items))
+	var totals Totals
+	var taxedTo BillingAddress
+	if address != nil {
+		taxedTo = taxAddress(address, shipTo)
+	}
+	for i, item := range items {
+		line := OrderItem{
+			ItemID:   item.ItemID,
+			Name:     item.Name,
+			Quantity: item.Quantity,
+			Price:    item.Price,
+			TaxClass: item.TaxClass,
+		}
+		if discounts != nil {
+			line.Discount = discounts[i]
+		}
+
+		lineAmount := roundAmount(float64(item.Quantity)*item.Price - line.Discount)
+		if address != nil {
+			lineTax := tax.Calculate(taxedTo, item.TaxClass, lineAmount)
+			line.TaxRate = lineTax.Rate
+			line.Tax = lineTax.Amount
+			line.TaxInclusive = lineTax.Inclusive
+		}
+
+		line.Total = lineAmount
+		if line.TaxInclusive {
+			totals.IncludedTax += line.Tax
+		} else {
+			line.Total = roundAmount(lineAmount + line.Tax)
+			totals.Tax += line.Tax
+		}
+
+		totals.Subtotal += float64(item.Quantity) * item.Price
+		totals.Discount += line.Discount
+		lines[i] = line
+	}
+
+	totals.Subtotal = roundAmount(totals.Subtotal)
+	totals.Discount = roundAmount(totals.Discount)
+	totals.Tax = roundAmount(totals.Tax)
+	totals.IncludedTax = roundAmount(totals.IncludedTax)
+
+	if address != nil && shippingMethod != "" {
+		shipping, available := shippingCost(shippingMethod, shippingCountry(address, shipTo), cartWeight(items), totals.discountedSubtotal())
+		if !available {
+			return lines, totals, false
+		}
+		totals.Shipping = shipping
+	}
+
+	totals.GrandTotal = roundAmount(totals.discountedSubtotal() + totals.Tax + totals.Shipping)
+	return lines, totals, true
+}
+
+// shippingCountry returns the country shipping is priced to
+func shippingCountry(billing *BillingAddress, shipTo *ShippingAddress) string {
+	if shipTo != nil {
+		return shipTo.Country
+	}
+	return billing.Country
+}
+
+// taxAddress returns the address tax is calculated for: the place of supply
+// is where the goods are delivered, so the shipping address when there is one
+func taxAddress(billing *BillingAddress, shipTo *ShippingAddress) BillingAddress {
+	if shipTo == nil {
+		return *billing
+	}
+	address := *billing
+	address.Address = shipTo.Address
+	address.City = shipTo.City
+	address.PostalCode = shipTo.PostalCode
+	address.Region = shipTo.Region
+	address.Country = shipTo.Country
+	return address
+}
+
+// discountedSubtotal returns the item subtotal after discounts, which free
+// shipping thresholds are measured against
+func (t Totals) discountedSubtotal() float64 {
+	return roundAmount(t.Subtotal - t.Discount)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test that line and order totals come out of the pipeline consistently
+func TestPriceItems(t *testing.T) {
+	items := []Item{
+		{ItemID: "item001", Quantity: 1, Price: 1000, TaxClass: "standard", Weight: 2},
+		{ItemID: "item002", Quantity: 2, Price: 50, TaxClass: "standard", Weight: 0.1},
+	}
+	promotion := &Promotion{Type: PromotionPercentage, Value: 10}
+
+	// Exclusive tax is charged on the discounted lines and shipping on the discounted subtotal
+	lines, totals, ok := priceItems(taxCalculator, items, promotion, &BillingAddress{Country: "ID"}, nil, ShippingExpress)
+	assert.True(t, ok)
+	assert.Equal(t, Totals{Subtotal: 1100, Discount: 110, Tax: 108.9, Shipping: 45, GrandTotal: 1143.9}, totals)
+	assert.Equal(t, 100.0, lines[0].Discount)
+	assert.Equal(t, 99.0, lines[0].Tax)
+	assert.Equal(t, 999.0, lines[0].Total)
+
+	// Inclusive tax is reported but not added
+	lines, totals, ok = priceItems(taxCalculator, items, nil, &BillingAddress{Country: "GB"}, nil, ShippingStandard)
+	assert.True(t, ok)
+	assert.Equal(t, Totals{Subtotal: 1100, IncludedTax: 183.34, Shipping: 0, GrandTotal: 1100}, totals)
+	assert.Equal(t, 1000.0, lines[0].Total)
+
+	// Goods shipped elsewhere are taxed where they are delivered
+	lines, totals, _ = priceItems(taxCalculator, items, nil, &BillingAddress{Country: "GB"}, &ShippingAddress{Region: "CA", Country: "US"}, "")
+	assert.Equal(t, Totals{Subtotal: 1100, Tax: 79.75, GrandTotal: 1179.75}, totals)
+	assert.Equal(t, 0.0725, lines[0].TaxRate)
+	assert.False(t, lines[0].TaxInclusive)
+
+	// Without an address only discounts are applied
+	_, totals, _ = priceItems(taxCalculator, items, promotion, nil, nil, ShippingExpress)
+	assert.Equal(t, Totals{Subtotal: 1100, Discount: 110, GrandTotal: 990}, totals)
+
+	_, _, ok = priceItems(taxCalculator, items, nil, &BillingAddress{Country: "ID"}, nil, "drone")
+	assert.False(t, ok)
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Promotion types
+const (
+	PromotionPercentage = "percentage"  // Value percent off eligible items
+	PromotionFixed      = "fixed"       // Value off eligible items, spread across them
+	PromotionBuyXGetY   = "buy_x_get_y" // for every BuyQuantity units of an eligible item, GetQuantity more are free
+)
+
+// Struct to represent a promotion redeemed with a coupon code
+type Promotion struct {
+	Code                  string     `json:"code" validate:"required"`
+	Type                  string     `json:"type" validate:"oneof=percentage fixed buy_x_get_y"`
+	Value                 float64    `json:"value" validate:"gte=0"` // percentage (10 for 10%) or fixed amount
+	SKUs                  []string   `json:"skus"`                   // eligible items, empty means every item
+	BuyQuantity           int        `json:"buy_quantity" validate:"gte=0"`
+	GetQuantity           int        `json:"get_quantity" validate:"gte=0"`
+	MinSpend              float64    `json:"min_spend" validate:"gte=0"`                // minimum item subtotal
+	UsageLimitPerCustomer int        `json:"usage_limit_per_customer" validate:"gte=0"` // 0 means unlimited
+	StartsAt              *time.Time `json:"starts_at"`
+	EndsAt                *time.Time `json:"ends_at"`
+}
+
+// Struct to represent the promotion applied to an order, frozen at checkout
+type AppliedPromotion struct {
+	Code     string  `json:"code"`
+	Type     string  `json:"type"`
+	Value    float64 `json:"value"`
+	Discount float64 `json:"discount"`
+}
+
+// Request struct for applying a coupon code to a cart
+type ApplyCouponRequest struct {
+	CustomerID string `json:"customer_id" validate:"required"`
+	Code       string `json:"code" validate:"required"`
+}
+
+// Request struct for removing the coupon code from a cart
+type RemoveCouponRequest struct {
+	CustomerID string `json:"customer_id"` // taken from the path on /v1
+}
+
+// normalizeCouponCode makes coupon codes case-insensitive
+func normalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// isEligible reports whether the promotion applies to the item
+func (p *Promotion) isEligible(itemID string) bool {
+	if len(p.SKUs) == 0 {
+		return true
+	}
+	for _, sku := range p.SKUs {
+		if sku == itemID {
+			return true
+		}
+	}
+	return false
+}
+
+// lineDiscounts allocates the promotion's discount across the cart lines and
+// returns the discount for each line in cart order. Keeping the discount per
+// line lets refunds take back the right share when only some lines are returned.
+func (p *Promotion) lineDiscounts(items []Item) []float64 {
+	discounts := make([]float64, len(items))
+
+	var eligibleSubtotal float64
+	for _, item := range items {
+		if p.isEligible(item.ItemID) {
+			eligibleSubtotal += float64(item.Quantity) * item.Price
+		}
+	}
+	if eligibleSubtotal == 0 {
+		return discounts
+	}
+
+	switch p.Type {
+	case PromotionPercentage:
+		for i, item := range items {
+			if p.isEligible(item.ItemID) {
+				discounts[i] = roundAmount(float64(item.Quantity) * item.Price * p.Value / 100)
+			}
+		}
+
+	case PromotionFixed:
+		// Spread the amount by line value; the last eligible line takes the rounding remainder
+		total := roundAmount(minFloat(p.Value, eligibleSubtotal))
+		remaining := total
+		last := -1
+		for i, item := range items {
+			if p.isEligible(item.ItemID) {
+				discounts[i] = roundAmount(total * float64(item.Quantity) * item.Price / eligibleSubtotal)
+				remaining = roundAmount(remaining - discounts[i])
+				last = i
+			}
+		}
+		discounts[last] = roundAmount(discounts[last] + remaining)
+
+	case PromotionBuyXGetY:
+		for i, item := range items {
+			if p.isEligible(item.ItemID) && p.BuyQuantity > 0 && p.GetQuantity > 0 {
+				freeUnits := item.Quantity / (p.BuyQuantity + p.GetQuantity) * p.GetQuantity
+				discounts[i] = roundAmount(float64(freeUnits) * item.Price)
+			}
+		}
+	}
+	return discounts
+}
+
+// minFloat returns the smaller of a and b
+func minFloat(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// checkPromotion validates a coupon code of the tenant for a customer and item
+// subtotal. On failure it returns the API error.
+func checkPromotion(tenant *Tenant, code, customerID string, subtotal float64, now time.Time) (*Promotion, error) {
+	promotion, exists := tenant.Promotions[normalizeCouponCode(code)]
+	if !exists {
+		log.Warn().Msgf("Coupon code %s not found", code)
+		return nil, ErrPromotionNotFound.WithTarget("code")
+	}
+
+	if (promotion.StartsAt != nil && now.Before(*promotion.StartsAt)) ||
+		(promotion.EndsAt != nil && now.After(*promotion.EndsAt)) {
+		log.Warn().Msgf("Coupon code %s is outside its validity window", promotion.Code)
+		return nil, ErrPromotionNotActive.WithTarget("code")
+	}
+
+	if subtotal < promotion.MinSpend {
+		log.Warn().Msgf("Cart subtotal %.2f below minimum spend for coupon code %s", subtotal, promotion.Code)
+		return nil, ErrMinimumSpendNotReached.WithTarget("code").WithDetails(fiber.Map{
+			"min_spend": promotion.MinSpend,
+			"subtotal":  subtotal,
+		})
+	}
+
+	if promotion.UsageLimitPerCustomer > 0 && tenant.promotionUsage[promotion.Code][customerID] >= promotion.UsageLimitPerCustomer {
+		log.Warn().Msgf("Customer %s reached the usage limit of coupon code %s", customerID, promotion.Code)
+		return nil, ErrPromotionUsageLimitReached.WithTarget("code")
+	}
+
+	return promotion, nil
+}
+
+// recordPromotionUsage counts a paid order against the customer's usage limit
+func recordPromotionUsage(tenant *Tenant, code, customerID string) {
+	if tenant.promotionUsage[code] == nil {
+		tenant.promotionUsage[code] = make(map[string]int)
+	}
+	tenant.promotionUsage[code][customerID]++
+}
+
+// applyCartPromotion recalculates the cart totals with its coupon code, if any
+func applyCartPromotion(cart *Cart, promotion *Promotion) {
+	lines, totals, _ := priceItems(nil, cart.Items, promotion, nil, nil, "")
+	for i := range cart.Items {
+		cart.Items[i].Discount = lines[i].Discount
+	}
+	cart.Totals = totals
+}
+
+func CreatePromotionHandler(c *fiber.Ctx) error {
+	var promotion Promotion
+
+	// Parse JSON input
+	if err := c.BodyParser(&promotion); err != nil {
+		log.Warn().Msg("Invalid JSON input for /promotions")
+		return ErrInvalidJSON
+	}
+
+	promotion.Code = normalizeCouponCode(promotion.Code)
+	if err := checkRequest(promotion); err != nil {
+		return err
+	}
+
+	// Validate the promotion rules for its type
+	valid := false
+	switch promotion.Type {
+	case PromotionPercentage:
+		valid = promotion.Value > 0 && promotion.Value <= 100
+	case PromotionFixed:
+		valid = promotion.Value > 0
+	case PromotionBuyXGetY:
+		valid = promotion.BuyQuantity > 0 && promotion.GetQuantity > 0
+	}
+	if !valid {
+		log.Warn().Msgf("Invalid rules for promotion %s", promotion.Code)
+		return ErrInvalidRequest.WithMessage("Percentage promotions need a value up to 100, fixed promotions a positive value and buy_x_get_y promotions positive buy and get quantities").WithTarget("value")
+	}
+
+	promotions := tenantOf(c).Promotions
+	if _, exists := promotions[promotion.Code]; exists {
+		log.Warn().Msgf("Coupon code %s already exists", promotion.Code)
+		return ErrPromotionAlreadyExists.WithTarget("code")
+	}
+
+	promotions[promotion.Code] = &promotion
+
+	log.Info().Str("event.action", "create_promotion").
+		Str("promotion.code", promotion.Code).
+		Msg("Promotion created successfully")
+
+	return c.Status(201).JSON(fiber.Map{
+		"message":   "Promotion created successfully",
+		"promotion": &promotion,
+	})
+}
+
+func GetPromotionsHandler(c *fiber.Ctx) error {
+	promotions := tenantOf(c).Promotions
+	promotionList := make([]*Promotion, 0, len(promotions))
+	for _, promotion := range promotions {
+		promotionList = append(promotionList, promotion)
+	}
+
+	log.Info().Msg("Fetching all promotions")
+	return c.JSON(fiber.Map{
+		"message":    "All promotions retrieved successfully",
+		"promotions": promotionList,
+	})
+}
+
+func DeletePromotionHandler(c *fiber.Ctx) error {
+	code := normalizeCouponCode(c.Params("code"))
+
+	// Check if promotion exists
+	promotions := tenantOf(c).Promotions
+	if _, exists := promotions[code]; !exists {
+		log.Warn().Msgf("Coupon code %s not found for deletion", code)
+		return ErrPromotionNotFound.WithTarget("code")
+	}
+
+	// Orders keep their own snapshot of the applied promotion
+	delete(promotions, code)
+
+	log.Info().Str("event.action", "delete_promotion").
+		Str("promotion.code", code).
+		Msg("Promotion deleted successfully")
+
+	return c.JSON(fiber.Map{
+		"message": "Promotion deleted successfully",
+	})
+}
+
+func ApplyCouponHandler(c *fiber.Ctx) error {
+	var couponReq ApplyCouponRequest
+
+	// Parse JSON input
+	if err := c.BodyParser(&couponReq); err != nil {
+		log.Warn().Msg("Invalid JSON input for /apply-coupon")
+		return ErrInvalidJSON
+	}
+	couponReq.CustomerID = pathParam(c, "id", couponReq.CustomerID)
+
+	if err := checkRequest(couponReq); err != nil {
+		return err
+	}
+	if err := authorizeOwner(c, PermCartsWrite, couponReq.CustomerID); err != nil {
+		return err
+	}
+
+	// Retrieve the customer's cart
+	cart, exists := tenantOf(c).Carts[couponReq.CustomerID]
+	if !exists {
+		log.Warn().Msgf("Cart for customer ID %s not found", couponReq.CustomerID)
+		return ErrCartNotFound
+	}
+
+	promotion, err := checkPromotion(tenantOf(c), couponReq.Code, couponReq.CustomerID, cart.Totals.Subtotal, time.Now())
+	if err != nil {
+		return err
+	}
+
+	cart.CouponCode = promotion.Code
+	applyCartPromotion(cart, promotion)
+
+	log.Info().Str("event.action", "apply_coupon").
+		Str("customer.id", couponReq.CustomerID).
+		Str("promotion.code", promotion.Code).
+		Float64("discount", cart.Totals.Discount).
+		Msg("Coupon applied to cart")
+
+	return c.JSON(fiber.Map{
+		"message": "Coupon applied",
+		"cart":    cart,
+	})
+}
+
+func RemoveCouponHandler(c *fiber.Ctx) error {
+	// Parse JSON input
+	var payload RemoveCouponRequest
+	if err := parseBody(c, &payload); err != nil {
+		log.Warn().Msg("Invalid JSON input for /remove-coupon")
+		return ErrInvalidJSON
+	}
+	customerID := pathParam(c, "id", payload.CustomerID)
+	if err := authorizeOwner(c, PermCartsWrite, customerID); err != nil {
+		return err
+	}
+
+	// Retrieve the customer's cart
+	cart, exists := tenantOf(c).Carts[customerID]
+	if !exists {
+		log.Warn().Msgf("Cart for customer ID %s not found", customerID)
+		return ErrCartNotFound
+	}
+
+	cart.CouponCode = ""
+	applyCartPromotion(cart, nil)
+
+	return c.JSON(fiber.Map{
+		"message": "Coupon removed",
+		"cart":    cart,
+	})
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test how each promotion type spreads its discount over the cart lines
+func TestPromotionLineDiscounts(t *testing.T) {
+	items := []Item{
+		{ItemID: "item001", Quantity: 1, Price: 1000},
+		{ItemID: "item002", Quantity: 5, Price: 50},
+	}
+
+	percentage := &Promotion{Type: PromotionPercentage, Value: 10}
+	assert.Equal(t, []float64{100, 25}, percentage.lineDiscounts(items))
+
+	// Only eligible SKUs are discounted
+	percentage.SKUs = []string{"item002"}
+	assert.Equal(t, []float64{0, 25}, percentage.lineDiscounts(items))
+
+	// Fixed discounts are prorated by line value and never exceed it
+	fixed := &Promotion{Type: PromotionFixed, Value: 100}
+	assert.Equal(t, []float64{80, 20}, fixed.lineDiscounts(items))
+	fixed = &Promotion{Type: PromotionFixed, Value: 500, SKUs: []string{"item002"}}
+	assert.Equal(t, []float64{0, 250}, fixed.lineDiscounts(items))
+
+	// Buy 2 get 1: five mice contain one complete set of three
+	buyXGetY := &Promotion{Type: PromotionBuyXGetY, BuyQuantity: 2, GetQuantity: 1, SKUs: []string{"item002"}}
+	assert.Equal(t, []float64{0, 50}, buyXGetY.lineDiscounts(items))
+}
+
+// Test applying a coupon code to a cart and paying the discounted total
+func TestApplyCouponAndCheckout(t *testing.T) {
+	app := setupApp()
+
+	status, _ := doJSON(t, app, http.MethodPost, "/promotions", `{
+		"code": "save10", "type": "percentage", "value": 10, "min_spend": 500, "usage_limit_per_customer": 1
+	}`)
+	assert.Equal(t, 201, status)
+
+	status, body := doJSON(t, app, http.MethodPost, "/promotions", `{"code": "SAVE10", "type": "fixed", "value": 5}`)
+	assert.Equal(t, 409, status)
+	assert.Equal(t, "PromotionAlreadyExists", body["error"].(map[string]interface{})["code"])
+
+	// Below the minimum spend
+	doJSON(t, app, http.MethodPost, "/create-cart", `{"customer_id": "cust_coupon", "items": [{"item_id": "item002", "quantity": 1}]}`)
+	status, body = doJSON(t, app, http.MethodPost, "/apply-coupon", `{"customer_id": "cust_coupon", "code": "SAVE10"}`)
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "MinimumSpendNotReached", body["error"].(map[string]interface{})["code"])
+
+	status, body = doJSON(t, app, http.MethodPost, "/apply-coupon", `{"customer_id": "cust_coupon", "code": "NOPE"}`)
+	assert.Equal(t, 404, status)
+	assert.Equal(t, "PromotionNotFound", body["error"].(map[string]interface{})["code"])
+
+	doJSON(t, app, http.MethodPost, "/create-cart", `{
+		"customer_id": "cust_coupon",
+		"items": [{"item_id": "item001", "quantity": 1}, {"item_id": "item002", "quantity": 2}]
+	}`)
+	status, body = doJSON(t, app, http.MethodPost, "/apply-coupon", `{"customer_id": "cust_coupon", "code": "save10"}`)
+	assert.Equal(t, 200, status)
+	cart := body["cart"].(map[string]interface{})
+	assert.Equal(t, "SAVE10", cart["coupon_code"])
+	assert.Equal(t, 1100.0, cart["totals"].(map[string]interface{})["subtotal"])
+	assert.Equal(t, 110.0, cart["totals"].(map[string]interface{})["discount"])
+
+	billingAddress := map[string]interface{}{
+		"customer_id": "cust_coupon",
+		"name":        "John Doe",
+		"email":       "john@example.com",
+		"phone":       "+15555555555",
+		"country":     "US",
+	}
+
+	// The discounted subtotal no longer reaches free shipping
+	quotePayload, _ := json.Marshal(map[string]interface{}{"billing_address": billingAddress})
+	status, body = doJSON(t, app, http.MethodPost, "/quote-cart", string(quotePayload))
+	assert.Equal(t, 200, status)
+	totals := body["totals"].(map[string]interface{})
+	assert.Equal(t, 110.0, totals["discount"])
+	assert.Equal(t, 30.0, totals["shipping"])
+	assert.Equal(t, 1020.0, totals["grand_total"])
+
+	paymentPayload, _ := json.Marshal(map[string]interface{}{
+		"order_id":        "order_coupon",
+		"amount":          1020,
+		"billing_address": billingAddress,
+	})
+	status, body = doJSON(t, app, http.MethodPost, "/process-payment", string(paymentPayload))
+	assert.Equal(t, 200, status)
+
+	order := body["order"].(map[string]interface{})
+	assert.Equal(t, 1020.0, order["Amount"])
+	assert.Equal(t, 110.0, order["Totals"].(map[string]interface{})["discount"])
+	promotion := order["Promotion"].(map[string]interface{})
+	assert.Equal(t, "SAVE10", promotion["code"])
+	assert.Equal(t, 110.0, promotion["discount"])
+	assert.Equal(t, 100.0, order["Items"].([]interface{})[0].(map[string]interface{})["discount"])
+
+	// The coupon can only be used once per customer, also on their next cart
+	doJSON(t, app, http.MethodPost, "/create-cart", `{"customer_id": "cust_coupon", "items": [{"item_id": "item001", "quantity": 1}]}`)
+	status, body = doJSON(t, app, http.MethodPost, "/apply-coupon", `{"customer_id": "cust_coupon", "code": "SAVE10"}`)
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "PromotionUsageLimitReached", body["error"].(map[string]interface{})["code"])
+}
+
+// Test that coupons outside their validity window are rejected
+func TestApplyCouponValidityWindow(t *testing.T) {
+	app := setupApp()
+
+	startsAt := time.Now().Add(24 * time.Hour).UTC().Format(time.RFC3339)
+	status, _ := doJSON(t, app, http.MethodPost, "/promotions", `{
+		"code": "LATER", "type": "fixed", "value": 20, "starts_at": "`+startsAt+`"
+	}`)
+	assert.Equal(t, 201, status)
+
+	doJSON(t, app, http.MethodPost, "/create-cart", `{"customer_id": "cust_coupon_later", "items": [{"item_id": "item002", "quantity": 1}]}`)
+	status, body := doJSON(t, app, http.MethodPost, "/apply-coupon", `{"customer_id": "cust_coupon_later", "code": "LATER"}`)
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "PromotionNotActive", body["error"].(map[string]interface{})["code"])
+}
+
+// Test that quotes check the cart's coupon code the same way payment does
+func TestQuoteCartChecksCoupon(t *testing.T) {
+	app := setupApp()
+
+	status, _ := doJSON(t, app, http.MethodPost, "/promotions", `{"code": "QUOTE5", "type": "fixed", "value": 5}`)
+	assert.Equal(t, 201, status)
+	doJSON(t, app, http.MethodPost, "/create-cart", `{"customer_id": "cust_coupon_quote", "items": [{"item_id": "item002", "quantity": 1}]}`)
+	status, _ = doJSON(t, app, http.MethodPost, "/apply-coupon", `{"customer_id": "cust_coupon_quote", "code": "QUOTE5"}`)
+	assert.Equal(t, 200, status)
+
+	quotePayload := `{"billing_address": {"customer_id": "cust_coupon_quote", "name": "John Doe", "email": "john@example.com", "phone": "+15555555555", "country": "US"}}`
+	status, body := doJSON(t, app, http.MethodPost, "/quote-cart", quotePayload)
+	assert.Equal(t, 200, status)
+	assert.Equal(t, 5.0, body["totals"].(map[string]interface{})["discount"])
+
+	// A coupon removed since it was applied is not quoted
+	status, _ = doJSON(t, app, http.MethodDelete, "/promotions/QUOTE5", "")
+	assert.Equal(t, 200, status)
+	status, body = doJSON(t, app, http.MethodPost, "/quote-cart", quotePayload)
+	assert.Equal(t, 404, status)
+	assert.Equal(t, "PromotionNotFound", errorCode(body))
+}
+
+// Test that returning a discounted line refunds its price less its share of the discount
+func TestReturnRefundProratesDiscount(t *testing.T) {
+	app := setupApp()
+
+	status, _ := doJSON(t, app, http.MethodPost, "/promotions", `{"code": "TAKE100", "type": "fixed", "value": 100}`)
+	assert.Equal(t, 201, status)
+
+	doJSON(t, app, http.MethodPost, "/create-cart", `{
+		"customer_id": "cust_coupon_return",
+		"items": [{"item_id": "item001", "quantity": 1}, {"item_id": "item002", "quantity": 2}]
+	}`)
+	status, _ = doJSON(t, app, http.MethodPost, "/apply-coupon", `{"customer_id": "cust_coupon_return", "code": "TAKE100"}`)
+	assert.Equal(t, 200, status)
+
+	paymentPayload, _ := json.Marshal(map[string]interface{}{
+		"order_id": "order_coupon_return",
+		"amount":   1000,
+		"billing_address": map[string]interface{}{
+			"customer_id": "cust_coupon_return",
+			"name":        "John Doe",
+			"email":       "john@example.com",
+			"phone":       "+15555555555",
+			"country":     "US",
+		},
+	})
+	status, _ = doJSON(t, app, http.MethodPost, "/process-payment", string(paymentPayload))
+	assert.Equal(t, 200, status)
+	doJSON(t, app, http.MethodPost, "/route-order", `{"order_id": "order_coupon_return"}`)
+	doJSON(t, app, http.MethodPost, "/fulfill-order", `{"order_id": "order_coupon_return"}`)
+
+	// The mice carry 9.09 of the 100 discount
+	status, body := doJSON(t, app, http.MethodPost, "/returns", `{
+		"order_id": "order_coupon_return", "items": [{"item_id": "item002", "quantity": 2, "reason": "Not needed"}]
+	}`)
+	assert.Equal(t, 201, status)
+	assert.Equal(t, 90.91, body["return"].(map[string]interface{})["refund_amount"])
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Struct to represent a message published to a broker topic
+type BrokerMessage struct {
+	Topic   string
+	Key     string // messages with the same key keep their order
+	Headers map[string]string
+	Value   []byte
+}
+
+// Publisher sends messages to a message broker. Publish returns once the
+// broker has accepted the message.
+type Publisher interface {
+	Publish(ctx context.Context, message BrokerMessage) error
+	Close() error
+}
+
+// Struct to represent a domain event as a CloudEvents 1.0 JSON message
+type CloudEvent struct {
+	SpecVersion     string    `json:"specversion"`
+	ID              string    `json:"id"`
+	Source          string    `json:"source"`
+	Type            string    `json:"type"`
+	Subject         string    `json:"subject,omitempty"` // the order, when there is one
+	Time            time.Time `json:"time"`
+	DataContentType string    `json:"datacontenttype"`
+	PartitionKey    string    `json:"partitionkey"` // partitioning extension
+	TenantID        string    `json:"tenantid"`
+	Data            Event     `json:"data"`
+}
+
+const (
+	cloudEventsSpecVersion = "1.0"
+	cloudEventsContentType = "application/cloudevents+json"
+
+	defaultBrokerTopic   = "order-events"
+	defaultBrokerSource  = "/order-app"
+	brokerPublishTimeout = 5 * time.Second
+)
+
+// Struct to represent where domain events are published
+type BrokerConfig struct {
+	Publisher Publisher
+	Topic     string
+	Source    string // CloudEvents source of the events
+}
+
+// loadBrokerConfig reads the broker from the environment, or nil when
+// BROKER_URL is not set. BROKER_URL is a nats:// URL, or memory:// to keep
+// the messages in the process.
+func loadBrokerConfig(getenv func(string) string) (*BrokerConfig, error) {
+	brokerURL := getenv("BROKER_URL")
+	if brokerURL == "" {
+		return nil, nil
+	}
+
+	config := &BrokerConfig{Topic: defaultBrokerTopic, Source: defaultBrokerSource}
+	if topic := getenv("BROKER_TOPIC"); topic != "" {
+		config.Topic = topic
+	}
+	if source := getenv("BROKER_SOURCE"); source != "" {
+		config.Source = source
+	}
+
+	parsed, err := url.Parse(brokerURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid BROKER_URL: %w", err)
+	}
+	switch parsed.Scheme {
+	case "nats":
+		config.Publisher = NewNATSPublisher(parsed)
+	case "memory":
+		config.Publisher = NewMemoryPublisher()
+	default:
+		return nil, fmt.Errorf("invalid BROKER_URL: unsupported scheme %q", parsed.Scheme)
+	}
+	return config, nil
+}
+
+// eventKey keeps the events of an order in order; cart events have no order
+// yet and are keyed by their customer
+func eventKey(event Event) string {
+	if event.OrderID != "" {
+		return event.OrderID
+	}
+	return "customer:" + event.CustomerID
+}
+
+func newCloudEvent(event Event, source string) CloudEvent {
+	return CloudEvent{
+		SpecVersion:     cloudEventsSpecVersion,
+		ID:              event.ID,
+		Source:          source,
+		Type:            event.Type,
+		Subject:         event.OrderID,
+		Time:            event.OccurredAt,
+		DataContentType: "application/json",
+		PartitionKey:    eventKey(event),
+		TenantID:        event.TenantID,
+		Data:            event,
+	}
+}
+
+// PublishEventHandler publishes events as CloudEvents keyed by order. It is
+// subscribed in order, so a failed event holds back the later ones of its
+// order until its retry succeeds.
+func PublishEventHandler(config *BrokerConfig) EventHandler {
+	return func(event Event) error {
+		value, err := json.Marshal(newCloudEvent(event, config.Source))
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), brokerPublishTimeout)
+		defer cancel()
+		return config.Publisher.Publish(ctx, BrokerMessage{
+			Topic:   config.Topic,
+			Key:     eventKey(event),
+			Headers: map[string]string{"content-type": cloudEventsContentType, "ce-id": event.ID, "ce-type": event.Type},
+			Value:   value,
+		})
+	}
+}
+
+// MemoryPublisher keeps published messages in the process, for tests and
+// local runs without a broker
+type MemoryPublisher struct {
+	mu       sync.Mutex
+	messages []BrokerMessage
+	err      error // returned by Publish when set
+}
+
+func NewMemoryPublisher() *MemoryPublisher {
+	return &MemoryPublisher{}
+}
+
+func (p *MemoryPublisher) Publish(ctx context.Context, message BrokerMessage) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	p.messages = append(p.messages, message)
+	return nil
+}
+
+func (p *MemoryPublisher) Close() error {
+	return nil
+}
+
+// FailWith makes the next publishes fail with an error, or succeed again
+// when it is nil
+func (p *MemoryPublisher) FailWith(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.err = err
+}
+
+// Messages returns the messages published with a key, oldest first, or all
+// of them when the key is empty
+func (p *MemoryPublisher) Messages(key string) []BrokerMessage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var messages []BrokerMessage
+	for _, message := range p.messages {
+		if key == "" || message.Key == key {
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
+
+// NATSPublisher publishes to NATS JetStream on the subject <topic>.<key>, so
+// streams and consumers keep the order of each key. A stream must capture the
+// subjects: Publish returns once JetStream has stored the message and
+// acknowledged it. The event ID is sent as Nats-Msg-Id, so JetStream drops the
+// duplicates of retried publishes within its duplicate window. It connects on
+// the first publish and lets the client reconnect after connection errors.
+type NATSPublisher struct {
+	mu      sync.Mutex
+	address string
+	user    *url.Userinfo
+	conn    *nats.Conn
+	js      jetstream.JetStream
+}
+
+func NewNATSPublisher(server *url.URL) *NATSPublisher {
+	address := server.Host
+	if server.Port() == "" {
+		address = net.JoinHostPort(server.Hostname(), "4222")
+	}
+	return &NATSPublisher{address: address, user: server.User}
+}
+
+func (p *NATSPublisher) Publish(ctx context.Context, message BrokerMessage) error {
+	js, err := p.jetStream()
+	if err != nil {
+		return fmt.Errorf("nats connect: %w", err)
+	}
+
+	msg := nats.NewMsg(message.Topic + "." + natsToken(message.Key))
+	for name, value := range message.Headers {
+		msg.Header.Set(name, value)
+	}
+	msg.Data = message.Value
+
+	var options []jetstream.PublishOpt
+	if id := message.Headers["ce-id"]; id != "" {
+		options = append(options, jetstream.WithMsgID(id))
+	}
+	ack, err := js.PublishMsg(ctx, msg, options...)
+	if err != nil {
+		return fmt.Errorf("jetstream publish: %w", err)
+	}
+	if ack.Duplicate {
+		// Stored by an earlier attempt whose acknowledgement was lost
+		log.Debug().Str("event.id", message.Headers["ce-id"]).Str("nats.stream", ack.Stream).Msg("Event already stored by JetStream")
+	}
+	return nil
+}
+
+// jetStream connects on first use
+func (p *NATSPublisher) jetStream() (jetstream.JetStream, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.js != nil {
+		return p.js, nil
+	}
+
+	options := []nats.Option{nats.Name("order-app"), nats.Timeout(brokerPublishTimeout), nats.MaxReconnects(-1)}
+	if p.user != nil {
+		password, _ := p.user.Password()
+		options = append(options, nats.UserInfo(p.user.Username(), password))
+	}
+	conn, err := nats.Connect("nats://"+p.address, options...)
+	if err != nil {
+		return nil, err
+	}
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	p.conn, p.js = conn, js
+	return js, nil
+}
+
+func (p *NATSPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.Drain()
+	p.conn, p.js = nil, nil
+	return err
+}
+
+// natsToken makes a key usable as one subject token, which cannot contain
+// dots, wildcards or whitespace
+func natsToken(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '*', '>', ' ', '\t', '\r', '\n':
+			return '_'
+		}
+		return r
+	}, key)
+}
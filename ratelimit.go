@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Struct to represent a token bucket: up to Requests at once, refilled at
+// Requests per Period
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// String formats the limit like the configuration, e.g. "10/1m0s"
+func (l RateLimit) String() string {
+	return fmt.Sprintf("%d/%s", l.Requests, l.Period)
+}
+
+// Struct to represent the outcome of taking a token from a bucket
+type RateDecision struct {
+	Allowed    bool
+	Limit      RateLimit
+	Remaining  int           // whole tokens left
+	Reset      time.Duration // until the bucket is full again
+	RetryAfter time.Duration // until the next token, when not allowed
+}
+
+// Limiter keeps the token buckets of rate-limited clients. MemoryLimiter keeps
+// them in this process; a shared backend lets several instances enforce the
+// same limits.
+type Limiter interface {
+	Take(key string, limit RateLimit, now time.Time) RateDecision
+}
+
+// Struct to represent the state of a client's bucket
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time // when the bucket is full again, so it can be dropped
+}
+
+// MemoryLimiter keeps token buckets in memory
+type MemoryLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// Interval between drops of buckets that have refilled
+const limiterSweepInterval = time.Minute
+
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{buckets: make(map[string]*tokenBucket)}
+}
+
+// Take refills the key's bucket for the time passed and takes a token if one
+// is left
+func (m *MemoryLimiter) Take(key string, limit RateLimit, now time.Time) RateDecision {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sweep(now)
+
+	capacity := float64(limit.Requests)
+	rate := capacity / limit.Period.Seconds() // tokens per second
+	bucket, exists := m.buckets[key]
+	if !exists {
+		bucket = &tokenBucket{tokens: capacity, updated: now}
+		m.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(capacity, bucket.tokens+now.Sub(bucket.updated).Seconds()*rate)
+	bucket.updated = now
+
+	decision := RateDecision{Limit: limit}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		decision.Allowed = true
+	} else {
+		decision.RetryAfter = secondsDuration((1 - bucket.tokens) / rate)
+	}
+	decision.Remaining = int(bucket.tokens)
+	decision.Reset = secondsDuration((capacity - bucket.tokens) / rate)
+	bucket.full = now.Add(decision.Reset)
+	return decision
+}
+
+// sweep drops the buckets that have refilled, which behave like new ones
+func (m *MemoryLimiter) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < limiterSweepInterval {
+		return
+	}
+	m.lastSweep = now
+	for key, bucket := range m.buckets {
+		if !now.Before(bucket.full) {
+			delete(m.buckets, key)
+		}
+	}
+}
+
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// Struct to hold the rate limits of the API. Each client gets a bucket per IP,
+// per API key or token subject, and per customer; payment and refund requests
+// also take from a stricter bucket.
+type RateLimitConfig struct {
+	Default  RateLimit
+	Payments RateLimit
+	Limiter  Limiter
+}
+
+// Rate limits used when none are configured
+var (
+	defaultRateLimit        = RateLimit{Requests: 120, Period: time.Minute}
+	defaultPaymentRateLimit = RateLimit{Requests: 10, Period: time.Minute}
+)
+
+// Routes that move money, limited by RateLimitConfig.Payments
+var paymentRoutes = []string{
+	"/process-payment", "/refund-payment", "/returns/:id/refund", "/exchanges",
+	"/v1/orders", "/v1/orders/:id/refund", "/v1/returns/:id/refund", "/v1/exchanges",
+}
+
+// isPaymentRoute reports whether a request pays or refunds
+func isPaymentRoute(method, path string) bool {
+	if method != fiber.MethodPost {
+		return false
+	}
+	for _, route := range paymentRoutes {
+		if matchRoutePattern(route, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchRoutePattern matches a path against a route with ":param" segments
+func matchRoutePattern(pattern, path string) bool {
+	patternParts := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathParts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(patternParts) != len(pathParts) {
+		return false
+	}
+	for i, part := range patternParts {
+		if !strings.HasPrefix(part, ":") && part != pathParts[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// loadRateLimitConfig reads the rate limits from the environment:
+// RATE_LIMIT="120/1m" for every client and RATE_LIMIT_PAYMENTS="10/1m" for
+// payments and refunds. RATE_LIMIT=off turns rate limiting off.
+func loadRateLimitConfig(getenv func(string) string) (*RateLimitConfig, error) {
+	if getenv("RATE_LIMIT") == "off" {
+		return nil, nil
+	}
+
+	config := &RateLimitConfig{Default: defaultRateLimit, Payments: defaultPaymentRateLimit, Limiter: NewMemoryLimiter()}
+	for name, limit := range map[string]*RateLimit{"RATE_LIMIT": &config.Default, "RATE_LIMIT_PAYMENTS": &config.Payments} {
+		value := getenv(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := parseRateLimit(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", name, err)
+		}
+		*limit = parsed
+	}
+	return config, nil
+}
+
+// parseRateLimit reads a limit like "10/1m": 10 requests per minute
+func parseRateLimit(value string) (RateLimit, error) {
+	requests, period, ok := strings.Cut(value, "/")
+	if !ok {
+		return RateLimit{}, fmt.Errorf("%q is not <requests>/<period>", value)
+	}
+	count, err := strconv.Atoi(requests)
+	if err != nil || count <= 0 {
+		return RateLimit{}, fmt.Errorf("%q is not a positive number of requests", requests)
+	}
+	duration, err := time.ParseDuration(period)
+	if err != nil || duration <= 0 {
+		return RateLimit{}, fmt.Errorf("%q is not a positive duration", period)
+	}
+	return RateLimit{Requests: count, Period: duration}, nil
+}
+
+// RateLimitByIP limits requests per client IP. It runs before authentication
+// so invalid credentials cannot be tried without limit.
+func RateLimitByIP(config *RateLimitConfig) fiber.Handler {
+	return config.limit(func(c *fiber.Ctx) []string {
+		return []string{"ip:" + c.IP()}
+	})
+}
+
+// RateLimitByClient limits requests per API key or token subject and per
+// customer, once the request is authenticated
+func RateLimitByClient(config *RateLimitConfig) fiber.Handler {
+	return config.limit(func(c *fiber.Ctx) []string {
+		principal := currentPrincipal(c)
+		if principal == nil {
+			return nil
+		}
+		keys := []string{principal.Method + ":" + principal.ID}
+		if principal.CustomerID != "" {
+			keys = append(keys, "customer:"+principal.CustomerID)
+		}
+		return keys
+	})
+}
+
+// limit takes a token from each bucket of a request, answering 429 when one
+// is empty. The RateLimit headers describe the bucket closest to empty.
+func (r *RateLimitConfig) limit(bucketKeys func(c *fiber.Ctx) []string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if r == nil {
+			return c.Next()
+		}
+
+		limits := []RateLimit{r.Default}
+		scopes := []string{""}
+		if isPaymentRoute(c.Method(), c.Path()) {
+			limits = append(limits, r.Payments)
+			scopes = append(scopes, ":payments")
+		}
+
+		now := time.Now()
+		var tightest *RateDecision
+		for _, key := range bucketKeys(c) {
+			for i, limit := range limits {
+				decision := r.Limiter.Take(key+scopes[i], limit, now)
+				if !decision.Allowed {
+					setRateLimitHeaders(c, decision)
+					retryAfter := int(math.Ceil(decision.RetryAfter.Seconds()))
+					c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+					log.Warn().
+						Str("client.key", key+scopes[i]).
+						Str("url.path", c.Path()).
+						Msgf("Rate limit %s exceeded", limit)
+					return ErrRateLimited.WithDetails(fiber.Map{
+						"limit":       limit.String(),
+						"retry_after": retryAfter,
+					})
+				}
+				if tightest == nil || decision.Remaining < tightest.Remaining {
+					tightest = &decision
+				}
+			}
+		}
+		if tightest != nil && !tighterLimitReported(c, tightest.Remaining) {
+			setRateLimitHeaders(c, *tightest)
+		}
+		return c.Next()
+	}
+}
+
+// tighterLimitReported reports whether an earlier limiter already described a
+// bucket with fewer tokens left
+func tighterLimitReported(c *fiber.Ctx, remaining int) bool {
+	reported, err := strconv.Atoi(c.GetRespHeader("RateLimit-Remaining"))
+	return err == nil && reported <= remaining
+}
+
+// setRateLimitHeaders describes a bucket with the IETF RateLimit headers
+func setRateLimitHeaders(c *fiber.Ctx, decision RateDecision) {
+	c.Set("RateLimit-Limit", strconv.Itoa(decision.Limit.Requests))
+	c.Set("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+	c.Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(decision.Reset.Seconds()))))
+	c.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", decision.Limit.Requests, int(decision.Limit.Period.Seconds())))
+}
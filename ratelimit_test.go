@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupRateLimitApp(rateLimits *RateLimitConfig, auth *AuthConfig) *fiber.App {
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	app.Use(RateLimitByIP(rateLimits))
+	app.Use(Authenticate(auth))
+	app.Use(ResolveTenant())
+	app.Use(RateLimitByClient(rateLimits))
+	app.Use(OpenAPIValidator(ValidateStrict))
+	app.Use(CommitEvents())
+	setupRoutes(app)
+	return app
+}
+
+// Test that buckets hold a burst and refill over time
+func TestMemoryLimiter(t *testing.T) {
+	limiter := NewMemoryLimiter()
+	limit := RateLimit{Requests: 2, Period: 10 * time.Second}
+	now := time.Now()
+
+	decision := limiter.Take("ip:1", limit, now)
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, 1, decision.Remaining)
+	assert.True(t, limiter.Take("ip:1", limit, now).Allowed)
+
+	decision = limiter.Take("ip:1", limit, now)
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, 5*time.Second, decision.RetryAfter)
+	assert.Equal(t, 10*time.Second, decision.Reset)
+
+	// Other clients have their own bucket
+	assert.True(t, limiter.Take("ip:2", limit, now).Allowed)
+
+	// One token is back after a fifth of the period
+	assert.True(t, limiter.Take("ip:1", limit, now.Add(5*time.Second)).Allowed)
+	assert.False(t, limiter.Take("ip:1", limit, now.Add(5*time.Second)).Allowed)
+}
+
+// Test that limits are read from the environment
+func TestLoadRateLimitConfig(t *testing.T) {
+	config, err := loadRateLimitConfig(func(name string) string {
+		return map[string]string{"RATE_LIMIT_PAYMENTS": "5/30s"}[name]
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, defaultRateLimit, config.Default)
+	assert.Equal(t, RateLimit{Requests: 5, Period: 30 * time.Second}, config.Payments)
+
+	config, err = loadRateLimitConfig(func(name string) string {
+		return map[string]string{"RATE_LIMIT": "off"}[name]
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, config)
+
+	_, err = loadRateLimitConfig(func(name string) string {
+		return map[string]string{"RATE_LIMIT": "fast"}[name]
+	})
+	assert.Error(t, err)
+}
+
+// Test that payments have a stricter limit and exceeding it gets a 429
+func TestRateLimitPayments(t *testing.T) {
+	config := &RateLimitConfig{
+		Default:  RateLimit{Requests: 10, Period: time.Minute},
+		Payments: RateLimit{Requests: 1, Period: time.Minute},
+		Limiter:  NewMemoryLimiter(),
+	}
+	app := setupRateLimitApp(config, nil)
+
+	req, _ := http.NewRequest(http.MethodGet, "/v1/products", nil)
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "10", resp.Header.Get("RateLimit-Limit"))
+	assert.Equal(t, "9", resp.Header.Get("RateLimit-Remaining"))
+	assert.Equal(t, "10;w=60", resp.Header.Get("RateLimit-Policy"))
+
+	createPaidOrder(t, app, "cust_rate_limit", "order_rate_limit", nil)
+
+	// The second payment is over the payment limit, not the general one
+	status, body := doJSON(t, app, http.MethodPost, "/v1/orders/order_rate_limit/refund", "")
+	assert.Equal(t, 429, status)
+	assert.Equal(t, "RateLimited", errorCode(body))
+	assert.Equal(t, "1/1m0s", body["error"].(map[string]interface{})["details"].(map[string]interface{})["limit"])
+	assert.False(t, defaultTenant.Orders["order_rate_limit"].Refunded)
+
+	req, _ = http.NewRequest(http.MethodPost, "/refund-payment", nil)
+	resp, err = app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 429, resp.StatusCode)
+	assert.Equal(t, "60", resp.Header.Get(fiber.HeaderRetryAfter))
+	assert.Equal(t, "0", resp.Header.Get("RateLimit-Remaining"))
+
+	// Other routes are still served
+	req, _ = http.NewRequest(http.MethodGet, "/v1/orders/order_rate_limit", nil)
+	resp, err = app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+}
+
+// Test that each API key and customer has its own bucket
+func TestRateLimitPerClient(t *testing.T) {
+	limiter := NewMemoryLimiter()
+	config := &RateLimitConfig{Default: RateLimit{Requests: 2, Period: time.Minute}, Payments: defaultPaymentRateLimit, Limiter: limiter}
+	auth := &AuthConfig{
+		APIKeys:     map[string]string{"key-a": "client-a", "key-b": "client-b"},
+		APIKeyRoles: map[string][]string{"client-a": {"admin"}, "client-b": {"admin"}},
+		JWTSecret:   rbacSecret,
+	}
+	app := setupRateLimitApp(config, auth)
+
+	// Requests in tests all come from one IP, whose bucket is dropped
+	// after each request so only the client buckets count
+	resetIP := func() { delete(limiter.buckets, "ip:0.0.0.0") }
+
+	for _, expected := range []int{200, 200, 429} {
+		resp, _ := authRequest(t, app, http.MethodGet, "/v1/products", map[string]string{apiKeyHeader: "key-a"})
+		assert.Equal(t, expected, resp.StatusCode)
+		resetIP()
+	}
+	resp, _ := authRequest(t, app, http.MethodGet, "/v1/products", map[string]string{apiKeyHeader: "key-b"})
+	assert.Equal(t, 200, resp.StatusCode)
+
+	// Tokens of different users acting for one customer share its bucket
+	for i, expected := range []int{200, 200, 429} {
+		token := rbacToken(t, "user_rate_"+string(rune('a'+i)), "cust_rate", "customer")
+		status, body := doAuthJSON(t, app, http.MethodGet, "/v1/products", token, "")
+		assert.Equal(t, expected, status)
+		if expected == 429 {
+			assert.Equal(t, "RateLimited", errorCode(body))
+		}
+		resetIP()
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// Permissions checked per route. A role granted a permission with the ":own"
+// suffix may only use it on the carts, orders, returns and account of the
+// customer its token acts for.
+const (
+	PermCartsWrite      = "carts:write"      // create carts, quotes and coupons
+	PermOrdersCreate    = "orders:create"    // pay for a cart
+	PermOrdersRead      = "orders:read"      // orders and shipments
+	PermOrdersCancel    = "orders:cancel"    // cancel before fulfillment
+	PermOrdersRoute     = "orders:route"     // grace period and routing
+	PermOrdersFulfill   = "orders:fulfill"   // fulfillment steps and shipments
+	PermPaymentsCapture = "payments:capture" // capture fulfilled orders
+	PermPaymentsRefund  = "payments:refund"  // refund orders and returns
+	PermReturnsCreate   = "returns:create"   // request returns and exchanges
+	PermReturnsRead     = "returns:read"     // view returns
+	PermReturnsManage   = "returns:manage"   // approve, reject, receive and inspect returns
+	PermProductsRead    = "products:read"    // browse the catalog
+	PermProductsWrite   = "products:write"   // maintain the catalog
+	PermCustomersRead   = "customers:read"   // view accounts
+	PermCustomersWrite  = "customers:write"  // create and update accounts
+	PermPromotionsRead  = "promotions:read"  // view promotions
+	PermPromotionsWrite = "promotions:write" // create and delete promotions
+	PermReportsRead     = "reports:read"     // revenue reports
+	PermWebhooksManage  = "webhooks:manage"  // webhook subscriptions and deliveries
+	PermEventsStream    = "events:stream"    // stream the events of every order
+	PermAll             = "*"                // every permission
+)
+
+// Suffix limiting a permission to the caller's own customer
+const ownPermissionSuffix = ":own"
+
+// Permissions granted to each role
+var rolePermissions = map[string][]string{
+	// Shoppers, acting on their own carts, orders and account
+	"customer": {
+		PermCartsWrite + ownPermissionSuffix,
+		PermOrdersCreate + ownPermissionSuffix,
+		PermOrdersRead + ownPermissionSuffix,
+		PermOrdersCancel + ownPermissionSuffix,
+		PermReturnsCreate + ownPermissionSuffix,
+		PermReturnsRead + ownPermissionSuffix,
+		PermCustomersRead + ownPermissionSuffix,
+		PermCustomersWrite + ownPermissionSuffix,
+		PermProductsRead,
+	},
+	// Store and DC staff moving orders through routing, fulfillment and returns
+	"warehouse": {
+		PermOrdersRead, PermOrdersRoute, PermOrdersFulfill,
+		PermReturnsRead, PermReturnsManage, PermProductsRead,
+	},
+	// Finance staff handling the money
+	"finance": {
+		PermOrdersRead, PermPaymentsCapture, PermPaymentsRefund,
+		PermReturnsRead, PermPromotionsRead, PermReportsRead,
+	},
+	// Customer service acting for any customer
+	"support": {
+		PermCartsWrite, PermOrdersCreate, PermOrdersRead, PermOrdersCancel,
+		PermReturnsCreate, PermReturnsRead, PermCustomersRead, PermCustomersWrite,
+		PermProductsRead, PermPromotionsRead,
+	},
+	// Partner systems following orders through webhooks
+	"integrator": {
+		PermOrdersRead, PermProductsRead, PermWebhooksManage,
+	},
+	"admin": {PermAll},
+}
+
+// grants reports whether the principal's roles include a permission, exactly
+// as named
+func (p *Principal) grants(permission string) bool {
+	for _, role := range p.Roles {
+		for _, granted := range rolePermissions[role] {
+			if granted == permission || granted == PermAll {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// authorize allows a route to principals granted the permission, for any
+// customer or only their own. Ownership is checked by the handler with
+// authorizeOwner once it knows whose resource it is.
+func authorize(permission string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal := currentPrincipal(c)
+		if principal == nil || principal.grants(permission) || principal.grants(permission+ownPermissionSuffix) {
+			return c.Next()
+		}
+
+		log.Warn().
+			Str("user.id", principal.ID).
+			Strs("user.roles", principal.Roles).
+			Str("url.path", c.Path()).
+			Msgf("Permission %s denied", permission)
+		return ErrPermissionDenied.WithDetails(fiber.Map{
+			"permission": permission,
+			"roles":      principal.Roles,
+		})
+	}
+}
+
+// authorizeOwner checks that the caller may use a permission on a customer's
+// resource: without authentication, with the permission for any customer, or
+// with the own-customer permission for their own customer ID
+func authorizeOwner(c *fiber.Ctx, permission, customerID string) error {
+	principal := currentPrincipal(c)
+	if principal == nil || principal.grants(permission) {
+		return nil
+	}
+	if principal.CustomerID != "" && principal.CustomerID == customerID && principal.grants(permission+ownPermissionSuffix) {
+		return nil
+	}
+
+	log.Warn().
+		Str("user.id", principal.ID).
+		Str("customer.id", customerID).
+		Msgf("Permission %s denied on another customer's resource", permission)
+	return ErrNotResourceOwner.WithDetails(fiber.Map{"permission": permission})
+}
+
+// ownedOnly reports whether the caller may only see its own customer's
+// resources, so listings must be filtered
+func ownedOnly(c *fiber.Ctx, permission string) bool {
+	principal := currentPrincipal(c)
+	return principal != nil && !principal.grants(permission)
+}
+
+// isOwnedBy reports whether the caller acts for a customer
+func isOwnedBy(c *fiber.Ctx, customerID string) bool {
+	principal := currentPrincipal(c)
+	return principal != nil && principal.CustomerID != "" && principal.CustomerID == customerID
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+var rbacSecret = []byte("rbac-secret")
+
+// rbacToken signs a token for a user with roles, acting for a customer if set
+func rbacToken(t *testing.T, subject, customerID string, roles ...string) string {
+	return signJWT(t, fiber.Map{"alg": "HS256"}, fiber.Map{
+		"sub": subject, "customer_id": customerID, "roles": roles,
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}, rbacSecret)
+}
+
+// doAuthJSON sends a JSON request with a bearer token
+func doAuthJSON(t *testing.T, app *fiber.App, method, path, token, payload string) (int, map[string]interface{}) {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := app.Test(req, -1)
+	assert.NoError(t, err)
+
+	var body map[string]interface{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	return resp.StatusCode, body
+}
+
+func errorCode(body map[string]interface{}) interface{} {
+	errBody, _ := body["error"].(map[string]interface{})
+	return errBody["code"]
+}
+
+// Test that customers can only act on their own carts and orders
+func TestRBACCustomerOwnership(t *testing.T) {
+	app := setupAuthApp(&AuthConfig{JWTSecret: rbacSecret})
+	alice := rbacToken(t, "user_alice", "cust_rbac_alice", "customer")
+	bob := rbacToken(t, "user_bob", "cust_rbac_bob", "customer")
+
+	status, body := doAuthJSON(t, app, http.MethodPost, "/v1/carts", bob, `{"customer_id": "cust_rbac_alice", "items": [{"item_id": "item001", "quantity": 1}]}`)
+	assert.Equal(t, 403, status)
+	assert.Equal(t, "NotResourceOwner", errorCode(body))
+
+	status, _ = doAuthJSON(t, app, http.MethodPost, "/v1/carts", alice, `{"customer_id": "cust_rbac_alice", "items": [{"item_id": "item001", "quantity": 1}, {"item_id": "item002", "quantity": 2}]}`)
+	assert.Equal(t, 200, status)
+	status, body = doAuthJSON(t, app, http.MethodPost, "/v1/orders", alice, `{
+		"order_id": "order_rbac_alice", "amount": 1100,
+		"billing_address": {"customer_id": "cust_rbac_alice", "name": "Alice", "email": "alice@example.com", "phone": "+15555555555", "country": "US"}
+	}`)
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "user_alice", body["order"].(map[string]interface{})["ProcessedBy"])
+
+	// Other customers can neither see nor cancel the order
+	status, body = doAuthJSON(t, app, http.MethodGet, "/v1/orders/order_rbac_alice", bob, "")
+	assert.Equal(t, 403, status)
+	assert.Equal(t, "NotResourceOwner", errorCode(body))
+	status, body = doAuthJSON(t, app, http.MethodPost, "/v1/orders/order_rbac_alice/cancel", bob, "")
+	assert.Equal(t, 403, status)
+	assert.Equal(t, "NotResourceOwner", errorCode(body))
+
+	// Order listings only show the customer's own orders
+	createPaidOrder(t, setupApp(), "cust_rbac_other", "order_rbac_other", nil)
+	status, body = doAuthJSON(t, app, http.MethodGet, "/v1/orders", alice, "")
+	assert.Equal(t, 200, status)
+	listed := body["orders"].(map[string]interface{})
+	assert.Contains(t, listed, "order_rbac_alice")
+	assert.NotContains(t, listed, "order_rbac_other")
+
+	status, body = doAuthJSON(t, app, http.MethodPost, "/v1/orders/order_rbac_alice/cancel", alice, "")
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "Order Cancelled", body["order"].(map[string]interface{})["Status"])
+}
+
+// Test that each workflow step is limited to the roles doing it
+func TestRBACWorkflowRoles(t *testing.T) {
+	app := setupAuthApp(&AuthConfig{JWTSecret: rbacSecret})
+	customer := rbacToken(t, "user_carol", "cust_rbac_carol", "customer")
+	warehouse := rbacToken(t, "user_warehouse", "", "warehouse")
+	finance := rbacToken(t, "user_finance", "", "finance")
+	createPaidOrder(t, setupApp(), "cust_rbac_carol", "order_rbac_carol", nil)
+
+	// Customers cannot move their own orders through the workflow
+	status, body := doAuthJSON(t, app, http.MethodPost, "/v1/orders/order_rbac_carol/route", customer, "")
+	assert.Equal(t, 403, status)
+	assert.Equal(t, "PermissionDenied", errorCode(body))
+	assert.Equal(t, PermOrdersRoute, body["error"].(map[string]interface{})["details"].(map[string]interface{})["permission"])
+
+	for _, action := range []string{"route", "fulfill"} {
+		status, _ = doAuthJSON(t, app, http.MethodPost, "/v1/orders/order_rbac_carol/"+action, warehouse, "")
+		assert.Equal(t, 200, status, action)
+	}
+
+	// Warehouse staff cannot capture, finance can
+	status, body = doAuthJSON(t, app, http.MethodPost, "/v1/orders/order_rbac_carol/capture", warehouse, "")
+	assert.Equal(t, 403, status)
+	assert.Equal(t, "PermissionDenied", errorCode(body))
+	status, body = doAuthJSON(t, app, http.MethodPost, "/v1/orders/order_rbac_carol/capture", finance, "")
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "user_finance", body["order"].(map[string]interface{})["ProcessedBy"])
+
+	// Legacy routes are protected the same way
+	status, _ = doAuthJSON(t, app, http.MethodPost, "/refund-payment", warehouse, `{"order_id": "order_rbac_carol"}`)
+	assert.Equal(t, 403, status)
+	status, _ = doAuthJSON(t, app, http.MethodPost, "/refund-payment", finance, `{"order_id": "order_rbac_carol"}`)
+	assert.Equal(t, 200, status)
+
+	// Tokens without roles may only use public routes
+	status, body = doAuthJSON(t, app, http.MethodGet, "/v1/products", rbacToken(t, "user_none", ""), "")
+	assert.Equal(t, 403, status)
+	assert.Equal(t, "PermissionDenied", errorCode(body))
+}
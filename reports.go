@@ -0,0 +1,69 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// Struct to represent revenue figures across the paid orders of a tenant
+type RevenueReport struct {
+	Currency        string  `json:"currency"`
+	Orders          int     `json:"orders"`
+	Exchanges       int     `json:"exchanges"`
+	GrossSales      float64 `json:"gross_sales"`
+	Discounts       float64 `json:"discounts"`        // coupon discounts given, already taken off gross sales
+	Tax             float64 `json:"tax"`              // tax collected on top of prices, part of gross sales
+	Shipping        float64 `json:"shipping"`         // shipping charged, part of gross sales
+	Refunds         float64 `json:"refunds"`          // money paid back to customers
+	ExchangeCredits float64 `json:"exchange_credits"` // returned value reused by replacement orders
+	NetRevenue      float64 `json:"net_revenue"`
+}
+
+// revenueReport totals paid orders that were not cancelled, as cancelling
+// releases the payment. Replacement orders are partly paid with the
+// value of returned items, which was already counted on the original order, so
+// that credit is subtracted once instead of counting the same sale twice.
+func revenueReport(orders map[string]*Order) RevenueReport {
+	var report RevenueReport
+	for _, order := range orders {
+		if !order.PaymentDone || order.Cancelled {
+			continue
+		}
+		report.Orders++
+		report.GrossSales += order.Totals.GrandTotal
+		report.Discounts += order.Totals.Discount
+		report.Tax += order.Totals.Tax
+		report.Shipping += order.Totals.Shipping
+
+		if order.ExchangeOf != "" {
+			report.Exchanges++
+			report.ExchangeCredits += order.ExchangeCredit
+		}
+
+		for _, refund := range order.Refunds {
+			if refund.Method == RefundToPayment {
+				report.Refunds += refund.Amount
+			}
+		}
+	}
+
+	report.GrossSales = roundAmount(report.GrossSales)
+	report.Discounts = roundAmount(report.Discounts)
+	report.Tax = roundAmount(report.Tax)
+	report.Shipping = roundAmount(report.Shipping)
+	report.Refunds = roundAmount(report.Refunds)
+	report.ExchangeCredits = roundAmount(report.ExchangeCredits)
+	report.NetRevenue = roundAmount(report.GrossSales - report.Refunds - report.ExchangeCredits)
+	return report
+}
+
+func RevenueReportHandler(c *fiber.Ctx) error {
+	tenant := tenantOf(c)
+	log.Info().Str("tenant.id", tenant.ID).Msg("Generating revenue report")
+
+	report := revenueReport(tenant.Orders)
+	report.Currency = tenant.Currency
+	return c.JSON(fiber.Map{
+		"message": "Revenue report generated successfully",
+		"report":  report,
+	})
+}
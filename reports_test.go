@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test that cancelled orders drop out of the revenue report
+func TestRevenueReportSkipsCancelledOrders(t *testing.T) {
+	setupTenants(t, []TenantConfig{{ID: "shop_report"}})
+	app := setupApp()
+
+	billingAddress := `{"customer_id": "cust_report", "name": "John Doe", "email": "john@example.com", "phone": "+15555555555", "country": "US"}`
+	for _, orderID := range []string{"order_report_kept", "order_report_cancelled"} {
+		status, _ := doTenantJSON(t, app, http.MethodPost, "/v1/carts", "shop_report", `{"customer_id": "cust_report", "items": [{"item_id": "item001", "quantity": 1}, {"item_id": "item002", "quantity": 2}]}`)
+		assert.Equal(t, 200, status)
+		status, _ = doTenantJSON(t, app, http.MethodPost, "/v1/orders", "shop_report", `{"order_id": "`+orderID+`", "amount": 1100, "billing_address": `+billingAddress+`}`)
+		assert.Equal(t, 200, status)
+	}
+	status, _ := doTenantJSON(t, app, http.MethodPost, "/v1/orders/order_report_cancelled/cancel", "shop_report", "")
+	assert.Equal(t, 200, status)
+
+	status, body := doTenantJSON(t, app, http.MethodGet, "/v1/reports/revenue", "shop_report", "")
+	assert.Equal(t, 200, status)
+	report := body["report"].(map[string]interface{})
+	assert.Equal(t, 1.0, report["orders"])
+	assert.Equal(t, 1100.0, report["gross_sales"])
+	assert.Equal(t, 1100.0, report["net_revenue"])
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// Return merchandise authorization (RMA) statuses
+const (
+	ReturnRequested = "requested"
+	ReturnApproved  = "approved"
+	ReturnRejected  = "rejected"
+	ReturnReceived  = "received"
+	ReturnInspected = "inspected"
+	ReturnRefunded  = "refunded"
+)
+
+// Struct to represent an order line being returned
+type ReturnItem struct {
+	ItemID   string `json:"item_id" validate:"required"`
+	Quantity int    `json:"quantity" validate:"gt=0"`
+	Reason   string `json:"reason"`
+	Restock  bool   `json:"restock"` // decided at inspection
+}
+
+// Struct to represent a return merchandise authorization
+type Return struct {
+	ReturnID     string               `json:"return_id"`
+	TenantID     string               `json:"tenant_id"`
+	OrderID      string               `json:"order_id"`
+	Status       string               `json:"status"`
+	Items        []ReturnItem         `json:"items"`
+	RejectReason string               `json:"reject_reason,omitempty"`
+	RefundAmount float64              `json:"refund_amount"`
+	RefundID     string               `json:"refund_id,omitempty"`
+	StatusTimes  map[string]time.Time `json:"status_times"`
+	// Replacement order when the items are exchanged rather than refunded
+	ExchangeOrderID string `json:"exchange_order_id,omitempty"`
+}
+
+// Request struct for requesting a return
+type ReturnRequest struct {
+	OrderID string       `json:"order_id"`
+	Items   []ReturnItem `json:"items" validate:"required,max=50"`
+}
+
+// Request struct for the inspection result of returned lines
+type InspectReturnRequest struct {
+	Items []struct {
+		ItemID  string `json:"item_id"`
+		Restock bool   `json:"restock"`
+	} `json:"items"` // lines not listed are restocked
+}
+
+// Request struct for rejecting a return
+type RejectReturnRequest struct {
+	Reason string `json:"reason"`
+}
+
+// In-memory return storage keyed by return ID
+var returns = make(map[string]*Return)
+
+// returnableQuantities returns how many units of each order item can still be returned
+func returnableQuantities(order *Order) map[string]int {
+	returnable := make(map[string]int)
+	for _, item := range order.Items {
+		returnable[item.ItemID] += item.Quantity
+	}
+	for _, rma := range order.Returns {
+		if rma.Status == ReturnRejected {
+			continue
+		}
+		for _, item := range rma.Items {
+			returnable[item.ItemID] -= item.Quantity
+		}
+	}
+	return returnable
+}
+
+// returnRefundAmount calculates the amount to refund for the returned lines as
+// their share of each line total, so discounts and tax are prorated the same
+// way they were charged
+func returnRefundAmount(order *Order, rma *Return) float64 {
+	lines := make(map[string]OrderItem)
+	for _, item := range order.Items {
+		lines[item.ItemID] = item
+	}
+
+	var amount float64
+	for _, item := range rma.Items {
+		line := lines[item.ItemID]
+		if line.Quantity > 0 {
+			amount += line.Total * float64(item.Quantity) / float64(line.Quantity)
+		}
+	}
+	return roundAmount(amount)
+}
+
+// checkOrderReturnable checks that items of the order can still be returned.
+// On failure it returns the API error.
+func checkOrderReturnable(order *Order) error {
+	// Only fulfilled orders can be returned; earlier the order can be cancelled instead
+	if !order.Fulfilled {
+		log.Warn().Msgf("Order ID %s has not been fulfilled and cannot be returned", order.ID)
+		return ErrOrderNotFulfilled.WithMessage("The order must be fulfilled before items can be returned.").WithTarget("order_id")
+	}
+
+	if order.Refunded {
+		log.Warn().Msgf("Order ID %s has already been refunded", order.ID)
+		return ErrPaymentAlreadyRefunded.WithTarget("order_id")
+	}
+	return nil
+}
+
+// checkReturnItems checks each requested line against what has been ordered and
+// not yet returned. On failure it returns the API error.
+func checkReturnItems(order *Order, requested []ReturnItem) ([]ReturnItem, error) {
+	returnable := returnableQuantities(order)
+	items := make([]ReturnItem, len(requested))
+	for i, item := range requested {
+		if item.Reason == "" {
+			log.Warn().Msgf("Return reason missing for item %s", item.ItemID)
+			return nil, ErrInvalidRequest.WithMessage("A reason is required for each returned item").WithTarget("items").WithDetails(fiber.Map{
+				"item_id": item.ItemID,
+			})
+		}
+
+		if item.Quantity <= 0 || item.Quantity > returnable[item.ItemID] {
+			log.Warn().Msgf("Invalid return quantity for item %s on Order ID %s", item.ItemID, order.ID)
+			return nil, ErrInvalidReturnItems.WithTarget("items").WithDetails(fiber.Map{
+				"item_id":    item.ItemID,
+				"returnable": returnable[item.ItemID],
+			})
+		}
+		returnable[item.ItemID] -= item.Quantity
+
+		items[i] = ReturnItem{ItemID: item.ItemID, Quantity: item.Quantity, Reason: item.Reason}
+	}
+	return items, nil
+}
+
+// newReturn creates and stores a return for the order in the given status
+func newReturn(order *Order, items []ReturnItem, status string) *Return {
+	rma := &Return{
+		ReturnID:    uuid.New().String(),
+		TenantID:    order.TenantID,
+		OrderID:     order.ID,
+		Items:       items,
+		StatusTimes: map[string]time.Time{},
+	}
+	setReturnStatus(rma, status)
+	rma.RefundAmount = returnRefundAmount(order, rma)
+
+	returns[rma.ReturnID] = rma
+	order.Returns = append(order.Returns, rma)
+	return rma
+}
+
+// setReturnStatus moves the return to status and records when it happened
+func setReturnStatus(rma *Return, status string) {
+	rma.Status = status
+	rma.StatusTimes[status] = time.Now().UTC()
+}
+
+// findReturnInStatus looks up the return in the path and checks it is in the expected status.
+// On failure it returns the API error.
+func findReturnInStatus(c *fiber.Ctx, expected string) (*Return, error) {
+	returnID := c.Params("id")
+
+	rma, exists := returns[returnID]
+	if !exists || rma.TenantID != tenantOf(c).ID {
+		log.Warn().Msgf("Return ID %s not found", returnID)
+		return nil, ErrReturnNotFound.WithTarget("id")
+	}
+
+	if rma.Status != expected {
+		log.Warn().Msgf("Return ID %s is %s, expected %s", returnID, rma.Status, expected)
+		return nil, ErrInvalidReturnStatus.WithMessage(fmt.Sprintf("The return is %s and must be %s for this step.", rma.Status, expected)).WithTarget("id").WithDetails(fiber.Map{
+			"current_status":  rma.Status,
+			"expected_status": expected,
+		})
+	}
+
+	return rma, nil
+}
+
+func CreateReturnHandler(c *fiber.Ctx) error {
+	var returnReq ReturnRequest
+
+	// Parse JSON input
+	if err := c.BodyParser(&returnReq); err != nil {
+		log.Warn().Msg("Invalid JSON input for /returns")
+		return ErrInvalidJSON
+	}
+
+	if returnReq.OrderID == "" {
+		log.Warn().Msg("Order ID is missing for return request")
+		return ErrMissingOrderID.WithMessage("Order ID is required to request a return.")
+	}
+
+	if err := checkRequest(returnReq); err != nil {
+		return err
+	}
+
+	// Check if order exists
+	order, exists := tenantOf(c).Orders[returnReq.OrderID]
+	if !exists {
+		log.Warn().Msgf("Order ID %s not found for return", returnReq.OrderID)
+		return ErrOrderNotFound.WithTarget("order_id")
+	}
+	if err := authorizeOwner(c, PermReturnsCreate, order.Customer.CustomerID); err != nil {
+		return err
+	}
+
+	if err := checkOrderReturnable(order); err != nil {
+		return err
+	}
+
+	items, err := checkReturnItems(order, returnReq.Items)
+	if err != nil {
+		return err
+	}
+
+	rma := newReturn(order, items, ReturnRequested)
+
+	log.Info().
+		Str("event.action", "request_return").
+		Str("order.id", order.ID).
+		Str("return.id", rma.ReturnID).
+		Msg("Return requested")
+
+	return c.Status(201).JSON(fiber.Map{
+		"message": "Return requested",
+		"return":  rma,
+	})
+}
+
+func GetReturnHandler(c *fiber.Ctx) error {
+	returnID := c.Params("id")
+
+	// Check if return exists
+	rma, exists := returns[returnID]
+	if !exists || rma.TenantID != tenantOf(c).ID {
+		log.Warn().Msgf("Return ID %s not found", returnID)
+		return ErrReturnNotFound.WithTarget("id")
+	}
+	if err := authorizeOwner(c, PermReturnsRead, tenantOf(c).Orders[rma.OrderID].Customer.CustomerID); err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Return retrieved successfully",
+		"return":  rma,
+	})
+}
+
+func ApproveReturnHandler(c *fiber.Ctx) error {
+	rma, err := findReturnInStatus(c, ReturnRequested)
+	if err != nil {
+		return err
+	}
+
+	setReturnStatus(rma, ReturnApproved)
+
+	log.Info().
+		Str("event.action", "approve_return").
+		Str("order.id", rma.OrderID).
+		Str("return.id", rma.ReturnID).
+		Msg("Return approved")
+
+	return c.JSON(fiber.Map{
+		"message": "Return approved",
+		"return":  rma,
+	})
+}
+
+func RejectReturnHandler(c *fiber.Ctx) error {
+	rma, err := findReturnInStatus(c, ReturnRequested)
+	if err != nil {
+		return err
+	}
+
+	// Parse JSON input
+	var rejectReq RejectReturnRequest
+	if err := parseBody(c, &rejectReq); err != nil {
+		log.Warn().Msg("Invalid JSON input for return rejection")
+		return ErrInvalidJSON
+	}
+
+	rma.RejectReason = rejectReq.Reason
+	setReturnStatus(rma, ReturnRejected)
+
+	log.Info().
+		Str("event.action", "reject_return").
+		Str("order.id", rma.OrderID).
+		Str("return.id", rma.ReturnID).
+		Msg("Return rejected")
+
+	return c.JSON(fiber.Map{
+		"message": "Return rejected",
+		"return":  rma,
+	})
+}
+
+func ReceiveReturnHandler(c *fiber.Ctx) error {
+	rma, err := findReturnInStatus(c, ReturnApproved)
+	if err != nil {
+		return err
+	}
+
+	setReturnStatus(rma, ReturnReceived)
+
+	log.Info().
+		Str("event.action", "receive_return").
+		Str("order.id", rma.OrderID).
+		Str("return.id", rma.ReturnID).
+		Msg("Returned items received")
+
+	return c.JSON(fiber.Map{
+		"message": "Returned items received",
+		"return":  rma,
+	})
+}
+
+func InspectReturnHandler(c *fiber.Ctx) error {
+	rma, err := findReturnInStatus(c, ReturnReceived)
+	if err != nil {
+		return err
+	}
+
+	var inspectReq InspectReturnRequest
+	if err := parseBody(c, &inspectReq); err != nil {
+		log.Warn().Msg("Invalid JSON input for return inspection")
+		return ErrInvalidJSON
+	}
+
+	// Restock every line unless the inspection says otherwise
+	restock := make(map[string]bool)
+	for _, item := range rma.Items {
+		restock[item.ItemID] = true
+	}
+	for _, result := range inspectReq.Items {
+		if _, returned := restock[result.ItemID]; !returned {
+			log.Warn().Msgf("Item %s is not part of Return ID %s", result.ItemID, rma.ReturnID)
+			return ErrInvalidReturnItems.WithMessage("Inspected items must be part of the return.").WithTarget("items").WithDetails(fiber.Map{
+				"item_id": result.ItemID,
+			})
+		}
+		restock[result.ItemID] = result.Restock
+	}
+	for i := range rma.Items {
+		rma.Items[i].Restock = restock[rma.Items[i].ItemID]
+	}
+
+	setReturnStatus(rma, ReturnInspected)
+
+	log.Info().
+		Str("event.action", "inspect_return").
+		Str("order.id", rma.OrderID).
+		Str("return.id", rma.ReturnID).
+		Msg("Returned items inspected")
+
+	return c.JSON(fiber.Map{
+		"message": "Returned items inspected",
+		"return":  rma,
+	})
+}
+
+func RefundReturnHandler(c *fiber.Ctx) error {
+	rma, err := findReturnInStatus(c, ReturnInspected)
+	if err != nil {
+		return err
+	}
+
+	order := tenantOf(c).Orders[rma.OrderID]
+
+	// Refund the returned lines through the regular refund path. Exchanges only
+	// refund what the replacement order did not use up, which may be nothing.
+	if rma.RefundAmount > 0 {
+		if !order.PaymentDone {
+			log.Warn().Msgf("Payment was not processed for Order ID %s", order.ID)
+			return ErrPaymentNotProcessed.WithTarget("order_id")
+		}
+
+		if order.Refunded {
+			log.Warn().Msgf("Payment has already been refunded for Order ID %s", order.ID)
+			return ErrPaymentAlreadyRefunded.WithTarget("order_id")
+		}
+
+		previous := order.Status
+		refund := recordRefund(order, rma.RefundAmount, RefundToPayment, "Return "+rma.ReturnID, rma.ReturnID)
+		rma.RefundID = refund.RefundID
+		recordRefundEvent(c, order, refund, previous)
+	}
+
+	// Put resellable items back on hand
+	for _, item := range rma.Items {
+		if product, exists := tenantOf(c).Products[item.ItemID]; exists && item.Restock {
+			product.Stock += item.Quantity
+		}
+	}
+
+	setReturnStatus(rma, ReturnRefunded)
+
+	log.Info().
+		Str("event.action", "refund_return").
+		Str("order.id", order.ID).
+		Str("return.id", rma.ReturnID).
+		Float64("amount", rma.RefundAmount).
+		Msg("Return refunded and restocked")
+
+	return c.JSON(fiber.Map{
+		"message": "Return refunded",
+		"return":  rma,
+		"order":   order,
+	})
+}
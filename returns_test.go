@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test the full return flow from request to partial refund and restock
+func TestReturnFlowRefundsAndRestocks(t *testing.T) {
+	app := setupApp()
+
+	createPaidOrder(t, app, "cust_return", "order_return", nil)
+
+	// Unfulfilled orders are cancelled, not returned
+	status, body := doJSON(t, app, http.MethodPost, "/returns", `{
+		"order_id": "order_return", "items": [{"item_id": "item002", "quantity": 1, "reason": "Too small"}]
+	}`)
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "OrderNotFulfilled", body["error"].(map[string]interface{})["code"])
+
+	status, _ = doJSON(t, app, http.MethodPost, "/route-order", `{"order_id": "order_return"}`)
+	assert.Equal(t, 200, status)
+	status, _ = doJSON(t, app, http.MethodPost, "/fulfill-order", `{"order_id": "order_return"}`)
+	assert.Equal(t, 200, status)
+
+	// Only two mice were ordered
+	status, body = doJSON(t, app, http.MethodPost, "/returns", `{
+		"order_id": "order_return", "items": [{"item_id": "item002", "quantity": 3, "reason": "Broken"}]
+	}`)
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "InvalidReturnItems", body["error"].(map[string]interface{})["code"])
+
+	status, body = doJSON(t, app, http.MethodPost, "/returns", `{
+		"order_id": "order_return", "items": [{"item_id": "item002", "quantity": 2, "reason": "Broken"}]
+	}`)
+	assert.Equal(t, 201, status)
+	rma := body["return"].(map[string]interface{})
+	returnID := rma["return_id"].(string)
+	assert.Equal(t, "requested", rma["status"])
+	assert.Equal(t, 100.0, rma["refund_amount"])
+
+	// Steps must happen in order
+	status, body = doJSON(t, app, http.MethodPost, "/returns/"+returnID+"/receive", "{}")
+	assert.Equal(t, 409, status)
+	assert.Equal(t, "InvalidReturnStatus", body["error"].(map[string]interface{})["code"])
+
+	stockBefore := defaultTenant.Products["item002"].Stock
+
+	status, _ = doJSON(t, app, http.MethodPost, "/returns/"+returnID+"/approve", "{}")
+	assert.Equal(t, 200, status)
+	status, _ = doJSON(t, app, http.MethodPost, "/returns/"+returnID+"/receive", "{}")
+	assert.Equal(t, 200, status)
+	status, _ = doJSON(t, app, http.MethodPost, "/returns/"+returnID+"/inspect", `{"items": [{"item_id": "item002", "restock": true}]}`)
+	assert.Equal(t, 200, status)
+
+	status, body = doJSON(t, app, http.MethodPost, "/returns/"+returnID+"/refund", "{}")
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "refunded", body["return"].(map[string]interface{})["status"])
+
+	order := body["order"].(map[string]interface{})
+	assert.Equal(t, "Partially Refunded", order["Status"])
+	assert.Equal(t, 100.0, order["RefundedAmount"])
+	assert.Equal(t, false, order["Refunded"])
+	assert.Equal(t, stockBefore+2, defaultTenant.Products["item002"].Stock)
+
+	// A full refund afterwards only refunds what is left
+	status, body = doJSON(t, app, http.MethodPost, "/refund-payment", `{"order_id": "order_return"}`)
+	assert.Equal(t, 200, status)
+	order = body["order"].(map[string]interface{})
+	assert.Equal(t, 1100.0, order["RefundedAmount"])
+	assert.Equal(t, 1000.0, order["Refunds"].([]interface{})[1].(map[string]interface{})["amount"])
+}
+
+// Test that rejected returns free up the quantity and damaged items are not restocked
+func TestRejectedReturnAndDamagedItems(t *testing.T) {
+	app := setupApp()
+
+	createPaidOrder(t, app, "cust_return_reject", "order_return_reject", nil)
+	doJSON(t, app, http.MethodPost, "/route-order", `{"order_id": "order_return_reject"}`)
+	doJSON(t, app, http.MethodPost, "/fulfill-order", `{"order_id": "order_return_reject"}`)
+
+	status, body := doJSON(t, app, http.MethodPost, "/returns", `{
+		"order_id": "order_return_reject", "items": [{"item_id": "item001", "quantity": 1, "reason": "Changed mind"}]
+	}`)
+	assert.Equal(t, 201, status)
+	returnID := body["return"].(map[string]interface{})["return_id"].(string)
+
+	status, body = doJSON(t, app, http.MethodPost, "/returns/"+returnID+"/reject", `{"reason": "Outside return window"}`)
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "rejected", body["return"].(map[string]interface{})["status"])
+
+	status, body = doJSON(t, app, http.MethodPost, "/returns", `{
+		"order_id": "order_return_reject", "items": [{"item_id": "item001", "quantity": 1, "reason": "Dead on arrival"}]
+	}`)
+	assert.Equal(t, 201, status)
+	returnID = body["return"].(map[string]interface{})["return_id"].(string)
+
+	stockBefore := defaultTenant.Products["item001"].Stock
+	doJSON(t, app, http.MethodPost, "/returns/"+returnID+"/approve", "{}")
+	doJSON(t, app, http.MethodPost, "/returns/"+returnID+"/receive", "{}")
+	doJSON(t, app, http.MethodPost, "/returns/"+returnID+"/inspect", `{"items": [{"item_id": "item001", "restock": false}]}`)
+	status, _ = doJSON(t, app, http.MethodPost, "/returns/"+returnID+"/refund", "{}")
+	assert.Equal(t, 200, status)
+	assert.Equal(t, stockBefore, defaultTenant.Products["item001"].Stock)
+	assert.Equal(t, 1000.0, defaultTenant.Orders["order_return_reject"].RefundedAmount)
+
+	// The /v1 steps take no body: rejections need no reason and inspections
+	// restock every item by default
+	status, body = doJSON(t, app, http.MethodPost, "/v1/returns", `{
+		"order_id": "order_return_reject", "items": [{"item_id": "item002", "quantity": 1, "reason": "Changed mind"}]
+	}`)
+	assert.Equal(t, 201, status)
+	returnID = body["return"].(map[string]interface{})["return_id"].(string)
+	resp, body := authRequest(t, app, http.MethodPost, "/v1/returns/"+returnID+"/reject", nil)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "rejected", body["return"].(map[string]interface{})["status"])
+
+	status, body = doJSON(t, app, http.MethodPost, "/v1/returns", `{
+		"order_id": "order_return_reject", "items": [{"item_id": "item002", "quantity": 1, "reason": "Too small"}]
+	}`)
+	assert.Equal(t, 201, status)
+	returnID = body["return"].(map[string]interface{})["return_id"].(string)
+	authRequest(t, app, http.MethodPost, "/v1/returns/"+returnID+"/approve", nil)
+	authRequest(t, app, http.MethodPost, "/v1/returns/"+returnID+"/receive", nil)
+	resp, body = authRequest(t, app, http.MethodPost, "/v1/returns/"+returnID+"/inspect", nil)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, true, body["return"].(map[string]interface{})["items"].([]interface{})[0].(map[string]interface{})["restock"])
+}
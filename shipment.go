@@ -0,0 +1,330 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// Shipment statuses reported by carriers
+const (
+	ShipmentShipped        = "shipped"
+	ShipmentInTransit      = "in_transit"
+	ShipmentOutForDelivery = "out_for_delivery"
+	ShipmentDelivered      = "delivered"
+	ShipmentException      = "exception"
+)
+
+// Allowed carrier status transitions for a shipment
+var shipmentTransitions = map[string][]string{
+	ShipmentShipped:        {ShipmentInTransit, ShipmentOutForDelivery, ShipmentDelivered, ShipmentException},
+	ShipmentInTransit:      {ShipmentInTransit, ShipmentOutForDelivery, ShipmentDelivered, ShipmentException},
+	ShipmentOutForDelivery: {ShipmentInTransit, ShipmentDelivered, ShipmentException},
+	ShipmentException:      {ShipmentInTransit, ShipmentOutForDelivery, ShipmentDelivered, ShipmentException},
+	ShipmentDelivered:      {},
+}
+
+// Order statuses that are still derived from the order's shipments
+var shippingPhaseStatuses = map[string]bool{
+	"Items Packed":          true,
+	"Order Shipped":         true,
+	"Fulfillment Completed": true,
+	"Partially Shipped":     true,
+	"Shipped":               true,
+	"Delivered":             true,
+}
+
+// Struct to represent an order item contained in a shipment
+type ShipmentItem struct {
+	ItemID   string `json:"item_id" validate:"required"`
+	Quantity int    `json:"quantity" validate:"gt=0"`
+}
+
+// Struct to represent a carrier status update for a shipment
+type ShipmentEvent struct {
+	Status      string    `json:"status"`
+	Description string    `json:"description"`
+	OccurredAt  time.Time `json:"occurred_at"`
+}
+
+// Struct to represent a package handed to a carrier
+type Shipment struct {
+	ShipmentID     string          `json:"shipment_id"`
+	TenantID       string          `json:"tenant_id"`
+	OrderID        string          `json:"order_id"`
+	Carrier        string          `json:"carrier"`
+	ServiceLevel   string          `json:"service_level"`
+	TrackingNumber string          `json:"tracking_number"`
+	Items          []ShipmentItem  `json:"items"`
+	Status         string          `json:"status"`
+	ShippedAt      time.Time       `json:"shipped_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+	Events         []ShipmentEvent `json:"events"`
+}
+
+// Request struct for creating a shipment
+type CreateShipmentRequest struct {
+	OrderID        string         `json:"order_id"`
+	Carrier        string         `json:"carrier" validate:"required"`
+	ServiceLevel   string         `json:"service_level"`
+	TrackingNumber string         `json:"tracking_number" validate:"required"`
+	Items          []ShipmentItem `json:"items"` // defaults to all unshipped items
+}
+
+// Request struct for a carrier status update
+type ShipmentStatusRequest struct {
+	Status      string     `json:"status"`
+	Description string     `json:"description"`
+	OccurredAt  *time.Time `json:"occurred_at"` // defaults to now
+}
+
+// In-memory shipment storage keyed by shipment ID
+var shipments = make(map[string]*Shipment)
+
+// unshippedQuantities returns the quantity of each order item not yet in a shipment
+func unshippedQuantities(order *Order) map[string]int {
+	remaining := make(map[string]int)
+	for _, item := range order.Items {
+		remaining[item.ItemID] += item.Quantity
+	}
+	for _, shipment := range order.Shipments {
+		for _, item := range shipment.Items {
+			remaining[item.ItemID] -= item.Quantity
+		}
+	}
+	return remaining
+}
+
+// allItemsShipped reports whether every order item is contained in a shipment
+func allItemsShipped(order *Order) bool {
+	for _, qty := range unshippedQuantities(order) {
+		if qty > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// shipmentOrderStatus derives the order status from its shipments
+func shipmentOrderStatus(order *Order) string {
+	if !allItemsShipped(order) {
+		return "Partially Shipped"
+	}
+	for _, shipment := range order.Shipments {
+		if shipment.Status != ShipmentDelivered {
+			return "Shipped"
+		}
+	}
+	return "Delivered"
+}
+
+// refreshShipmentStatus updates the order status from its shipments while the
+// order is still in the shipping phase. Later statuses (ready for pickup,
+// payment captured, refunded...) are left alone.
+func refreshShipmentStatus(order *Order) {
+	if len(order.Shipments) == 0 || !shippingPhaseStatuses[order.Status] {
+		return
+	}
+	order.Status = shipmentOrderStatus(order)
+}
+
+// applyShipmentStatus records a carrier status update on the shipment and its
+// order, and the change of the order status
+func applyShipmentStatus(c *fiber.Ctx, shipment *Shipment, status, description string, occurredAt time.Time) error {
+	allowed := false
+	for _, next := range shipmentTransitions[shipment.Status] {
+		if next == status {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("shipment %s cannot move from %s to %s", shipment.ShipmentID, shipment.Status, status)
+	}
+
+	shipment.Status = status
+	shipment.Events = append(shipment.Events, ShipmentEvent{
+		Status:      status,
+		Description: description,
+		OccurredAt:  occurredAt,
+	})
+	if status == ShipmentDelivered {
+		shipment.DeliveredAt = &occurredAt
+	}
+
+	if order, exists := findTenantOrder(shipment.TenantID, shipment.OrderID); exists {
+		previous := order.Status
+		refreshShipmentStatus(order)
+		recordStatusChange(c, order, previous)
+	}
+	return nil
+}
+
+func CreateShipmentHandler(c *fiber.Ctx) error {
+	var shipmentReq CreateShipmentRequest
+
+	// Parse JSON input
+	if err := c.BodyParser(&shipmentReq); err != nil {
+		log.Warn().Msg("Invalid JSON input for /shipments")
+		return ErrInvalidJSON
+	}
+
+	if shipmentReq.OrderID == "" {
+		log.Warn().Msg("Order ID is missing for shipment creation")
+		return ErrMissingOrderID.WithMessage("Order ID is required to create a shipment.")
+	}
+
+	if err := checkRequest(shipmentReq); err != nil {
+		return err
+	}
+
+	// Check if order exists
+	order, exists := tenantOf(c).Orders[shipmentReq.OrderID]
+	if !exists {
+		log.Warn().Msgf("Order ID %s not found for shipment creation", shipmentReq.OrderID)
+		return ErrOrderNotFound.WithTarget("order_id")
+	}
+
+	// Only packed delivery and locker orders can be handed to a carrier
+	if order.Fulfillment.Type == FulfillmentPickup {
+		log.Warn().Msgf("Order ID %s is a pickup order and cannot be shipped", order.ID)
+		return ErrShipmentNotAllowed.WithTarget("order_id")
+	}
+
+	if order.Fulfillment.Step != StepPacked {
+		log.Warn().Msgf("Order ID %s is not packed", order.ID)
+		return ErrOrderNotPacked.WithTarget("order_id")
+	}
+
+	// Default to shipping everything that is left, otherwise check the requested items
+	remaining := unshippedQuantities(order)
+	items := shipmentReq.Items
+	if len(items) == 0 {
+		for _, item := range order.Items {
+			if remaining[item.ItemID] > 0 {
+				items = append(items, ShipmentItem{ItemID: item.ItemID, Quantity: remaining[item.ItemID]})
+				remaining[item.ItemID] = 0
+			}
+		}
+	} else {
+		for _, item := range items {
+			if item.Quantity <= 0 || item.Quantity > remaining[item.ItemID] {
+				log.Warn().Msgf("Invalid shipment quantity for item %s on Order ID %s", item.ItemID, order.ID)
+				return ErrInvalidShipmentItems.WithTarget("items").WithDetails(fiber.Map{
+					"item_id":   item.ItemID,
+					"remaining": remaining[item.ItemID],
+				})
+			}
+			remaining[item.ItemID] -= item.Quantity
+		}
+	}
+
+	now := time.Now().UTC()
+	shipment := &Shipment{
+		ShipmentID:     uuid.New().String(),
+		TenantID:       order.TenantID,
+		OrderID:        order.ID,
+		Carrier:        shipmentReq.Carrier,
+		ServiceLevel:   shipmentReq.ServiceLevel,
+		TrackingNumber: shipmentReq.TrackingNumber,
+		Items:          items,
+		Status:         ShipmentShipped,
+		ShippedAt:      now,
+		Events: []ShipmentEvent{
+			{Status: ShipmentShipped, Description: "Handed to carrier", OccurredAt: now},
+		},
+	}
+	shipments[shipment.ShipmentID] = shipment
+	order.Shipments = append(order.Shipments, shipment)
+
+	// Once every item is on its way the fulfillment ship step is complete
+	previous := order.Status
+	shipped := allItemsShipped(order)
+	if shipped {
+		advanceFulfillment(order, StepShipped)
+	}
+	refreshShipmentStatus(order)
+	if shipped {
+		recordFulfillmentEvents(c, order, StepShipped)
+	}
+	recordStatusChange(c, order, previous)
+
+	log.Info().
+		Str("event.action", "create_shipment").
+		Str("order.id", order.ID).
+		Str("shipment.id", shipment.ShipmentID).
+		Str("shipment.carrier", shipment.Carrier).
+		Msg("Shipment created successfully")
+
+	return c.Status(201).JSON(fiber.Map{
+		"message":  "Shipment created",
+		"shipment": shipment,
+		"order":    order,
+	})
+}
+
+func UpdateShipmentStatusHandler(c *fiber.Ctx) error {
+	shipmentID := c.Params("id")
+
+	// Check if shipment exists
+	shipment, exists := shipments[shipmentID]
+	if !exists || shipment.TenantID != tenantOf(c).ID {
+		log.Warn().Msgf("Shipment ID %s not found", shipmentID)
+		return ErrShipmentNotFound.WithTarget("id")
+	}
+
+	var statusReq ShipmentStatusRequest
+	if err := c.BodyParser(&statusReq); err != nil {
+		log.Warn().Msg("Invalid JSON input for shipment status update")
+		return ErrInvalidJSON
+	}
+
+	if _, known := shipmentTransitions[statusReq.Status]; !known {
+		log.Warn().Msgf("Unknown shipment status %s", statusReq.Status)
+		return ErrInvalidRequest.WithMessage("Status must be one of shipped, in_transit, out_for_delivery, delivered or exception").WithTarget("status")
+	}
+
+	occurredAt := time.Now().UTC()
+	if statusReq.OccurredAt != nil {
+		occurredAt = statusReq.OccurredAt.UTC()
+	}
+
+	if err := applyShipmentStatus(c, shipment, statusReq.Status, statusReq.Description, occurredAt); err != nil {
+		log.Warn().Err(err).Msg("Invalid shipment status transition")
+		return ErrInvalidShipmentTransition.WithMessage(err.Error()).WithTarget("status")
+	}
+
+	log.Info().
+		Str("event.action", "update_shipment_status").
+		Str("order.id", shipment.OrderID).
+		Str("shipment.id", shipment.ShipmentID).
+		Str("shipment.status", shipment.Status).
+		Msg("Shipment status updated")
+
+	return c.JSON(fiber.Map{
+		"message":  "Shipment status updated",
+		"shipment": shipment,
+		"order":    tenantOf(c).Orders[shipment.OrderID],
+	})
+}
+
+func GetShipmentHandler(c *fiber.Ctx) error {
+	shipmentID := c.Params("id")
+
+	// Check if shipment exists
+	shipment, exists := shipments[shipmentID]
+	if !exists || shipment.TenantID != tenantOf(c).ID {
+		log.Warn().Msgf("Shipment ID %s not found", shipmentID)
+		return ErrShipmentNotFound.WithTarget("id")
+	}
+	if err := authorizeOwner(c, PermOrdersRead, tenantOf(c).Orders[shipment.OrderID].Customer.CustomerID); err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{
+		"message":  "Shipment retrieved successfully",
+		"shipment": shipment,
+	})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper function to route, pick and pack an order so it is ready to ship
+func packOrder(t *testing.T, app *fiber.App, orderID string) {
+	t.Helper()
+
+	orderPayload := `{"order_id": "` + orderID + `"}`
+	for _, path := range []string{"/route-order", "/fulfillment/pick", "/fulfillment/pack"} {
+		status, _ := doJSON(t, app, http.MethodPost, path, orderPayload)
+		assert.Equal(t, 200, status, path)
+	}
+}
+
+// Test that the order status is derived from its shipments
+func TestShipmentsDriveOrderStatus(t *testing.T) {
+	app := setupApp()
+
+	createPaidOrder(t, app, "cust_shipments", "order_shipments", nil)
+
+	// Shipping before packing is rejected
+	status, body := doJSON(t, app, http.MethodPost, "/shipments", `{
+		"order_id": "order_shipments", "carrier": "jne", "tracking_number": "JNE001"
+	}`)
+	assert.Equal(t, 409, status)
+	assert.Equal(t, "OrderNotPacked", body["error"].(map[string]interface{})["code"])
+
+	packOrder(t, app, "order_shipments")
+
+	// First package contains only the laptop
+	status, body = doJSON(t, app, http.MethodPost, "/shipments", `{
+		"order_id": "order_shipments", "carrier": "jne", "service_level": "express",
+		"tracking_number": "JNE001", "items": [{"item_id": "item001", "quantity": 1}]
+	}`)
+	assert.Equal(t, 201, status)
+	assert.Equal(t, "Partially Shipped", body["order"].(map[string]interface{})["Status"])
+	assert.Equal(t, false, body["order"].(map[string]interface{})["Fulfilled"])
+	firstShipmentID := body["shipment"].(map[string]interface{})["shipment_id"].(string)
+
+	// More mice than ordered are rejected
+	status, body = doJSON(t, app, http.MethodPost, "/shipments", `{
+		"order_id": "order_shipments", "carrier": "jne", "tracking_number": "JNE002",
+		"items": [{"item_id": "item002", "quantity": 3}]
+	}`)
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "InvalidShipmentItems", body["error"].(map[string]interface{})["code"])
+
+	// Second package defaults to the remaining items
+	status, body = doJSON(t, app, http.MethodPost, "/shipments", `{
+		"order_id": "order_shipments", "carrier": "sicepat", "tracking_number": "SCP002"
+	}`)
+	assert.Equal(t, 201, status)
+	order := body["order"].(map[string]interface{})
+	assert.Equal(t, "Shipped", order["Status"])
+	assert.Equal(t, true, order["Fulfilled"])
+	secondShipmentID := body["shipment"].(map[string]interface{})["shipment_id"].(string)
+
+	status, body = doJSON(t, app, http.MethodPost, "/shipments/"+firstShipmentID+"/status", `{"status": "delivered"}`)
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "Shipped", body["order"].(map[string]interface{})["Status"])
+	assert.NotEmpty(t, body["shipment"].(map[string]interface{})["delivered_at"])
+
+	// Delivered shipments cannot change status again
+	status, body = doJSON(t, app, http.MethodPost, "/shipments/"+firstShipmentID+"/status", `{"status": "in_transit"}`)
+	assert.Equal(t, 409, status)
+	assert.Equal(t, "InvalidShipmentTransition", body["error"].(map[string]interface{})["code"])
+
+	status, _ = doJSON(t, app, http.MethodPost, "/shipments/"+secondShipmentID+"/status", `{"status": "in_transit"}`)
+	assert.Equal(t, 200, status)
+	status, body = doJSON(t, app, http.MethodPost, "/shipments/"+secondShipmentID+"/status", `{"status": "delivered"}`)
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "Delivered", body["order"].(map[string]interface{})["Status"])
+}
+
+// Test that pickup orders cannot be shipped
+func TestShipmentRejectsPickupOrder(t *testing.T) {
+	app := setupApp()
+
+	createPaidOrder(t, app, "cust_shipment_pickup", "order_shipment_pickup", map[string]interface{}{
+		"fulfillment_type": "pickup",
+		"pickup_location":  "STORE-JKT-01",
+	})
+	packOrder(t, app, "order_shipment_pickup")
+
+	status, body := doJSON(t, app, http.MethodPost, "/shipments", `{
+		"order_id": "order_shipment_pickup", "carrier": "jne", "tracking_number": "JNE003"
+	}`)
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "ShipmentNotAllowed", body["error"].(map[string]interface{})["code"])
+}
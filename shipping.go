@@ -0,0 +1,119 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// Shipping methods offered at checkout
+const (
+	ShippingStandard = "standard"
+	ShippingExpress  = "express"
+	ShippingPickup   = "pickup" // collected in store, only for pickup orders
+)
+
+// Struct to represent a shipping rate for a method, destination and weight band
+type ShippingRate struct {
+	Method           string  `json:"method"`
+	Country          string  `json:"country"`            // "*" matches every country
+	MaxWeight        float64 `json:"max_weight"`         // kg, 0 means no limit
+	Price            float64 `json:"price"`              // price for parcels up to MaxWeight
+	FreeOverSubtotal float64 `json:"free_over_subtotal"` // item subtotal from which shipping is free, 0 means never
+}
+
+// Struct to represent the price of a shipping method for a cart
+type ShippingQuote struct {
+	Method string  `json:"method"`
+	Price  float64 `json:"price"`
+}
+
+// Default shipping rates for the demo
+var shippingRates = []ShippingRate{
+	{Method: ShippingStandard, Country: "ID", MaxWeight: 1, Price: 10, FreeOverSubtotal: 500},
+	{Method: ShippingStandard, Country: "ID", MaxWeight: 5, Price: 20, FreeOverSubtotal: 500},
+	{Method: ShippingStandard, Country: "ID", Price: 40, FreeOverSubtotal: 500},
+	{Method: ShippingExpress, Country: "ID", MaxWeight: 1, Price: 25},
+	{Method: ShippingExpress, Country: "ID", MaxWeight: 5, Price: 45},
+	{Method: ShippingExpress, Country: "ID", Price: 80},
+	{Method: ShippingStandard, Country: "*", MaxWeight: 1, Price: 15, FreeOverSubtotal: 1000},
+	{Method: ShippingStandard, Country: "*", MaxWeight: 5, Price: 30, FreeOverSubtotal: 1000},
+	{Method: ShippingStandard, Country: "*", Price: 60, FreeOverSubtotal: 1000},
+	{Method: ShippingExpress, Country: "*", MaxWeight: 5, Price: 75},
+	{Method: ShippingExpress, Country: "*", Price: 150},
+	{Method: ShippingPickup, Country: "*"},
+}
+
+// defaultShippingMethod returns the shipping method used when none is selected
+func defaultShippingMethod(fulfillmentType string) string {
+	if fulfillmentType == FulfillmentPickup {
+		return ShippingPickup
+	}
+	return ShippingStandard
+}
+
+// isShippingMethodAllowed reports whether the shipping method fits the fulfillment type
+func isShippingMethodAllowed(method, fulfillmentType string) bool {
+	return (method == ShippingPickup) == (fulfillmentType == FulfillmentPickup)
+}
+
+// cartWeight returns the total weight of the items in kilograms
+func cartWeight(items []Item) float64 {
+	var weight float64
+	for _, item := range items {
+		weight += float64(item.Quantity) * item.Weight
+	}
+	return weight
+}
+
+// shippingCost returns the price of a shipping method for a destination, parcel
+// weight and item subtotal. Country specific rates win over "*" rates and the
+// lightest weight band that fits is used. It returns false when the method is
+// not available for the destination or weight.
+func shippingCost(method, country string, weight, subtotal float64) (float64, bool) {
+	var candidates []ShippingRate
+	for _, rate := range shippingRates {
+		if rate.Method == method && strings.EqualFold(rate.Country, country) {
+			candidates = append(candidates, rate)
+		}
+	}
+	if len(candidates) == 0 {
+		for _, rate := range shippingRates {
+			if rate.Method == method && rate.Country == "*" {
+				candidates = append(candidates, rate)
+			}
+		}
+	}
+
+	// Check the bands from lightest to the unlimited one
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].MaxWeight == 0 || candidates[j].MaxWeight == 0 {
+			return candidates[j].MaxWeight == 0 && candidates[i].MaxWeight != 0
+		}
+		return candidates[i].MaxWeight < candidates[j].MaxWeight
+	})
+
+	for _, rate := range candidates {
+		if rate.MaxWeight != 0 && weight > rate.MaxWeight {
+			continue
+		}
+		if rate.FreeOverSubtotal > 0 && subtotal >= rate.FreeOverSubtotal {
+			return 0, true
+		}
+		return rate.Price, true
+	}
+	return 0, false
+}
+
+// shippingQuotes returns the shipping methods available for the fulfillment type and destination
+func shippingQuotes(fulfillmentType, country string, weight, subtotal float64) []ShippingQuote {
+	quotes := []ShippingQuote{}
+	for _, method := range []string{ShippingStandard, ShippingExpress, ShippingPickup} {
+		if !isShippingMethodAllowed(method, fulfillmentType) {
+			continue
+		}
+		if price, ok := shippingCost(method, country, weight, subtotal); ok {
+			quotes = append(quotes, ShippingQuote{Method: method, Price: price})
+		}
+	}
+	return quotes
+}
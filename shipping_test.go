@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test rate table lookup by country, weight band and free shipping threshold
+func TestShippingCost(t *testing.T) {
+	// Lightest weight band that fits
+	price, ok := shippingCost(ShippingStandard, "ID", 0.5, 100)
+	assert.True(t, ok)
+	assert.Equal(t, 10.0, price)
+
+	price, _ = shippingCost(ShippingStandard, "ID", 3, 100)
+	assert.Equal(t, 20.0, price)
+
+	price, _ = shippingCost(ShippingStandard, "ID", 12, 100)
+	assert.Equal(t, 40.0, price)
+
+	// Free over the subtotal threshold, but express never is
+	price, _ = shippingCost(ShippingStandard, "ID", 3, 500)
+	assert.Equal(t, 0.0, price)
+	price, _ = shippingCost(ShippingExpress, "ID", 3, 500)
+	assert.Equal(t, 45.0, price)
+
+	// Countries without their own rates use the "*" rates
+	price, ok = shippingCost(ShippingStandard, "SG", 3, 100)
+	assert.True(t, ok)
+	assert.Equal(t, 30.0, price)
+
+	price, ok = shippingCost(ShippingPickup, "SG", 30, 0)
+	assert.True(t, ok)
+	assert.Equal(t, 0.0, price)
+
+	_, ok = shippingCost("drone", "ID", 1, 100)
+	assert.False(t, ok)
+}
+
+// Test that the selected shipping method is charged as part of the payment amount
+func TestProcessPaymentIncludesShipping(t *testing.T) {
+	app := setupApp()
+
+	status, _ := doJSON(t, app, http.MethodPost, "/create-cart", `{
+		"customer_id": "cust_shipping",
+		"items": [{"item_id": "item001", "quantity": 1}, {"item_id": "item002", "quantity": 2}]
+	}`)
+	assert.Equal(t, 200, status)
+
+	billingAddress := map[string]interface{}{
+		"customer_id": "cust_shipping",
+		"name":        "Budi",
+		"email":       "budi@example.com",
+		"phone":       "+628123456789",
+		"country":     "ID",
+	}
+
+	quotePayload, _ := json.Marshal(map[string]interface{}{
+		"billing_address": billingAddress,
+		"shipping_method": "express",
+	})
+	status, body := doJSON(t, app, http.MethodPost, "/quote-cart", string(quotePayload))
+	assert.Equal(t, 200, status)
+	totals := body["totals"].(map[string]interface{})
+	assert.Equal(t, 45.0, totals["shipping"])
+	assert.Equal(t, 1266.0, totals["grand_total"])
+	assert.Len(t, body["shipping_options"], 2)
+
+	// Pickup shipping only goes with pickup orders
+	paymentPayload := map[string]interface{}{
+		"order_id":        "order_shipping",
+		"amount":          1221,
+		"billing_address": billingAddress,
+		"shipping_method": "pickup",
+	}
+	paymentPayloadBytes, _ := json.Marshal(paymentPayload)
+	status, _ = doJSON(t, app, http.MethodPost, "/process-payment", string(paymentPayloadBytes))
+	assert.Equal(t, 400, status)
+
+	paymentPayload["shipping_method"] = "express"
+	paymentPayloadBytes, _ = json.Marshal(paymentPayload)
+	status, body = doJSON(t, app, http.MethodPost, "/process-payment", string(paymentPayloadBytes))
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "AmountMismatch", body["error"].(map[string]interface{})["code"])
+
+	paymentPayload["amount"] = 1266
+	paymentPayloadBytes, _ = json.Marshal(paymentPayload)
+	status, body = doJSON(t, app, http.MethodPost, "/process-payment", string(paymentPayloadBytes))
+	assert.Equal(t, 200, status)
+
+	order := body["order"].(map[string]interface{})
+	assert.Equal(t, "express", order["ShippingMethod"])
+	assert.Equal(t, 45.0, order["Totals"].(map[string]interface{})["shipping"])
+	assert.Equal(t, 1266.0, order["Amount"])
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	lastEventIDHeader    = "Last-Event-ID"
+	eventStreamRetry     = 3 * time.Second  // reconnect delay suggested to clients
+	eventStreamHeartbeat = 15 * time.Second // keeps proxies from closing idle streams
+)
+
+// Closed on shutdown to end the open streams, which would otherwise keep the
+// server from shutting down
+var (
+	eventStreamsDone      = make(chan struct{})
+	closeEventStreamsOnce sync.Once
+)
+
+func closeEventStreams() {
+	closeEventStreamsOnce.Do(func() { close(eventStreamsDone) })
+}
+
+// lastEventID reads where a client resumes: the Last-Event-ID header sent by
+// EventSource when it reconnects, or the last_event_id query for clients that
+// cannot set headers
+func lastEventID(c *fiber.Ctx) (uint64, error) {
+	value, target := c.Get(lastEventIDHeader), lastEventIDHeader
+	if value == "" {
+		value, target = c.Query("last_event_id"), "last_event_id"
+	}
+	if value == "" {
+		return 0, nil
+	}
+
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidRequest.WithMessage("The last event ID must be the id of a streamed event.").WithTarget(target)
+	}
+	return id, nil
+}
+
+// streamEvents answers with a Server-Sent Events stream of the committed
+// events matching a filter: the ones after the last event ID first, then new
+// ones as they are committed. The stream runs after the handler has
+// returned, so the filter must not use the request.
+//
+// Clients resuming after events that were trimmed get an EventsExpired error
+// instead, and a stream falling that far behind ends with a reset message, so
+// that they never silently miss events. Customers do not see which staff
+// member changed their orders.
+func streamEvents(c *fiber.Ctx, after uint64, match func(Event) bool) error {
+	if !outbox.Keeps(after) {
+		return ErrEventsExpired.WithTarget(lastEventIDHeader)
+	}
+	hideActor := ownedOnly(c, PermOrdersRead)
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no") // no buffering by nginx
+
+	path := strings.Clone(c.Path())
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		committed, stop := outbox.Watch()
+		defer stop()
+		heartbeat := time.NewTicker(eventStreamHeartbeat)
+		defer heartbeat.Stop()
+
+		fmt.Fprintf(w, "retry: %d\n\n", eventStreamRetry.Milliseconds())
+		for {
+			events, kept := outbox.EventsSince(after, match)
+			if !kept {
+				log.Warn().Str("url.path", path).Uint64("event.sequence", after).Msg("Event stream fell behind the outbox retention")
+				fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+				w.Flush()
+				return
+			}
+			for _, event := range events {
+				if hideActor {
+					event.Actor = ""
+				}
+				if err := writeStreamEvent(w, event); err != nil {
+					log.Error().Err(err).Str("event.id", event.ID).Msg("Failed to encode streamed event")
+					return
+				}
+				after = event.Sequence
+			}
+			if err := w.Flush(); err != nil {
+				log.Info().Str("url.path", path).Uint64("event.sequence", after).Msg("Event stream closed by the client")
+				return
+			}
+
+			select {
+			case <-committed:
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+			case <-eventStreamsDone:
+				return
+			}
+		}
+	})
+	return nil
+}
+
+// writeStreamEvent writes an event as an SSE message, with its sequence
+// number as the ID clients resume from
+func writeStreamEvent(w *bufio.Writer, event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Sequence, event.Type, data)
+	return err
+}
+
+// OrderEventsHandler streams the status changes of an order, starting with
+// the ones it already went through
+func OrderEventsHandler(c *fiber.Ctx) error {
+	orderID := c.Params("id")
+
+	order, exists := tenantOf(c).Orders[orderID]
+	if !exists {
+		log.Warn().Msgf("Order ID %s not found", orderID)
+		return ErrOrderNotFound.WithTarget("id")
+	}
+	if err := authorizeOwner(c, PermOrdersRead, order.Customer.CustomerID); err != nil {
+		return err
+	}
+	after, err := lastEventID(c)
+	if err != nil {
+		return err
+	}
+
+	log.Info().
+		Str("event.action", "order-events-stream").
+		Str("order.id", order.ID).
+		Uint64("event.sequence", after).
+		Msg("Streaming order events")
+
+	tenantID, id := order.TenantID, order.ID
+	return streamEvents(c, after, func(event Event) bool {
+		return event.TenantID == tenantID && event.OrderID == id
+	})
+}
+
+// EventsHandler streams the events of every order of the tenant, optionally
+// only some types (comma separated), of one customer or of one order
+func EventsHandler(c *fiber.Ctx) error {
+	after, err := lastEventID(c)
+	if err != nil {
+		return err
+	}
+
+	types := make(map[string]bool)
+	for _, eventType := range strings.Split(c.Query("type"), ",") {
+		if eventType = strings.TrimSpace(eventType); eventType == "" {
+			continue
+		}
+		if !containsString(domainEventTypes, eventType) {
+			return ErrInvalidRequest.WithMessage("One or more fields are invalid").WithTarget("type").WithDetails([]FieldError{
+				{Field: "type", Reason: "must be one of " + strings.Join(domainEventTypes, ", ")},
+			})
+		}
+		types[strings.Clone(eventType)] = true
+	}
+	tenantID := tenantOf(c).ID
+	customerID := strings.Clone(c.Query("customer_id"))
+	orderID := strings.Clone(c.Query("order_id"))
+
+	log.Info().
+		Str("event.action", "events-stream").
+		Str("tenant.id", tenantID).
+		Uint64("event.sequence", after).
+		Msg("Streaming events")
+
+	return streamEvents(c, after, func(event Event) bool {
+		return event.TenantID == tenantID &&
+			(len(types) == 0 || types[event.Type]) &&
+			(customerID == "" || event.CustomerID == customerID) &&
+			(orderID == "" || event.OrderID == orderID)
+	})
+}
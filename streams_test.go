@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// Struct to represent a message read from an event stream
+type streamMessage struct {
+	id    string
+	event string
+	data  map[string]interface{}
+}
+
+// listen serves an app on a local port, as streams never end for app.Test
+func listen(t *testing.T, app *fiber.App) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go app.Listener(listener)
+	t.Cleanup(func() { app.ShutdownWithTimeout(100 * time.Millisecond) })
+	return "http://" + listener.Addr().String()
+}
+
+// openStream starts an event stream and returns a function reading its
+// next message
+func openStream(t *testing.T, url string, headers map[string]string) func() streamMessage {
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := (&http.Client{Timeout: 5 * time.Second}).Do(req)
+	assert.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get(fiber.HeaderContentType))
+
+	reader := bufio.NewReader(resp.Body)
+	return func() streamMessage {
+		var message streamMessage
+		for {
+			line, err := reader.ReadString('\n')
+			if !assert.NoError(t, err) {
+				return message
+			}
+			line = strings.TrimSuffix(line, "\n")
+			field, value, _ := strings.Cut(line, ": ")
+			switch field {
+			case "id":
+				message.id = value
+			case "event":
+				message.event = value
+			case "data":
+				assert.NoError(t, json.Unmarshal([]byte(value), &message.data))
+			case "":
+				if message.event != "" {
+					return message
+				}
+			}
+		}
+	}
+}
+
+// Test that an order's stream replays its history, pushes new status
+// changes and resumes after the last event ID
+func TestOrderEventStream(t *testing.T) {
+	app := setupApp()
+	baseURL := listen(t, app)
+	createPaidOrder(t, app, "cust_stream", "order_stream", nil)
+	status, _ := doJSON(t, app, http.MethodPost, "/v1/orders/order_stream/route", "")
+	assert.Equal(t, 200, status)
+
+	next := openStream(t, baseURL+"/v1/orders/order_stream/events", nil)
+	assert.Equal(t, EventPaymentProcessed, next().event)
+	assert.Equal(t, EventOrderStatusChanged, next().event)
+	assert.Equal(t, EventOrderRouted, next().event)
+	routed := next()
+	assert.Equal(t, EventOrderStatusChanged, routed.event)
+	assert.Equal(t, "Order Routed", routed.data["status"])
+	assert.Equal(t, "Payment Processed", routed.data["data"].(map[string]interface{})["previous_status"])
+	assert.Equal(t, "order_stream", routed.data["order_id"])
+
+	status, _ = doJSON(t, app, http.MethodPost, "/v1/orders/order_stream/fulfill", "")
+	assert.Equal(t, 200, status)
+	var pushed []string
+	for i := 0; i < 5; i++ {
+		message := next()
+		pushed = append(pushed, message.event+" "+message.data["status"].(string))
+	}
+	assert.Equal(t, []string{
+		"order.status_changed Items Picked", "order.status_changed Items Packed", "order.shipped Fulfillment Completed",
+		"order.fulfilled Fulfillment Completed", "order.status_changed Fulfillment Completed",
+	}, pushed)
+
+	resumed := openStream(t, baseURL+"/v1/orders/order_stream/events", map[string]string{lastEventIDHeader: routed.id})
+	assert.Equal(t, "Items Picked", resumed().data["status"])
+
+	// The firehose can be narrowed down by type and customer
+	firehose := openStream(t, baseURL+"/v1/events?type=order.fulfilled,payment.captured&customer_id=cust_stream", nil)
+	fulfilled := firehose()
+	assert.Equal(t, EventOrderFulfilled, fulfilled.event)
+	assert.Equal(t, "order_stream", fulfilled.data["order_id"])
+	status, _ = doJSON(t, app, http.MethodPost, "/v1/orders/order_stream/capture", "")
+	assert.Equal(t, 200, status)
+	assert.Equal(t, EventPaymentCaptured, firehose().event)
+
+	status, body := doJSON(t, app, http.MethodGet, "/v1/orders/order_unknown/events", "")
+	assert.Equal(t, 404, status)
+	assert.Equal(t, "OrderNotFound", errorCode(body))
+	status, body = doJSON(t, app, http.MethodGet, "/v1/orders/order_stream/events?last_event_id=latest", "")
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "last_event_id", body["error"].(map[string]interface{})["target"])
+	status, body = doJSON(t, app, http.MethodGet, "/v1/orders/order_stream/events?last_event_id=18446744073709551615", "")
+	assert.Equal(t, 410, status)
+	assert.Equal(t, "EventsExpired", errorCode(body))
+	status, body = doJSON(t, app, http.MethodGet, "/v1/events?type=order.teleported", "")
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "type", body["error"].(map[string]interface{})["target"])
+}
+
+// Test that shipment updates from carriers reach the order's stream
+func TestOrderEventStreamCarrierDelivery(t *testing.T) {
+	app := setupApp()
+	baseURL := listen(t, app)
+	carrier := fakeCarrier{name: "streamcarrier", secret: "s3cret"}
+	RegisterCarrier(carrier.name, GenericCarrierParser{}, carrier.secret)
+
+	createPaidOrder(t, app, "cust_stream_delivery", "order_stream_delivery", nil)
+	packOrder(t, app, "order_stream_delivery")
+	status, _ := doJSON(t, app, http.MethodPost, "/v1/shipments", `{"order_id": "order_stream_delivery", "carrier": "StreamCarrier", "tracking_number": "SC123"}`)
+	assert.Equal(t, 201, status)
+
+	next := openStream(t, baseURL+"/v1/events?type=order.status_changed&order_id=order_stream_delivery", nil)
+	for _, expected := range []string{"Payment Processed", "Order Routed", "Items Picked", "Items Packed", "Shipped"} {
+		assert.Equal(t, expected, next().data["status"])
+	}
+
+	status, body := carrier.post(t, app, `{"events": [{"event_id": "evt-stream-1", "tracking_number": "SC123", "status": "delivered"}]}`, time.Now())
+	assert.Equal(t, 200, status)
+	assert.Equal(t, 1.0, body["applied"])
+	delivered := next()
+	assert.Equal(t, "Delivered", delivered.data["status"])
+	assert.Equal(t, "Shipped", delivered.data["data"].(map[string]interface{})["previous_status"])
+}
+
+// Test that customers only stream their own orders, and only admins the firehose
+func TestEventStreamPermissions(t *testing.T) {
+	app := setupAuthApp(&AuthConfig{JWTSecret: rbacSecret})
+	owner := rbacToken(t, "user_stream_owner", "cust_stream_owner", "customer")
+	status, _ := doAuthJSON(t, app, http.MethodPost, "/v1/carts", owner, `{"customer_id": "cust_stream_owner", "items": [{"item_id": "item001", "quantity": 1}, {"item_id": "item002", "quantity": 2}]}`)
+	assert.Equal(t, 200, status)
+	status, _ = doAuthJSON(t, app, http.MethodPost, "/v1/orders", owner, `{
+		"order_id": "order_stream_owner", "amount": 1100,
+		"billing_address": {"customer_id": "cust_stream_owner", "name": "Owner", "email": "owner@example.com", "phone": "+15555555555", "country": "US"}
+	}`)
+	assert.Equal(t, 200, status)
+
+	// Customers do not see who handled their orders
+	baseURL := listen(t, app)
+	next := openStream(t, baseURL+"/v1/orders/order_stream_owner/events", map[string]string{fiber.HeaderAuthorization: "Bearer " + owner})
+	assert.NotContains(t, next().data, "actor")
+	next = openStream(t, baseURL+"/v1/orders/order_stream_owner/events", map[string]string{fiber.HeaderAuthorization: "Bearer " + rbacToken(t, "user_stream_support", "", "support")})
+	assert.Equal(t, "user_stream_owner", next().data["actor"])
+
+	status, body := doAuthJSON(t, app, http.MethodGet, "/v1/orders/order_stream_owner/events", rbacToken(t, "user_stream", "cust_stream_other", "customer"), "")
+	assert.Equal(t, 403, status)
+	assert.Equal(t, "NotResourceOwner", errorCode(body))
+
+	for _, role := range []string{"customer", "support", "warehouse"} {
+		status, body = doAuthJSON(t, app, http.MethodGet, "/v1/events", rbacToken(t, "user_stream", "cust_stream_owner", role), "")
+		assert.Equal(t, 403, status, role)
+		assert.Equal(t, "PermissionDenied", errorCode(body), role)
+	}
+}
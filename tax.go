@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strings"
+)
+
+// Product tax class that is never taxed
+const TaxClassExempt = "exempt"
+
+// Struct to represent the tax on a single order line
+type LineTax struct {
+	Rate      float64 `json:"rate"`
+	Amount    float64 `json:"amount"`
+	Inclusive bool    `json:"inclusive"` // already part of the line price
+}
+
+// TaxCalculator calculates the tax on an amount of a product tax class sold to an address
+type TaxCalculator interface {
+	Calculate(address BillingAddress, taxClass string, amount float64) LineTax
+}
+
+// Struct to represent a tax rate for a country, region and product tax class
+type TaxRule struct {
+	Country   string  `json:"country"`
+	Region    string  `json:"region"`    // empty matches the whole country
+	TaxClass  string  `json:"tax_class"` // empty matches every tax class
+	Rate      float64 `json:"rate"`      // e.g. 0.11 for 11%
+	Inclusive bool    `json:"inclusive"` // catalog prices already include the tax
+}
+
+// RuleTableTaxCalculator looks up rates in a table of rules. The most specific
+// rule wins: region and tax class, then region, then tax class, then country.
+// Addresses without a matching rule are not taxed.
+type RuleTableTaxCalculator struct {
+	Rules []TaxRule
+}
+
+// Default tax rules for the demo
+var defaultTaxRules = []TaxRule{
+	{Country: "ID", Rate: 0.11},
+	{Country: "GB", Rate: 0.20, Inclusive: true},
+	{Country: "GB", TaxClass: "reduced", Rate: 0.05, Inclusive: true},
+	{Country: "DE", Rate: 0.19, Inclusive: true},
+	{Country: "DE", TaxClass: "reduced", Rate: 0.07, Inclusive: true},
+	{Country: "US", Region: "CA", Rate: 0.0725},
+	{Country: "US", Region: "NY", Rate: 0.04},
+}
+
+// Tax calculator used for carts and orders
+var taxCalculator TaxCalculator = RuleTableTaxCalculator{Rules: defaultTaxRules}
+
+// matchRule returns the most specific rule for the address and tax class
+func (t RuleTableTaxCalculator) matchRule(address BillingAddress, taxClass string) (TaxRule, bool) {
+	var best TaxRule
+	bestScore := -1
+	for _, rule := range t.Rules {
+		if !strings.EqualFold(rule.Country, address.Country) {
+			continue
+		}
+		if rule.Region != "" && !strings.EqualFold(rule.Region, address.Region) {
+			continue
+		}
+		if rule.TaxClass != "" && rule.TaxClass != taxClass {
+			continue
+		}
+
+		score := 0
+		if rule.Region != "" {
+			score += 2
+		}
+		if rule.TaxClass != "" {
+			score++
+		}
+		if score > bestScore {
+			best, bestScore = rule, score
+		}
+	}
+	return best, bestScore >= 0
+}
+
+func (t RuleTableTaxCalculator) Calculate(address BillingAddress, taxClass string, amount float64) LineTax {
+	if taxClass == TaxClassExempt {
+		return LineTax{}
+	}
+
+	rule, found := t.matchRule(address, taxClass)
+	if !found {
+		return LineTax{}
+	}
+
+	// Inclusive prices contain the tax, exclusive prices get it added on top
+	if rule.Inclusive {
+		return LineTax{Rate: rule.Rate, Amount: roundAmount(amount - amount/(1+rule.Rate)), Inclusive: true}
+	}
+	return LineTax{Rate: rule.Rate, Amount: roundAmount(amount * rule.Rate)}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test rule matching and inclusive vs exclusive tax
+func TestRuleTableTaxCalculator(t *testing.T) {
+	calc := RuleTableTaxCalculator{Rules: defaultTaxRules}
+
+	// Exclusive tax is added on top of the price
+	tax := calc.Calculate(BillingAddress{Country: "ID"}, "standard", 1000)
+	assert.Equal(t, LineTax{Rate: 0.11, Amount: 110}, tax)
+
+	// Inclusive tax is already part of the price
+	tax = calc.Calculate(BillingAddress{Country: "GB"}, "standard", 120)
+	assert.Equal(t, LineTax{Rate: 0.20, Amount: 20, Inclusive: true}, tax)
+
+	// Tax class specific rules win over the country rule
+	tax = calc.Calculate(BillingAddress{Country: "GB"}, "reduced", 105)
+	assert.Equal(t, 5.0, tax.Amount)
+
+	// Region rules only apply inside the region
+	tax = calc.Calculate(BillingAddress{Country: "US", Region: "CA"}, "standard", 100)
+	assert.Equal(t, 7.25, tax.Amount)
+	tax = calc.Calculate(BillingAddress{Country: "US", Region: "OR"}, "standard", 100)
+	assert.Equal(t, 0.0, tax.Amount)
+
+	// Exempt products are never taxed
+	tax = calc.Calculate(BillingAddress{Country: "ID"}, TaxClassExempt, 100)
+	assert.Equal(t, 0.0, tax.Amount)
+}
+
+// Test that the payment amount must include tax and tax is stored per line
+func TestProcessPaymentIncludesTax(t *testing.T) {
+	app := setupApp()
+
+	status, _ := doJSON(t, app, http.MethodPost, "/create-cart", `{
+		"customer_id": "cust_tax",
+		"items": [{"item_id": "item001", "quantity": 1}, {"item_id": "item002", "quantity": 2}]
+	}`)
+	assert.Equal(t, 200, status)
+
+	billingAddress := map[string]interface{}{
+		"customer_id": "cust_tax",
+		"name":        "Budi",
+		"email":       "budi@example.com",
+		"phone":       "+628123456789",
+		"country":     "ID",
+	}
+
+	quotePayload, _ := json.Marshal(map[string]interface{}{"billing_address": billingAddress})
+	status, body := doJSON(t, app, http.MethodPost, "/quote-cart", string(quotePayload))
+	assert.Equal(t, 200, status)
+	totals := body["totals"].(map[string]interface{})
+	assert.Equal(t, 1100.0, totals["subtotal"])
+	assert.Equal(t, 121.0, totals["tax"])
+	assert.Equal(t, 1221.0, totals["grand_total"])
+
+	// Paying only the item subtotal is rejected
+	paymentPayload := map[string]interface{}{
+		"order_id":        "order_tax",
+		"amount":          1100,
+		"billing_address": billingAddress,
+	}
+	paymentPayloadBytes, _ := json.Marshal(paymentPayload)
+	status, body = doJSON(t, app, http.MethodPost, "/process-payment", string(paymentPayloadBytes))
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "AmountMismatch", body["error"].(map[string]interface{})["code"])
+
+	paymentPayload["amount"] = 1221
+	paymentPayloadBytes, _ = json.Marshal(paymentPayload)
+	status, body = doJSON(t, app, http.MethodPost, "/process-payment", string(paymentPayloadBytes))
+	assert.Equal(t, 200, status)
+
+	order := body["order"].(map[string]interface{})
+	assert.Equal(t, 1221.0, order["Amount"])
+	assert.Equal(t, 121.0, order["Totals"].(map[string]interface{})["tax"])
+	line := order["Items"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, 0.11, line["tax_rate"])
+	assert.Equal(t, 110.0, line["tax"])
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Header naming the tenant of a request when the principal does not
+const tenantHeader = "X-Tenant-ID"
+
+// Tenant serving requests that name none, e.g. on single-storefront deployments
+const defaultTenantID = "default"
+
+// Fiber context key of the request's tenant
+const tenantKey = "tenant"
+
+// Struct to represent the settings of a storefront sharing the deployment
+type TenantConfig struct {
+	ID          string    `json:"tenant_id"`
+	Name        string    `json:"name"`
+	Currency    string    `json:"currency"`     // ISO 4217 code of every amount
+	GracePeriod string    `json:"grace_period"` // Go duration before routing, e.g. "5s"
+	TaxRules    []TaxRule `json:"tax_rules"`    // defaultTaxRules when empty
+}
+
+// Struct to represent a tenant with its isolated stores
+type Tenant struct {
+	TenantConfig
+	gracePeriod time.Duration
+	tax         TaxCalculator
+
+	Carts          map[string]*Cart          // by customer ID
+	Orders         map[string]*Order         // by order ID
+	Customers      map[string]*CustomerInfo  // by customer ID
+	Products       map[string]*Product       // by SKU
+	Promotions     map[string]*Promotion     // by coupon code
+	promotionUsage map[string]map[string]int // paid orders by coupon code and customer ID
+}
+
+// Settings of the default tenant
+var defaultTenantConfig = TenantConfig{ID: defaultTenantID, Name: "Default", Currency: "USD", GracePeriod: "5s"}
+
+// Tenants by ID. The default tenant always exists.
+var tenants = map[string]*Tenant{defaultTenantID: mustNewTenant(defaultTenantConfig)}
+
+// Tenant of requests that name none
+var defaultTenant = tenants[defaultTenantID]
+
+// newTenant sets up a tenant with empty stores
+func newTenant(config TenantConfig) (*Tenant, error) {
+	if config.ID == "" {
+		return nil, fmt.Errorf("tenant without tenant_id")
+	}
+	if config.Currency == "" {
+		config.Currency = defaultTenantConfig.Currency
+	}
+	if config.GracePeriod == "" {
+		config.GracePeriod = defaultTenantConfig.GracePeriod
+	}
+	gracePeriod, err := time.ParseDuration(config.GracePeriod)
+	if err != nil {
+		return nil, fmt.Errorf("tenant %s: invalid grace_period: %w", config.ID, err)
+	}
+
+	tenant := &Tenant{
+		TenantConfig:   config,
+		gracePeriod:    gracePeriod,
+		tax:            taxCalculator,
+		Carts:          make(map[string]*Cart),
+		Orders:         make(map[string]*Order),
+		Customers:      make(map[string]*CustomerInfo),
+		Products:       demoProducts(),
+		Promotions:     make(map[string]*Promotion),
+		promotionUsage: make(map[string]map[string]int),
+	}
+	if len(config.TaxRules) > 0 {
+		tenant.tax = RuleTableTaxCalculator{Rules: config.TaxRules}
+	}
+	return tenant, nil
+}
+
+func mustNewTenant(config TenantConfig) *Tenant {
+	tenant, err := newTenant(config)
+	if err != nil {
+		panic(err)
+	}
+	return tenant
+}
+
+// configureTenants adds the tenants of a JSON file holding a list of
+// TenantConfig. A "default" entry replaces the default tenant's settings.
+func configureTenants(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var configs []TenantConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return fmt.Errorf("invalid tenants file %s: %w", path, err)
+	}
+
+	for _, config := range configs {
+		tenant, err := newTenant(config)
+		if err != nil {
+			return err
+		}
+		if existing, exists := tenants[tenant.ID]; exists {
+			// Keep the stores of a tenant that is already serving requests
+			tenant.Carts, tenant.Orders, tenant.Customers = existing.Carts, existing.Orders, existing.Customers
+			tenant.Products, tenant.Promotions, tenant.promotionUsage = existing.Products, existing.Promotions, existing.promotionUsage
+			*existing = *tenant
+			continue
+		}
+		tenants[tenant.ID] = tenant
+	}
+	return nil
+}
+
+// ResolveTenant picks the tenant of each request: the tenant of the principal,
+// else the X-Tenant-ID header, else the default tenant. Principals bound to a
+// tenant cannot name another one.
+func ResolveTenant() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenantID := c.Get(tenantHeader)
+		if principal := currentPrincipal(c); principal != nil && principal.TenantID != "" {
+			if tenantID != "" && tenantID != principal.TenantID {
+				log.Warn().
+					Str("user.id", principal.ID).
+					Str("tenant.id", tenantID).
+					Msg("Principal named another tenant")
+				return ErrTenantMismatch.WithTarget(tenantHeader)
+			}
+			tenantID = principal.TenantID
+		}
+		if tenantID == "" {
+			tenantID = defaultTenantID
+		}
+
+		tenant, exists := tenants[tenantID]
+		if !exists {
+			log.Warn().Str("tenant.id", tenantID).Msg("Unknown tenant")
+			return ErrTenantNotFound.WithTarget(tenantHeader)
+		}
+		c.Locals(tenantKey, tenant)
+		return c.Next()
+	}
+}
+
+// findTenantOrder looks up an order of a tenant outside a request, e.g. for
+// carrier webhooks
+func findTenantOrder(tenantID, orderID string) (*Order, bool) {
+	tenant, exists := tenants[tenantID]
+	if !exists {
+		return nil, false
+	}
+	order, exists := tenant.Orders[orderID]
+	return order, exists
+}
+
+// tenantOf returns the tenant of a request, the default tenant when the
+// request did not go through ResolveTenant
+func tenantOf(c *fiber.Ctx) *Tenant {
+	if tenant, ok := c.Locals(tenantKey).(*Tenant); ok {
+		return tenant
+	}
+	return defaultTenant
+}
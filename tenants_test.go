@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// setupTenants adds tenants from a config file for the length of a test
+func setupTenants(t *testing.T, configs []TenantConfig) {
+	t.Helper()
+
+	data, err := json.Marshal(configs)
+	assert.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "tenants.json")
+	assert.NoError(t, os.WriteFile(path, data, 0o600))
+	assert.NoError(t, configureTenants(path))
+
+	t.Cleanup(func() {
+		for _, config := range configs {
+			delete(tenants, config.ID)
+		}
+	})
+}
+
+// doTenantJSON sends a JSON request naming a tenant
+func doTenantJSON(t *testing.T, app *fiber.App, method, path, tenantID, payload string) (int, map[string]interface{}) {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(tenantHeader, tenantID)
+	resp, err := app.Test(req, -1)
+	assert.NoError(t, err)
+
+	var body map[string]interface{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	return resp.StatusCode, body
+}
+
+// Test that tenants keep their own orders, currency, tax rules and grace period
+func TestTenantIsolation(t *testing.T) {
+	setupTenants(t, []TenantConfig{{
+		ID: "shop_eu", Name: "EU Shop", Currency: "EUR", GracePeriod: "10ms",
+		TaxRules: []TaxRule{{Country: "US", Rate: 0.2}},
+	}})
+	app := setupApp()
+
+	// The same order ID is used in the default tenant and in shop_eu
+	createPaidOrder(t, app, "cust_tenant_1", "order_tenant_1", nil)
+
+	status, _ := doTenantJSON(t, app, http.MethodPost, "/v1/carts", "shop_eu", `{"customer_id": "cust_tenant_1", "items": [{"item_id": "item001", "quantity": 1}, {"item_id": "item002", "quantity": 2}]}`)
+	assert.Equal(t, 200, status)
+	billingAddress := `{"customer_id": "cust_tenant_1", "name": "John Doe", "email": "john@example.com", "phone": "+15555555555", "country": "US"}`
+
+	// Tax follows the tenant's rules
+	status, body := doTenantJSON(t, app, http.MethodPost, "/v1/carts/cust_tenant_1/quote", "shop_eu", `{"billing_address": `+billingAddress+`}`)
+	assert.Equal(t, 200, status)
+	assert.Equal(t, 220.0, body["totals"].(map[string]interface{})["tax"])
+
+	status, body = doTenantJSON(t, app, http.MethodPost, "/v1/orders", "shop_eu", `{"order_id": "order_tenant_1", "amount": 1320, "billing_address": `+billingAddress+`}`)
+	assert.Equal(t, 200, status)
+	order := body["order"].(map[string]interface{})
+	assert.Equal(t, "shop_eu", order["TenantID"])
+	assert.Equal(t, "EUR", order["Currency"])
+
+	// Each tenant only sees its own order
+	status, body = doJSON(t, app, http.MethodGet, "/v1/orders/order_tenant_1", "")
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "USD", body["order"].(map[string]interface{})["Currency"])
+	assert.Equal(t, 1100.0, defaultTenant.Orders["order_tenant_1"].Amount)
+	assert.Equal(t, 1320.0, tenants["shop_eu"].Orders["order_tenant_1"].Amount)
+
+	status, body = doTenantJSON(t, app, http.MethodGet, "/v1/reports/revenue", "shop_eu", "")
+	assert.Equal(t, 200, status)
+	report := body["report"].(map[string]interface{})
+	assert.Equal(t, "EUR", report["currency"])
+	assert.Equal(t, 1.0, report["orders"])
+
+	// The grace period is the tenant's
+	start := time.Now()
+	status, _ = doTenantJSON(t, app, http.MethodPost, "/v1/orders/order_tenant_1/grace-period", "shop_eu", "")
+	assert.Equal(t, 200, status)
+	assert.Less(t, time.Since(start), time.Second)
+
+	status, body = doTenantJSON(t, app, http.MethodGet, "/v1/orders/order_tenant_1", "shop_unknown", "")
+	assert.Equal(t, 404, status)
+	assert.Equal(t, "TenantNotFound", errorCode(body))
+}
+
+// Test that customers, coupons and products belong to their tenant
+func TestTenantAccountIsolation(t *testing.T) {
+	setupTenants(t, []TenantConfig{{ID: "shop_accounts"}})
+	app := setupApp()
+
+	status, _ := doTenantJSON(t, app, http.MethodPost, "/v1/customers", "shop_accounts", `{"customer_id": "cust_tenant_account", "name": "Siti", "email": "siti@example.com"}`)
+	assert.Equal(t, 201, status)
+	status, _ = doTenantJSON(t, app, http.MethodPost, "/v1/promotions", "shop_accounts", `{"code": "TENANT10", "type": "percentage", "value": 10}`)
+	assert.Equal(t, 201, status)
+	status, _ = doTenantJSON(t, app, http.MethodPost, "/v1/products", "shop_accounts", `{"sku": "item_tenant", "name": "Tenant Mug", "price": 12}`)
+	assert.Equal(t, 201, status)
+
+	// The default tenant sees none of them, and may reuse their IDs
+	status, body := doJSON(t, app, http.MethodGet, "/v1/customers/cust_tenant_account", "")
+	assert.Equal(t, 404, status)
+	assert.Equal(t, "CustomerNotFound", errorCode(body))
+	status, body = doJSON(t, app, http.MethodGet, "/v1/products/item_tenant", "")
+	assert.Equal(t, 404, status)
+	assert.Equal(t, "ProductNotFound", errorCode(body))
+	status, _ = doJSON(t, app, http.MethodPost, "/v1/customers", `{"customer_id": "cust_tenant_account", "name": "Other", "email": "other@example.com"}`)
+	assert.Equal(t, 201, status)
+
+	status, _ = doJSON(t, app, http.MethodPost, "/v1/carts", `{"customer_id": "cust_tenant_account", "items": [{"item_id": "item001", "quantity": 1}]}`)
+	assert.Equal(t, 200, status)
+	status, body = doJSON(t, app, http.MethodPost, "/v1/carts/cust_tenant_account/coupon", `{"code": "TENANT10"}`)
+	assert.Equal(t, 404, status)
+	assert.Equal(t, "PromotionNotFound", errorCode(body))
+
+	status, _ = doTenantJSON(t, app, http.MethodPost, "/v1/carts", "shop_accounts", `{"customer_id": "cust_tenant_account", "items": [{"item_id": "item_tenant", "quantity": 1}]}`)
+	assert.Equal(t, 200, status)
+	status, _ = doTenantJSON(t, app, http.MethodPost, "/v1/carts/cust_tenant_account/coupon", "shop_accounts", `{"code": "TENANT10"}`)
+	assert.Equal(t, 200, status)
+
+	assert.Equal(t, "Siti", tenants["shop_accounts"].Customers["cust_tenant_account"].Name)
+	assert.Equal(t, "Other", defaultTenant.Customers["cust_tenant_account"].Name)
+}
+
+// Test that credentials bound to a tenant cannot reach another tenant
+func TestTenantBoundCredentials(t *testing.T) {
+	setupTenants(t, []TenantConfig{{ID: "shop_id", Currency: "IDR"}, {ID: "shop_sg", Currency: "SGD"}})
+	config, err := loadAuthConfig(func(name string) string {
+		return map[string]string{
+			"API_KEYS":        "shop-id-backend=id-key",
+			"API_KEY_ROLES":   "shop-id-backend=admin",
+			"API_KEY_TENANTS": "shop-id-backend=shop_id",
+		}[name]
+	})
+	assert.NoError(t, err)
+	app := setupAuthApp(config)
+
+	resp, body := authRequest(t, app, http.MethodGet, "/v1/reports/revenue", map[string]string{apiKeyHeader: "id-key"})
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "IDR", body["report"].(map[string]interface{})["currency"])
+
+	resp, body = authRequest(t, app, http.MethodGet, "/v1/reports/revenue", map[string]string{apiKeyHeader: "id-key", tenantHeader: "shop_sg"})
+	assert.Equal(t, 403, resp.StatusCode)
+	assert.Equal(t, "TenantMismatch", errorCode(body))
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// setupV1Routes sets up the versioned, resource-oriented API. Identifiers are
+// path parameters; actions on a resource are sub-resources posted to.
+func setupV1Routes(api fiber.Router) {
+	api.Post("/carts", authorize(PermCartsWrite), CreateCartHandler)
+	api.Post("/carts/:id/quote", authorize(PermCartsWrite), QuoteCartHandler)
+	api.Post("/carts/:id/coupon", authorize(PermCartsWrite), ApplyCouponHandler)
+	api.Delete("/carts/:id/coupon", authorize(PermCartsWrite), RemoveCouponHandler)
+
+	// Paying for a cart creates the order
+	api.Post("/orders", authorize(PermOrdersCreate), ProcessPaymentHandler)
+	api.Get("/orders", authorize(PermOrdersRead), GetOrdersHandler)
+	api.Get("/orders/:id", authorize(PermOrdersRead), GetOrderHandler)
+	api.Get("/orders/:id/events", authorize(PermOrdersRead), OrderEventsHandler)
+	api.Post("/orders/:id/grace-period", authorize(PermOrdersRoute), WaitGracePeriodHandler)
+	api.Post("/orders/:id/route", authorize(PermOrdersRoute), RouteOrderHandler)
+	api.Post("/orders/:id/fulfill", authorize(PermOrdersFulfill), FullfillOrderHandler)
+	api.Post("/orders/:id/capture", authorize(PermPaymentsCapture), CapturePaymentHandler)
+	api.Post("/orders/:id/refund", authorize(PermPaymentsRefund), RefundPaymentHandler)
+	api.Post("/orders/:id/cancel", authorize(PermOrdersCancel), CancelOrderHandler)
+
+	api.Post("/orders/:id/fulfillment/pick", authorize(PermOrdersFulfill), FulfillmentStepHandler(StepPicked))
+	api.Post("/orders/:id/fulfillment/pack", authorize(PermOrdersFulfill), FulfillmentStepHandler(StepPacked))
+	api.Post("/orders/:id/fulfillment/ship", authorize(PermOrdersFulfill), FulfillmentStepHandler(StepShipped))
+	api.Post("/orders/:id/fulfillment/ready-for-pickup", authorize(PermOrdersFulfill), FulfillmentStepHandler(StepReadyForPickup))
+	api.Post("/orders/:id/fulfillment/collect", authorize(PermOrdersFulfill), FulfillmentStepHandler(StepCollected))
+
+	api.Post("/shipments", authorize(PermOrdersFulfill), CreateShipmentHandler)
+	api.Get("/shipments/:id", authorize(PermOrdersRead), GetShipmentHandler)
+	api.Post("/shipments/:id/status", authorize(PermOrdersFulfill), UpdateShipmentStatusHandler)
+
+	api.Post("/webhooks/carriers/:carrier", CarrierWebhookHandler)
+
+	api.Post("/webhooks/subscriptions", authorize(PermWebhooksManage), CreateWebhookSubscriptionHandler)
+	api.Get("/webhooks/subscriptions", authorize(PermWebhooksManage), GetWebhookSubscriptionsHandler)
+	api.Get("/webhooks/subscriptions/:id", authorize(PermWebhooksManage), GetWebhookSubscriptionHandler)
+	api.Delete("/webhooks/subscriptions/:id", authorize(PermWebhooksManage), DeleteWebhookSubscriptionHandler)
+	api.Get("/webhooks/subscriptions/:id/deliveries", authorize(PermWebhooksManage), GetWebhookDeliveriesHandler)
+	api.Post("/webhooks/subscriptions/:id/deliveries/:delivery_id/redeliver", authorize(PermWebhooksManage), RedeliverWebhookHandler)
+
+	api.Get("/events", authorize(PermEventsStream), EventsHandler)
+
+	api.Post("/returns", authorize(PermReturnsCreate), CreateReturnHandler)
+	api.Get("/returns/:id", authorize(PermReturnsRead), GetReturnHandler)
+	api.Post("/returns/:id/approve", authorize(PermReturnsManage), ApproveReturnHandler)
+	api.Post("/returns/:id/reject", authorize(PermReturnsManage), RejectReturnHandler)
+	api.Post("/returns/:id/receive", authorize(PermReturnsManage), ReceiveReturnHandler)
+	api.Post("/returns/:id/inspect", authorize(PermReturnsManage), InspectReturnHandler)
+	api.Post("/returns/:id/refund", authorize(PermPaymentsRefund), RefundReturnHandler)
+
+	api.Post("/exchanges", authorize(PermReturnsCreate), ExchangeHandler)
+
+	api.Get("/reports/revenue", authorize(PermReportsRead), RevenueReportHandler)
+
+	api.Post("/products", authorize(PermProductsWrite), CreateProductHandler)
+	api.Get("/products", authorize(PermProductsRead), GetProductsHandler)
+	api.Get("/products/:sku", authorize(PermProductsRead), GetProductHandler)
+	api.Put("/products/:sku", authorize(PermProductsWrite), UpdateProductHandler)
+	api.Delete("/products/:sku", authorize(PermProductsWrite), DeleteProductHandler)
+
+	api.Post("/customers", authorize(PermCustomersWrite), CreateCustomerHandler)
+	api.Get("/customers", authorize(PermCustomersRead), GetCustomersHandler)
+	api.Get("/customers/:id", authorize(PermCustomersRead), GetCustomerHandler)
+	api.Patch("/customers/:id", authorize(PermCustomersWrite), UpdateCustomerHandler)
+	api.Get("/customers/:id/orders", authorize(PermOrdersRead), GetCustomerOrdersHandler)
+
+	api.Post("/promotions", authorize(PermPromotionsWrite), CreatePromotionHandler)
+	api.Get("/promotions", authorize(PermPromotionsRead), GetPromotionsHandler)
+	api.Delete("/promotions/:code", authorize(PermPromotionsWrite), DeletePromotionHandler)
+
+	api.Get("/errors", GetErrorsHandler)
+	api.Get("/errors/:code", GetErrorHandler)
+}
+
+// deprecated marks a legacy route with the Deprecation header and links to the
+// /v1 route replacing it
+func deprecated(successor string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Set("Deprecation", "true")
+		c.Set(fiber.HeaderLink, "<"+successor+`>; rel="successor-version"`)
+		return c.Next()
+	}
+}
+
+// parseBody parses the JSON body into out. Routes that take the resource from
+// the path accept an empty body.
+func parseBody(c *fiber.Ctx, out interface{}) error {
+	if len(c.Body()) == 0 && len(c.Route().Params) > 0 {
+		return nil
+	}
+	return c.BodyParser(out)
+}
+
+// pathParam returns the path parameter on /v1 routes, else the value the legacy
+// route read from the request
+func pathParam(c *fiber.Ctx, name, legacy string) string {
+	if value := c.Params(name); value != "" {
+		return value
+	}
+	return legacy
+}
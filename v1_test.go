@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test the order lifecycle through the /v1 resource routes with path parameters
+func TestV1OrderLifecycle(t *testing.T) {
+	app := setupApp()
+
+	status, _ := doJSON(t, app, http.MethodPost, "/v1/carts", `{"customer_id": "cust_v1", "items": [{"item_id": "item001", "quantity": 1}, {"item_id": "item002", "quantity": 2}]}`)
+	assert.Equal(t, 200, status)
+
+	status, body := doJSON(t, app, http.MethodPost, "/v1/carts/cust_v1/quote", `{}`)
+	assert.Equal(t, 200, status)
+	assert.Equal(t, float64(1100), body["totals"].(map[string]interface{})["grand_total"])
+
+	status, _ = doJSON(t, app, http.MethodPost, "/v1/orders", `{
+		"order_id": "order_v1", "amount": 1100,
+		"billing_address": {"customer_id": "cust_v1", "name": "John Doe", "email": "john@example.com", "phone": "+15555555555", "country": "US"}
+	}`)
+	assert.Equal(t, 200, status)
+
+	// Actions take the order from the path and need no body
+	for _, action := range []string{"route", "fulfill", "capture"} {
+		status, _ = doJSON(t, app, http.MethodPost, "/v1/orders/order_v1/"+action, "")
+		assert.Equal(t, 200, status, action)
+	}
+
+	status, body = doJSON(t, app, http.MethodGet, "/v1/orders/order_v1", "")
+	assert.Equal(t, 200, status)
+	order := body["order"].(map[string]interface{})
+	assert.Equal(t, "Payment Captured", order["Status"])
+
+	status, body = doJSON(t, app, http.MethodPost, "/v1/orders/order_v1/cancel", "")
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "OrderAlreadyFulfilled", body["error"].(map[string]interface{})["code"])
+
+	status, body = doJSON(t, app, http.MethodGet, "/v1/orders/order_v1_missing", "")
+	assert.Equal(t, 404, status)
+	assert.Equal(t, "OrderNotFound", body["error"].(map[string]interface{})["code"])
+}
+
+// Test that legacy routes keep working and point clients to /v1
+func TestLegacyRoutesDeprecated(t *testing.T) {
+	app := setupApp()
+	createPaidOrder(t, app, "cust_v1_legacy", "order_v1_legacy", nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/route-order", bytes.NewBufferString(`{"order_id": "order_v1_legacy"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "true", resp.Header.Get("Deprecation"))
+	assert.Equal(t, `</v1/orders/{id}/route>; rel="successor-version"`, resp.Header.Get("Link"))
+
+	// Legacy routes still need the order ID in the body
+	status, body := doJSON(t, app, http.MethodPost, "/capture-payment", `{}`)
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "MissingOrderID", body["error"].(map[string]interface{})["code"])
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/orders/order_v1_legacy", nil)
+	resp, err = app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Deprecation"))
+}
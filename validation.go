@@ -0,0 +1,256 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Request structs declare their rules in `validate` tags, for example
+// `validate:"required,email"`. Supported rules:
+//
+//	required               the value must not be empty
+//	omitempty              skip the other rules when the value is empty
+//	email                  an email address
+//	e164                   an E.164 phone number, e.g. +628123456789
+//	country                an ISO 3166-1 alpha-2 country code
+//	url                    an absolute http or https URL
+//	gt=N, gte=N            number greater than (or equal to) N
+//	min=N, max=N           length of a string or list, or value of a number
+//	oneof=a b c            one of the listed values
+//	required_fields=a b    the listed fields of a nested struct must not be empty
+//
+// Nested structs, pointers to structs and lists of structs are checked too.
+
+// Struct to represent one invalid field in a request
+type FieldError struct {
+	Field  string `json:"field"` // JSON path of the field, e.g. items[0].quantity
+	Reason string `json:"reason"`
+}
+
+var (
+	emailPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+	e164Pattern  = regexp.MustCompile(`^\+[1-9]\d{1,14}$`)
+)
+
+// ISO 3166-1 alpha-2 country codes
+var countryCodes = func() map[string]bool {
+	codes := make(map[string]bool)
+	for _, code := range strings.Fields(`
+		AD AE AF AG AI AL AM AO AQ AR AS AT AU AW AX AZ BA BB BD BE BF BG BH BI BJ BL
+		BM BN BO BQ BR BS BT BV BW BY BZ CA CC CD CF CG CH CI CK CL CM CN CO CR CU CV
+		CW CX CY CZ DE DJ DK DM DO DZ EC EE EG EH ER ES ET FI FJ FK FM FO FR GA GB GD
+		GE GF GG GH GI GL GM GN GP GQ GR GS GT GU GW GY HK HM HN HR HT HU ID IE IL IM
+		IN IO IQ IR IS IT JE JM JO JP KE KG KH KI KM KN KP KR KW KY KZ LA LB LC LI LK
+		LR LS LT LU LV LY MA MC MD ME MF MG MH MK ML MM MN MO MP MQ MR MS MT MU MV MW
+		MX MY MZ NA NC NE NF NG NI NL NO NP NR NU NZ OM PA PE PF PG PH PK PL PM PN PR
+		PS PT PW PY QA RE RO RS RU RW SA SB SC SD SE SG SH SI SJ SK SL SM SN SO SR SS
+		ST SV SX SY SZ TC TD TF TG TH TJ TK TL TM TN TO TR TT TV TW TZ UA UG UM US UY
+		UZ VA VC VE VG VI VN VU WF WS YE YT ZA ZM ZW`) {
+		codes[code] = true
+	}
+	return codes
+}()
+
+// validateRequest checks a request struct against its `validate` tags and
+// returns every invalid field
+func validateRequest(req interface{}) []FieldError {
+	var errs []FieldError
+	validateValue(reflect.ValueOf(req), "", &errs)
+	return errs
+}
+
+// checkRequest validates a request struct. On failure it returns an API error
+// listing every invalid field in its details.
+func checkRequest(req interface{}) error {
+	errs := validateRequest(req)
+	if len(errs) == 0 {
+		return nil
+	}
+
+	log.Warn().Int("error.count", len(errs)).Msgf("Request validation failed on %s", errs[0].Field)
+	return ErrInvalidRequest.WithMessage("One or more fields are invalid").WithTarget(errs[0].Field).WithDetails(errs)
+}
+
+// validateValue walks structs, pointers and lists, checking each struct field's tags
+func validateValue(v reflect.Value, path string, errs *[]FieldError) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			validateValue(v.Elem(), path, errs)
+		}
+
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			validateValue(v.Index(i), path+"["+strconv.Itoa(i)+"]", errs)
+		}
+
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			fieldPath := joinPath(path, jsonFieldName(field))
+			fieldValue := v.Field(i)
+
+			if tag := field.Tag.Get("validate"); tag != "" {
+				if reason := checkRules(fieldValue, tag); reason != "" {
+					*errs = append(*errs, FieldError{Field: fieldPath, Reason: reason})
+					continue
+				}
+				if names := requiredFields(tag); names != nil {
+					checkRequiredFields(fieldValue, fieldPath, names, errs)
+				}
+			}
+			validateValue(fieldValue, fieldPath, errs)
+		}
+	}
+}
+
+// checkRules applies the rules of a tag to a value and returns the first failure
+func checkRules(v reflect.Value, tag string) string {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			if strings.Contains(","+tag+",", ",required,") {
+				return "is required"
+			}
+			return ""
+		}
+		v = v.Elem()
+	}
+
+	for _, rule := range strings.Split(tag, ",") {
+		name, arg, _ := strings.Cut(rule, "=")
+		switch name {
+		case "required":
+			if v.IsZero() {
+				return "is required"
+			}
+		case "omitempty":
+			if v.IsZero() {
+				return ""
+			}
+		case "email":
+			if !emailPattern.MatchString(v.String()) {
+				return "must be a valid email address"
+			}
+		case "e164":
+			if !e164Pattern.MatchString(v.String()) {
+				return "must be an E.164 phone number, e.g. +628123456789"
+			}
+		case "country":
+			if !countryCodes[strings.ToUpper(v.String())] {
+				return "must be an ISO 3166-1 alpha-2 country code"
+			}
+		case "url":
+			parsed, err := url.Parse(v.String())
+			if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+				return "must be an http or https URL"
+			}
+		case "gt", "gte", "min", "max":
+			if reason := checkBound(v, name, arg); reason != "" {
+				return reason
+			}
+		case "oneof":
+			allowed := strings.Fields(arg)
+			found := false
+			for _, value := range allowed {
+				if v.String() == value {
+					found = true
+				}
+			}
+			if !found {
+				return "must be one of " + strings.Join(allowed, ", ")
+			}
+		}
+	}
+	return ""
+}
+
+// checkBound compares a number, or the length of a string or list, with a limit
+func checkBound(v reflect.Value, rule, arg string) string {
+	limit, _ := strconv.ParseFloat(arg, 64)
+
+	var value float64
+	isLength := false
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		value = float64(v.Int())
+	case reflect.Float32, reflect.Float64:
+		value = v.Float()
+	case reflect.String, reflect.Slice, reflect.Map:
+		value = float64(v.Len())
+		isLength = true
+	default:
+		return ""
+	}
+
+	switch {
+	case rule == "gt" && value <= limit:
+		return "must be greater than " + arg
+	case rule == "gte" && value < limit:
+		return "must not be less than " + arg
+	case rule == "min" && value < limit && isLength:
+		return "must have at least " + arg + " entries"
+	case rule == "min" && value < limit:
+		return "must not be less than " + arg
+	case rule == "max" && value > limit && isLength:
+		return "must have at most " + arg + " entries"
+	case rule == "max" && value > limit:
+		return "must not be more than " + arg
+	}
+	return ""
+}
+
+// requiredFields returns the field names listed in a required_fields rule
+func requiredFields(tag string) []string {
+	for _, rule := range strings.Split(tag, ",") {
+		if arg, found := strings.CutPrefix(rule, "required_fields="); found {
+			return strings.Fields(arg)
+		}
+	}
+	return nil
+}
+
+// checkRequiredFields reports the listed fields of a nested struct that are empty
+func checkRequiredFields(v reflect.Value, path string, names []string, errs *[]FieldError) {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return
+	}
+
+	t := v.Type()
+	for _, name := range names {
+		for i := 0; i < t.NumField(); i++ {
+			if jsonFieldName(t.Field(i)) == name && v.Field(i).IsZero() {
+				*errs = append(*errs, FieldError{Field: joinPath(path, name), Reason: "is required"})
+			}
+		}
+	}
+}
+
+// jsonFieldName returns the name a struct field has in JSON
+func jsonFieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+// joinPath appends a field name to a JSON path
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
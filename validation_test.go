@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test that every invalid field is listed with its JSON path and reason
+func TestProcessPaymentValidationDetails(t *testing.T) {
+	app := setupApp()
+
+	status, body := doJSON(t, app, http.MethodPost, "/process-payment", `{
+		"order_id": "order_invalid",
+		"amount": -5,
+		"billing_address": {"customer_id": "cust_invalid", "name": "", "email": "not-an-email", "phone": "0812", "country": "Indonesia"},
+		"fulfillment_type": "drone",
+		"shipping_address": {"name": "Budi", "city": "Jakarta", "country": "ID"}
+	}`)
+	assert.Equal(t, 400, status)
+
+	errBody := body["error"].(map[string]interface{})
+	assert.Equal(t, "InvalidRequest", errBody["code"])
+	assert.Equal(t, "amount", errBody["target"])
+
+	details := map[string]string{}
+	for _, detail := range errBody["details"].([]interface{}) {
+		fieldErr := detail.(map[string]interface{})
+		details[fieldErr["field"].(string)] = fieldErr["reason"].(string)
+	}
+	assert.Equal(t, map[string]string{
+		"amount":                   "must be greater than 0",
+		"billing_address.email":    "must be a valid email address",
+		"billing_address.phone":    "must be an E.164 phone number, e.g. +628123456789",
+		"billing_address.country":  "must be an ISO 3166-1 alpha-2 country code",
+		"fulfillment_type":         "must be one of delivery, pickup, locker",
+		"shipping_address.address": "is required",
+	}, details)
+
+	// Billing fields left out may come from the customer's account, so they
+	// are only required once the saved details are filled in
+	status, body = doJSON(t, app, http.MethodPost, "/process-payment", `{
+		"order_id": "order_invalid", "amount": 100,
+		"billing_address": {"customer_id": "cust_invalid", "email": "budi@example.com", "phone": "+628123456789"}
+	}`)
+	assert.Equal(t, 400, status)
+	errBody = body["error"].(map[string]interface{})
+	assert.Equal(t, "billing_address.name", errBody["target"])
+	assert.Len(t, errBody["details"], 1)
+}
+
+// Test item rules on cart creation
+func TestCreateCartValidation(t *testing.T) {
+	app := setupApp()
+
+	status, body := doJSON(t, app, http.MethodPost, "/create-cart", `{
+		"customer_id": "cust_invalid_cart",
+		"items": [{"item_id": "item001", "quantity": 0}, {"quantity": 1}]
+	}`)
+	assert.Equal(t, 400, status)
+	details := body["error"].(map[string]interface{})["details"].([]interface{})
+	assert.Len(t, details, 2)
+	assert.Equal(t, "items[0].quantity", details[0].(map[string]interface{})["field"])
+	assert.Equal(t, "items[1].item_id", details[1].(map[string]interface{})["field"])
+
+	// At most 50 lines per cart
+	items := strings.Repeat(`{"item_id": "item002", "quantity": 1},`, 51)
+	status, body = doJSON(t, app, http.MethodPost, "/create-cart", `{"customer_id": "cust_invalid_cart", "items": [`+strings.TrimSuffix(items, ",")+`]}`)
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "items", body["error"].(map[string]interface{})["target"])
+}
@@ -0,0 +1,542 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// Headers of outgoing webhook deliveries
+const (
+	webhookIDHeader        = "X-Webhook-ID"        // delivery ID, new on every attempt
+	webhookEventHeader     = "X-Webhook-Event"     // event type
+	webhookTimestampHeader = "X-Webhook-Timestamp" // unix seconds
+	webhookSignatureHeader = "X-Webhook-Signature" // "sha256=" + hex HMAC of "<timestamp>.<body>"
+)
+
+// Deliveries kept per subscription, newest last
+const maxWebhookDeliveries = 100
+
+// Client posting webhook deliveries. A receiver that does not answer in time
+// gets the event again later. Every address it connects to is checked, so
+// neither DNS nor redirects can point it at internal hosts.
+var webhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: 5 * time.Second, Control: checkWebhookDial}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse // a redirect is not a delivery
+	},
+}
+
+// Allows http:// URLs and loopback, link-local and private hosts, for local
+// development against receivers on the same machine (WEBHOOK_ALLOW_INSECURE)
+var allowInsecureWebhooks bool
+
+// Struct to represent a partner endpoint receiving events
+type WebhookSubscription struct {
+	SubscriptionID string    `json:"subscription_id"`
+	TenantID       string    `json:"tenant_id"`
+	URL            string    `json:"url"`
+	EventTypes     []string  `json:"event_types"`
+	Secret         string    `json:"-"` // only returned when the subscription is created
+	CreatedAt      time.Time `json:"created_at"`
+
+	queue *webhookQueue
+}
+
+// Struct to hold the events waiting for delivery to one subscription. Each
+// subscription has its own worker, so a slow or dead receiver only delays
+// its own events.
+type webhookQueue struct {
+	mu      sync.Mutex
+	pending []*queuedWebhook
+	wake    chan struct{}
+	stop    chan struct{}
+}
+
+// Struct to represent an event waiting for delivery or for a retry
+type queuedWebhook struct {
+	event       Event
+	attempts    int
+	nextAttempt time.Time
+}
+
+// Struct to represent one attempt to deliver an event to a subscription
+type WebhookDelivery struct {
+	DeliveryID     string    `json:"delivery_id"`
+	SubscriptionID string    `json:"subscription_id"`
+	EventID        string    `json:"event_id"`
+	EventType      string    `json:"event_type"`
+	Attempt        int       `json:"attempt"`     // attempts at this event so far, redeliveries included
+	Redelivery     bool      `json:"redelivery"`  // requested through the API
+	StatusCode     int       `json:"status_code"` // 0 when the receiver could not be reached
+	Error          string    `json:"error,omitempty"`
+	Success        bool      `json:"success"`
+	DurationMs     int64     `json:"duration_ms"`
+	AttemptedAt    time.Time `json:"attempted_at"`
+
+	event Event // sent again on redelivery
+}
+
+// Request struct for creating a webhook subscription
+type WebhookSubscriptionRequest struct {
+	URL        string   `json:"url" validate:"required,url"`
+	EventTypes []string `json:"event_types" validate:"required,min=1"`
+	Secret     string   `json:"secret" validate:"omitempty,min=16"` // generated when left out
+}
+
+// Webhook subscriptions and their delivery logs. Deliveries are made by the
+// event dispatcher while the API reads them, so access goes through webhooksMu.
+var (
+	webhooksMu           sync.Mutex
+	webhookSubscriptions = make(map[string]*WebhookSubscription)
+	webhookDeliveries    = make(map[string][]*WebhookDelivery) // by subscription ID
+)
+
+// webhookSignature signs a delivery like carriers sign theirs
+func webhookSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func generateWebhookSecret() (string, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(secret), nil
+}
+
+// eventBusName names a subscription's handler on the event bus, which keeps
+// track of the events delivered to it and retries the failed ones
+func (s *WebhookSubscription) eventBusName() string {
+	return "webhook:" + s.SubscriptionID
+}
+
+// handleEvent queues the events of the subscription's tenant for its
+// worker, leaving the outbox dispatcher free for the other subscribers
+func (s *WebhookSubscription) handleEvent(event Event) error {
+	if event.TenantID != s.TenantID {
+		return nil
+	}
+	s.queue.push(&queuedWebhook{event: event})
+	return nil
+}
+
+// start runs the subscription's delivery worker until stop is called
+func (s *WebhookSubscription) start() {
+	s.queue = &webhookQueue{wake: make(chan struct{}, 1), stop: make(chan struct{})}
+	go s.run()
+}
+
+// stop ends the worker; events still queued are dropped with the subscription
+func (s *WebhookSubscription) stop() {
+	close(s.queue.stop)
+}
+
+// run delivers the queued events as they become due. A failed delivery is
+// retried with the outbox's exponential backoff until maxEventDeliveries.
+func (s *WebhookSubscription) run() {
+	for {
+		due, wait := s.queue.take(time.Now())
+		for _, item := range due {
+			if delivery := s.deliver(item.event, false); delivery.Success {
+				continue
+			}
+			item.attempts++
+			if item.attempts >= maxEventDeliveries {
+				log.Error().
+					Str("webhook.subscription_id", s.SubscriptionID).
+					Str("event.id", item.event.ID).
+					Int("webhook.attempt", item.attempts).
+					Msg("Giving up on webhook delivery")
+				continue
+			}
+			item.nextAttempt = time.Now().Add(eventRetryDelay(item.attempts))
+			s.queue.push(item)
+		}
+		if len(due) > 0 {
+			continue
+		}
+
+		// Sleep until the next retry is due or an event is queued
+		timer := time.NewTimer(wait)
+		if wait == 0 {
+			timer.Stop()
+		}
+		select {
+		case <-s.queue.wake:
+		case <-timer.C:
+		case <-s.queue.stop:
+			timer.Stop()
+			return
+		}
+		timer.Stop()
+	}
+}
+
+// push queues an event and wakes the worker
+func (q *webhookQueue) push(item *queuedWebhook) {
+	q.mu.Lock()
+	q.pending = append(q.pending, item)
+	q.mu.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// take removes the events due at now, oldest first. When none are due, it
+// returns how long until the next one is, or 0 when the queue is empty.
+func (q *webhookQueue) take(now time.Time) ([]*queuedWebhook, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var due []*queuedWebhook
+	var wait time.Duration
+	kept := q.pending[:0]
+	for _, item := range q.pending {
+		if !now.Before(item.nextAttempt) {
+			due = append(due, item)
+			continue
+		}
+		if until := item.nextAttempt.Sub(now); wait == 0 || until < wait {
+			wait = until
+		}
+		kept = append(kept, item)
+	}
+	q.pending = kept
+	return due, wait
+}
+
+// checkWebhookURL accepts https URLs whose host is not a loopback, link-local
+// or private address. Host names are checked again when they are resolved.
+func checkWebhookURL(rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Hostname() == "" {
+		return errors.New("must be an absolute URL")
+	}
+	if allowInsecureWebhooks {
+		if parsed.Scheme != "https" && parsed.Scheme != "http" {
+			return errors.New("must be an http or https URL")
+		}
+		return nil
+	}
+	if parsed.Scheme != "https" {
+		return errors.New("must be an https URL")
+	}
+	if ip := net.ParseIP(parsed.Hostname()); ip != nil {
+		return checkWebhookIP(ip)
+	}
+	if strings.EqualFold(parsed.Hostname(), "localhost") {
+		return errors.New("must not be a loopback, link-local or private address")
+	}
+	return nil
+}
+
+// checkWebhookIP rejects the addresses of the host and its internal networks,
+// e.g. 127.0.0.1, 169.254.169.254 (cloud metadata) or 10.0.0.1
+func checkWebhookIP(ip net.IP) error {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() {
+		return errors.New("must not be a loopback, link-local or private address")
+	}
+	return nil
+}
+
+// checkWebhookDial runs before every connection of webhookClient, once the
+// host name has been resolved
+func checkWebhookDial(network, address string, conn syscall.RawConn) error {
+	if allowInsecureWebhooks {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("webhook address %s is not an IP address", address)
+	}
+	if err := checkWebhookIP(ip); err != nil {
+		return fmt.Errorf("webhook address %s %v", address, err)
+	}
+	return nil
+}
+
+// deliver posts a signed event to the subscription URL and logs the attempt
+func (s *WebhookSubscription) deliver(event Event, redelivery bool) *WebhookDelivery {
+	delivery := &WebhookDelivery{
+		DeliveryID:     uuid.New().String(),
+		SubscriptionID: s.SubscriptionID,
+		EventID:        event.ID,
+		EventType:      event.Type,
+		Redelivery:     redelivery,
+		AttemptedAt:    time.Now().UTC(),
+		event:          event,
+	}
+	statusCode, err := s.post(delivery.DeliveryID, event)
+	delivery.StatusCode = statusCode
+	delivery.Success = err == nil
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+	delivery.DurationMs = time.Since(delivery.AttemptedAt).Milliseconds()
+
+	webhooksMu.Lock()
+	for _, previous := range webhookDeliveries[s.SubscriptionID] {
+		if previous.EventID == event.ID {
+			delivery.Attempt = previous.Attempt
+		}
+	}
+	delivery.Attempt++
+	deliveries := append(webhookDeliveries[s.SubscriptionID], delivery)
+	if len(deliveries) > maxWebhookDeliveries {
+		deliveries = deliveries[len(deliveries)-maxWebhookDeliveries:]
+	}
+	webhookDeliveries[s.SubscriptionID] = deliveries
+	webhooksMu.Unlock()
+
+	if err != nil {
+		log.Warn().
+			Str("webhook.subscription_id", s.SubscriptionID).
+			Str("webhook.delivery_id", delivery.DeliveryID).
+			Str("event.id", event.ID).
+			Int("webhook.attempt", delivery.Attempt).
+			Msgf("Webhook delivery failed: %v", err)
+		return delivery
+	}
+	log.Info().Str("event.action", "deliver_webhook").
+		Str("webhook.subscription_id", s.SubscriptionID).
+		Str("webhook.delivery_id", delivery.DeliveryID).
+		Str("event.id", event.ID).
+		Msg("Webhook delivered successfully")
+	return delivery
+}
+
+// post sends one signed delivery and returns the receiver's status code
+func (s *WebhookSubscription) post(deliveryID string, event Event) (int, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	req.Header.Set(fiber.HeaderUserAgent, "order-app-webhooks")
+	req.Header.Set(webhookIDHeader, deliveryID)
+	req.Header.Set(webhookEventHeader, event.Type)
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, webhookSignature(s.Secret, timestamp, body))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// findWebhookSubscription looks up a subscription of the request's tenant
+func findWebhookSubscription(c *fiber.Ctx) (*WebhookSubscription, error) {
+	webhooksMu.Lock()
+	defer webhooksMu.Unlock()
+
+	subscription, exists := webhookSubscriptions[c.Params("id")]
+	if !exists || subscription.TenantID != tenantOf(c).ID {
+		log.Warn().Msgf("Webhook subscription %s not found", c.Params("id"))
+		return nil, ErrWebhookSubscriptionNotFound.WithTarget("id")
+	}
+	return subscription, nil
+}
+
+func CreateWebhookSubscriptionHandler(c *fiber.Ctx) error {
+	var subscriptionReq WebhookSubscriptionRequest
+
+	// Parse JSON input
+	if err := c.BodyParser(&subscriptionReq); err != nil {
+		log.Warn().Msg("Invalid JSON input for /webhooks/subscriptions")
+		return ErrInvalidJSON
+	}
+
+	if err := checkRequest(subscriptionReq); err != nil {
+		return err
+	}
+	if err := checkWebhookURL(subscriptionReq.URL); err != nil {
+		log.Warn().Err(err).Msg("Webhook URL not allowed")
+		return ErrInvalidRequest.WithMessage("One or more fields are invalid").WithTarget("url").WithDetails([]FieldError{
+			{Field: "url", Reason: err.Error()},
+		})
+	}
+	for i, eventType := range subscriptionReq.EventTypes {
+		if !containsString(domainEventTypes, eventType) {
+			target := fmt.Sprintf("event_types[%d]", i)
+			return ErrInvalidRequest.WithMessage("One or more fields are invalid").WithTarget(target).WithDetails([]FieldError{
+				{Field: target, Reason: "must be one of " + strings.Join(domainEventTypes, ", ")},
+			})
+		}
+	}
+
+	secret := subscriptionReq.Secret
+	if secret == "" {
+		var err error
+		if secret, err = generateWebhookSecret(); err != nil {
+			log.Error().Err(err).Msg("Failed to generate webhook secret")
+			return ErrInternalError.WithMessage("Failed to generate webhook secret")
+		}
+	}
+
+	subscription := &WebhookSubscription{
+		SubscriptionID: uuid.New().String(),
+		TenantID:       tenantOf(c).ID,
+		URL:            subscriptionReq.URL,
+		EventTypes:     subscriptionReq.EventTypes,
+		Secret:         secret,
+		CreatedAt:      time.Now().UTC(),
+	}
+	subscription.start()
+	webhooksMu.Lock()
+	webhookSubscriptions[subscription.SubscriptionID] = subscription
+	webhooksMu.Unlock()
+	eventBus.Subscribe(subscription.eventBusName(), subscription.handleEvent, subscription.EventTypes...)
+
+	log.Info().Str("event.action", "create_webhook_subscription").
+		Str("webhook.subscription_id", subscription.SubscriptionID).
+		Strs("webhook.event_types", subscription.EventTypes).
+		Msg("Webhook subscription created successfully")
+
+	return c.Status(201).JSON(fiber.Map{
+		"message":      "Webhook subscription created successfully",
+		"subscription": subscription,
+		"secret":       subscription.Secret,
+	})
+}
+
+func GetWebhookSubscriptionsHandler(c *fiber.Ctx) error {
+	webhooksMu.Lock()
+	subscriptionList := make([]*WebhookSubscription, 0)
+	for _, subscription := range webhookSubscriptions {
+		if subscription.TenantID == tenantOf(c).ID {
+			subscriptionList = append(subscriptionList, subscription)
+		}
+	}
+	webhooksMu.Unlock()
+	sort.Slice(subscriptionList, func(i, j int) bool {
+		return subscriptionList[i].CreatedAt.Before(subscriptionList[j].CreatedAt)
+	})
+
+	log.Info().Msg("Fetching all webhook subscriptions")
+	return c.JSON(fiber.Map{
+		"message":       "All webhook subscriptions retrieved successfully",
+		"subscriptions": subscriptionList,
+	})
+}
+
+func GetWebhookSubscriptionHandler(c *fiber.Ctx) error {
+	subscription, err := findWebhookSubscription(c)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{
+		"message":      "Webhook subscription retrieved successfully",
+		"subscription": subscription,
+	})
+}
+
+func DeleteWebhookSubscriptionHandler(c *fiber.Ctx) error {
+	subscription, err := findWebhookSubscription(c)
+	if err != nil {
+		return err
+	}
+
+	// Pending retries are dropped with the subscription
+	eventBus.Unsubscribe(subscription.eventBusName())
+	subscription.stop()
+	webhooksMu.Lock()
+	delete(webhookSubscriptions, subscription.SubscriptionID)
+	delete(webhookDeliveries, subscription.SubscriptionID)
+	webhooksMu.Unlock()
+
+	log.Info().Str("event.action", "delete_webhook_subscription").
+		Str("webhook.subscription_id", subscription.SubscriptionID).
+		Msg("Webhook subscription deleted successfully")
+
+	return c.JSON(fiber.Map{
+		"message": "Webhook subscription deleted successfully",
+	})
+}
+
+func GetWebhookDeliveriesHandler(c *fiber.Ctx) error {
+	subscription, err := findWebhookSubscription(c)
+	if err != nil {
+		return err
+	}
+
+	webhooksMu.Lock()
+	deliveries := append([]*WebhookDelivery{}, webhookDeliveries[subscription.SubscriptionID]...)
+	webhooksMu.Unlock()
+
+	return c.JSON(fiber.Map{
+		"message":    "Webhook deliveries retrieved successfully",
+		"deliveries": deliveries,
+	})
+}
+
+// RedeliverWebhookHandler sends the event of an earlier delivery again, e.g.
+// once a receiver that was down for longer than the retries is back
+func RedeliverWebhookHandler(c *fiber.Ctx) error {
+	subscription, err := findWebhookSubscription(c)
+	if err != nil {
+		return err
+	}
+
+	var original *WebhookDelivery
+	webhooksMu.Lock()
+	for _, delivery := range webhookDeliveries[subscription.SubscriptionID] {
+		if delivery.DeliveryID == c.Params("delivery_id") {
+			original = delivery
+		}
+	}
+	webhooksMu.Unlock()
+	if original == nil {
+		log.Warn().Msgf("Webhook delivery %s not found", c.Params("delivery_id"))
+		return ErrWebhookDeliveryNotFound.WithTarget("delivery_id")
+	}
+
+	delivery := subscription.deliver(original.event, true)
+	return c.JSON(fiber.Map{
+		"message":  "Webhook redelivered",
+		"delivery": delivery,
+	})
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Struct to represent a request received by the test webhook receiver
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+// webhookReceiver answers deliveries with the given status codes in turn,
+// then 204
+func webhookReceiver(t *testing.T, statuses ...int) (*httptest.Server, func() []receivedWebhook) {
+	var mu sync.Mutex
+	var received []receivedWebhook
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		received = append(received, receivedWebhook{header: r.Header.Clone(), body: body})
+		status := http.StatusNoContent
+		if len(received) <= len(statuses) {
+			status = statuses[len(received)-1]
+		}
+		mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() []receivedWebhook {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]receivedWebhook{}, received...)
+	}
+}
+
+// Test that subscribed events are signed, retried and can be redelivered
+func TestWebhookDeliveries(t *testing.T) {
+	setupTenants(t, []TenantConfig{{ID: "shop_webhooks"}})
+	app := setupApp()
+	server, received := webhookReceiver(t, http.StatusServiceUnavailable)
+	allowInsecureWebhooks = true // the test receiver is on http://127.0.0.1
+	t.Cleanup(func() { allowInsecureWebhooks = false })
+
+	// Only known event types and http(s) URLs are accepted
+	status, body := doTenantJSON(t, app, http.MethodPost, "/v1/webhooks/subscriptions", "shop_webhooks", `{"url": "`+server.URL+`", "event_types": ["order.teleported"]}`)
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "event_types[0]", body["error"].(map[string]interface{})["target"])
+	status, body = doTenantJSON(t, app, http.MethodPost, "/v1/webhooks/subscriptions", "shop_webhooks", `{"url": "ftp://partner.example.com", "event_types": ["order.routed"]}`)
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "url", body["error"].(map[string]interface{})["target"])
+
+	status, body = doTenantJSON(t, app, http.MethodPost, "/v1/webhooks/subscriptions", "shop_webhooks", `{"url": "`+server.URL+`", "event_types": ["order.routed"], "secret": "partner-secret-0001"}`)
+	assert.Equal(t, 201, status)
+	assert.Equal(t, "partner-secret-0001", body["secret"])
+	subscription := body["subscription"].(map[string]interface{})
+	subscriptionPath := "/v1/webhooks/subscriptions/" + subscription["subscription_id"].(string)
+	assert.NotContains(t, subscription, "secret")
+
+	// Subscriptions belong to their tenant
+	status, _ = doJSON(t, app, http.MethodGet, subscriptionPath, "")
+	assert.Equal(t, 404, status)
+
+	status, _ = doTenantJSON(t, app, http.MethodPost, "/v1/carts", "shop_webhooks", `{"customer_id": "cust_webhooks", "items": [{"item_id": "item001", "quantity": 1}, {"item_id": "item002", "quantity": 2}]}`)
+	assert.Equal(t, 200, status)
+	status, _ = doTenantJSON(t, app, http.MethodPost, "/v1/orders", "shop_webhooks", `{"order_id": "order_webhooks", "amount": 1100,
+		"billing_address": {"customer_id": "cust_webhooks", "name": "John Doe", "email": "john@example.com", "phone": "+15555555555", "country": "US"}}`)
+	assert.Equal(t, 200, status)
+	status, _ = doTenantJSON(t, app, http.MethodPost, "/v1/orders/order_webhooks/route", "shop_webhooks", "")
+	assert.Equal(t, 200, status)
+
+	// The receiver is down on the first attempt, which the subscription's
+	// worker retries after the backoff
+	outbox.Dispatch(eventBus, time.Now())
+	assert.Eventually(t, func() bool { return len(received()) == 2 }, 5*time.Second, 10*time.Millisecond)
+	deliveries := received()
+
+	delivered := deliveries[1]
+	assert.Equal(t, EventOrderRouted, delivered.header.Get(webhookEventHeader))
+	assert.Equal(t, webhookSignature("partner-secret-0001", delivered.header.Get(webhookTimestampHeader), delivered.body), delivered.header.Get(webhookSignatureHeader))
+	var event map[string]interface{}
+	assert.NoError(t, json.Unmarshal(delivered.body, &event))
+	assert.Equal(t, "order_webhooks", event["order_id"])
+	assert.Equal(t, "Order Routed", event["status"])
+
+	status, body = doTenantJSON(t, app, http.MethodGet, subscriptionPath+"/deliveries", "shop_webhooks", "")
+	assert.Equal(t, 200, status)
+	log := body["deliveries"].([]interface{})
+	assert.Len(t, log, 2)
+	first := log[0].(map[string]interface{})
+	assert.Equal(t, false, first["success"])
+	assert.Equal(t, 503.0, first["status_code"])
+	assert.Equal(t, 2.0, log[1].(map[string]interface{})["attempt"])
+
+	// Deliveries can be sent again on request
+	status, body = doTenantJSON(t, app, http.MethodPost, subscriptionPath+"/deliveries/"+first["delivery_id"].(string)+"/redeliver", "shop_webhooks", "")
+	assert.Equal(t, 200, status)
+	redelivery := body["delivery"].(map[string]interface{})
+	assert.Equal(t, true, redelivery["redelivery"])
+	assert.Equal(t, true, redelivery["success"])
+	assert.Equal(t, 3.0, redelivery["attempt"])
+	assert.Len(t, received(), 3)
+
+	status, body = doTenantJSON(t, app, http.MethodPost, subscriptionPath+"/deliveries/unknown/redeliver", "shop_webhooks", "")
+	assert.Equal(t, 404, status)
+	assert.Equal(t, "WebhookDeliveryNotFound", errorCode(body))
+
+	status, _ = doTenantJSON(t, app, http.MethodDelete, subscriptionPath, "shop_webhooks", "")
+	assert.Equal(t, 200, status)
+	status, body = doTenantJSON(t, app, http.MethodGet, subscriptionPath, "shop_webhooks", "")
+	assert.Equal(t, 404, status)
+	assert.Equal(t, "WebhookSubscriptionNotFound", errorCode(body))
+}
+
+// Test that a slow receiver does not hold up the dispatcher or other subscriptions
+func TestWebhookSlowReceiver(t *testing.T) {
+	setupTenants(t, []TenantConfig{{ID: "shop_webhooks_slow"}})
+	app := setupApp()
+	allowInsecureWebhooks = true
+	t.Cleanup(func() { allowInsecureWebhooks = false })
+
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(slow.Close)
+	t.Cleanup(func() { close(release) })
+	fast, received := webhookReceiver(t)
+
+	for _, receiverURL := range []string{slow.URL, fast.URL} {
+		status, _ := doTenantJSON(t, app, http.MethodPost, "/v1/webhooks/subscriptions", "shop_webhooks_slow", `{"url": "`+receiverURL+`", "event_types": ["cart.created"]}`)
+		assert.Equal(t, 201, status)
+	}
+	status, _ := doTenantJSON(t, app, http.MethodPost, "/v1/carts", "shop_webhooks_slow", `{"customer_id": "cust_webhooks_slow", "items": [{"item_id": "item001", "quantity": 1}]}`)
+	assert.Equal(t, 200, status)
+
+	start := time.Now()
+	outbox.Dispatch(eventBus, time.Now())
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Eventually(t, func() bool { return len(received()) == 1 }, 5*time.Second, 10*time.Millisecond)
+}
+
+// Test that webhooks cannot be pointed at the host or internal networks
+func TestWebhookURLRestrictions(t *testing.T) {
+	setupTenants(t, []TenantConfig{{ID: "shop_webhooks_ssrf"}})
+	app := setupApp()
+
+	for _, rejected := range []string{
+		"http://partner.example.com/hooks",
+		"https://127.0.0.1:8080/hooks",
+		"https://localhost/hooks",
+		"https://169.254.169.254/latest/meta-data",
+		"https://10.0.0.5/hooks",
+		"https://[::1]/hooks",
+	} {
+		status, body := doTenantJSON(t, app, http.MethodPost, "/v1/webhooks/subscriptions", "shop_webhooks_ssrf", `{"url": "`+rejected+`", "event_types": ["order.routed"]}`)
+		assert.Equal(t, 400, status, rejected)
+		assert.Equal(t, "url", body["error"].(map[string]interface{})["target"], rejected)
+	}
+
+	// Host names are checked once resolved, on every connection
+	status, _ := doTenantJSON(t, app, http.MethodPost, "/v1/webhooks/subscriptions", "shop_webhooks_ssrf", `{"url": "https://partner.example.com/hooks", "event_types": ["order.routed"]}`)
+	assert.Equal(t, 201, status)
+	assert.Error(t, checkWebhookDial("tcp", "127.0.0.1:443", nil))
+	assert.Error(t, checkWebhookDial("tcp", "[fe80::1]:443", nil))
+	assert.NoError(t, checkWebhookDial("tcp", "93.184.216.34:443", nil))
+}